@@ -0,0 +1,61 @@
+// Command migrate applies pending MongoDB migrations, or lists them with
+// -status. It reads MONGO_URI and MONGO_DB like the server does.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"bookstore/internal/db"
+	"bookstore/internal/migrate"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	status := flag.Bool("status", false, "list applied and pending migrations without running anything")
+	timeout := flag.Duration("timeout", 10*time.Minute, "give up after this long")
+	flag.Parse()
+
+	client, mongoDB, err := db.Connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Disconnect(db.Bg())
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if *status {
+		applied, err := migrate.Applied(ctx, mongoDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pending, err := migrate.Pending(ctx, mongoDB, migrate.All)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, r := range applied {
+			fmt.Printf("applied  %3d  %s  (%s)\n", r.Version, r.Description, r.AppliedAt.Format(time.RFC3339))
+		}
+		for _, m := range pending {
+			fmt.Printf("pending  %3d  %s\n", m.Version, m.Description)
+		}
+		return
+	}
+
+	ran, err := migrate.Run(ctx, mongoDB, migrate.All)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ran) == 0 {
+		fmt.Println("database is up to date")
+		return
+	}
+	fmt.Printf("applied %d migration(s)\n", len(ran))
+}
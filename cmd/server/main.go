@@ -22,8 +22,10 @@ func main() {
 	}
 	defer client.Disconnect(db.Bg())
 
-	bookRepo := repository.NewBookRepo(mongoDB)
-	bookService := logic.NewBookService(bookRepo)
+	ids := repository.CounterIDs(mongoDB)
+	bookRepo := repository.NewBookRepo(mongoDB, ids)
+	inventoryService := logic.NewInventoryService(repository.NewInventoryRepo(mongoDB, ids), repository.NewMongoTransactions(mongoDB), 0)
+	bookService := logic.NewBookService(bookRepo, inventoryService)
 	bookHandler := handlers.NewBookHandler(bookService)
 
 	mux := http.NewServeMux()
@@ -0,0 +1,109 @@
+// Package apperr defines the error kinds shared by repositories, services and
+// handlers. Code that fails for a reason the client can act on returns an
+// *Error of one of these kinds; anything else is treated as an internal
+// failure and is not shown to the client.
+package apperr
+
+import "errors"
+
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrForbidden    = errors.New("forbidden")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrOutOfStock   = errors.New("out of stock")
+	ErrLocked       = errors.New("locked")
+	ErrRateLimited  = errors.New("too many requests")
+)
+
+// ErrStale is the cause behind a conflict from a write that carried an
+// out-of-date version. Handlers look for it to answer a failed If-Match
+// with 412 rather than 409.
+var ErrStale = errors.New("stale version")
+
+// Error is a client-facing failure. Message is safe to return as is. Fields
+// maps request fields to what is wrong with them and is only set for
+// validation errors.
+type Error struct {
+	Kind    error
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is makes errors.Is(err, ErrNotFound) and friends match any *Error of that
+// kind, in addition to matching the cause.
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+func NotFound(msg string) *Error { return &Error{Kind: ErrNotFound, Message: msg} }
+
+func Conflict(msg string) *Error { return &Error{Kind: ErrConflict, Message: msg} }
+
+// Stale reports a write that lost an optimistic concurrency check.
+func Stale(msg string) *Error { return Conflict(msg).Wrap(ErrStale) }
+
+func OutOfStock(msg string) *Error { return &Error{Kind: ErrOutOfStock, Message: msg} }
+
+func Forbidden(msg string) *Error { return &Error{Kind: ErrForbidden, Message: msg} }
+
+func Unauthorized(msg string) *Error { return &Error{Kind: ErrUnauthorized, Message: msg} }
+
+func Locked(msg string) *Error { return &Error{Kind: ErrLocked, Message: msg} }
+
+func RateLimited(msg string) *Error { return &Error{Kind: ErrRateLimited, Message: msg} }
+
+// Invalid reports a validation failure. field names the offending request
+// field and may be empty when the problem is not tied to one.
+func Invalid(field, msg string) *Error {
+	e := &Error{Kind: ErrValidation, Message: msg}
+	if field != "" {
+		e.Fields = map[string]string{field: msg}
+	}
+	return e
+}
+
+// Wrap attaches a cause to e so the original error stays reachable through
+// errors.Is and errors.As while the client only sees e's message.
+func (e *Error) Wrap(cause error) *Error {
+	out := *e
+	out.Err = cause
+	return &out
+}
+
+// As returns err's *Error if it carries one.
+func As(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// Code is the machine-readable name for err's kind, as sent to clients.
+func Code(err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrValidation):
+		return "validation_failed"
+	case errors.Is(err, ErrForbidden):
+		return "forbidden"
+	case errors.Is(err, ErrUnauthorized):
+		return "unauthorized"
+	case errors.Is(err, ErrOutOfStock):
+		return "out_of_stock"
+	case errors.Is(err, ErrLocked):
+		return "locked"
+	case errors.Is(err, ErrRateLimited):
+		return "too_many_requests"
+	default:
+		return "internal"
+	}
+}
@@ -24,6 +24,12 @@ func Connect() (*mongo.Client, *mongo.Database, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	// Connect is lazy; without a ping a wrong URI only shows up on the
+	// first request.
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, nil, err
+	}
 
 	return client, client.Database(dbName), nil
 }
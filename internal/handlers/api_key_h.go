@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/models"
+)
+
+type APIKeyHandler struct {
+	service *logic.APIKeyService
+}
+
+func NewAPIKeyHandler(service *logic.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{service: service}
+}
+
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	writeJSON(w, http.StatusOK, h.service.List(r.Context(), userID))
+}
+
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	p, ok := middleware.FromRequest(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	// A key would outlive the reduced session, so the second factor has to
+	// be done before one can be minted.
+	if p.MFAPending {
+		writeErrorStatus(w, http.StatusForbidden, "two-factor authentication required")
+		return
+	}
+
+	var in struct {
+		Name          string              `json:"name"`
+		Scopes        []models.Permission `json:"scopes"`
+		ExpiresInDays int                 `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	ttl := time.Duration(in.ExpiresInDays) * 24 * time.Hour
+	key, err := h.service.Create(r.Context(), p.UserID, in.Name, in.Scopes, ttl)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, key)
+}
+
+func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	if err := h.service.Revoke(r.Context(), userID, id); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "api key revoked"})
+}
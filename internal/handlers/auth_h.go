@@ -1,18 +1,322 @@
 package handlers
 
-
 import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
 )
 
-func Login(w http.ResponseWriter, r *http.Request) {
-	if logic.Login("test@mail.com", "1234") {
-		w.Write([]byte("Login successful"))
-	} else {
-		w.WriteHeader(http.StatusUnauthorized)
-		_, _ = w.Write([]byte("Unauthorized"))
+type AuthHandler struct {
+	auth    *logic.AuthService
+	account *logic.AccountService
+}
+
+func NewAuthHandler(auth *logic.AuthService, account *logic.AccountService) *AuthHandler {
+	return &AuthHandler{auth: auth, account: account}
+}
+
+type credentialsInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
+	var in credentialsInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if err := h.auth.Register(r.Context(), in.Email, in.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := h.account.SendVerificationEmailTo(r.Context(), in.Email); err != nil {
+		log.Printf("[AUTH] verification email failed: %v\n", err)
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"message": "registered"})
+}
+
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var in credentialsInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	pair, err := h.auth.Login(r.Context(), in.Email, in.Password, middleware.ClientIP(r))
+	if challenge := logic.MFAChallenge(err); challenge != "" {
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"mfaRequired": true,
+			"mfaToken":    challenge,
+			"expiresIn":   int(logic.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+	if d := logic.RetryAfter(err); d > 0 {
+		setRetryAfter(w, d)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pair)
+}
+
+func (h *AuthHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		MFAToken string `json:"mfaToken"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	pair, err := h.auth.CompleteMFA(r.Context(), in.MFAToken, in.Code, middleware.ClientIP(r))
+	if d := logic.RetryAfter(err); d > 0 {
+		setRetryAfter(w, d)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pair)
+}
+
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	pair, err := h.auth.Refresh(r.Context(), in.RefreshToken)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pair)
+}
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	p, ok := middleware.FromRequest(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var in struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+	}
+
+	claims := logic.AccessClaims{UserID: p.UserID, JTI: p.TokenID, ExpiresAt: p.ExpiresAt}
+	if err := h.auth.Logout(r.Context(), claims, in.RefreshToken); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "logged out"})
+}
+
+func (h *AuthHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if err := h.auth.LogoutAll(r.Context(), userID); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "logged out of all devices"})
+}
+
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	err := h.account.VerifyEmail(r.Context(), in.Token)
+	if errors.Is(err, logic.ErrInvalidToken) {
+		writeErrorStatus(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "email verified"})
+}
+
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if err := h.account.SendVerificationEmail(r.Context(), userID); err != nil {
+		writeErrorStatus(w, http.StatusInternalServerError, "could not send email")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "verification email sent"})
+}
+
+func (h *AuthHandler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if err := h.account.RequestPasswordReset(r.Context(), in.Email); err != nil {
+		log.Printf("[AUTH] password reset email failed: %v\n", err)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"message": "if the account exists, a reset link has been sent"})
+}
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
 	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if err := h.account.ResetPassword(r.Context(), in.Token, in.Password); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "password updated"})
 }
 
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+func (h *AuthHandler) TOTPSetup(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	setup, err := h.auth.BeginTOTPSetup(r.Context(), userID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, setup)
+}
+
+func (h *AuthHandler) TOTPEnable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	codes, pair, err := h.auth.EnableTOTP(r.Context(), userID, in.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"recoveryCodes": codes,
+		"tokens":        pair,
+	})
+}
+
+func (h *AuthHandler) TOTPDisable(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var in struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	if err := h.auth.DisableTOTP(r.Context(), userID, in.Password, in.Code); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "two-factor authentication disabled"})
+}
+
+func (h *AuthHandler) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var in struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	codes, err := h.auth.RegenerateRecoveryCodes(r.Context(), userID, in.Code)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
+
+func (h *AuthHandler) ResetTOTP(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	if err := h.auth.ResetTOTP(r.Context(), id); err != nil {
+		writeError(w, err)
+		return
+	}
+	actorID, _ := middleware.UserID(r)
+	log.Printf("[AUTH] two-factor reset: userId=%d by=%d\n", id, actorID)
+	writeJSON(w, http.StatusOK, map[string]string{"message": "two-factor authentication reset"})
+}
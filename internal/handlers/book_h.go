@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
 	"bookstore/internal/models"
 )
 
@@ -19,93 +20,102 @@ func NewBookHandler(service *logic.BookService) *BookHandler {
 }
 
 func (h *BookHandler) Books(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	switch r.Method {
 	case http.MethodGet:
 		q := parseBookQuery(r)
 		books, err := h.service.ListBooks(r.Context(), q)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+			writeError(w, err)
 			return
 		}
-		_ = json.NewEncoder(w).Encode(books)
+		writeJSON(w, http.StatusOK, books)
 
 	case http.MethodPost:
 		var b models.Book
 		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid json")
 			return
 		}
 
-		created, err := h.service.CreateBook(b)
+		created, err := h.service.CreateBook(r.Context(), b)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			writeError(w, err)
 			return
 		}
 
-		w.WriteHeader(http.StatusCreated)
-		_ = json.NewEncoder(w).Encode(created)
+		setETag(w, created.Version)
+		writeJSON(w, http.StatusCreated, created)
 
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *BookHandler) BookByID(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		b, err := h.service.GetBook(id)
+		b, err := h.service.GetBook(r.Context(), id)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			writeError(w, err)
 			return
 		}
-		_ = json.NewEncoder(w).Encode(b)
+		setETag(w, b.Version)
+		writeJSON(w, http.StatusOK, b)
 
 	case http.MethodPut:
 		var b models.Book
 		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid json"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid json")
 			return
 		}
 
 		b.ID = id
-		if err := h.service.UpdateBook(b); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		if v, ok := ifMatch(r); ok {
+			b.Version = v
+		}
+
+		updated, err := h.service.UpdateBook(r.Context(), b)
+		if err != nil {
+			writeUpdateError(w, r, err)
 			return
 		}
 
-		_ = json.NewEncoder(w).Encode(b)
+		setETag(w, updated.Version)
+		writeJSON(w, http.StatusOK, updated)
 
 	case http.MethodDelete:
-		if err := h.service.DeleteBook(id); err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		actorID, _ := middleware.UserID(r)
+		if err := h.service.DeleteBook(r.Context(), id, actorID); err != nil {
+			writeError(w, err)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_ = json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *BookHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	b, err := h.service.RestoreBook(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
 	}
+	writeJSON(w, http.StatusOK, b)
 }
 
 func parseBookQuery(r *http.Request) models.BookQuery {
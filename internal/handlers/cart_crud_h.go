@@ -22,19 +22,17 @@ func NewCartHandler(service *logic.CartCRUDService) *CartHandler {
 func (h *CartHandler) Carts(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	role := middleware.Role(r)
-
 	switch r.Method {
 	case http.MethodGet:
-		if role == "admin" {
-			writeJSON(w, http.StatusOK, h.service.ListCarts())
+		if middleware.Can(r, models.PermCartsRead) {
+			writeJSON(w, http.StatusOK, h.service.ListCarts(r.Context()))
 			return
 		}
 
-		all := h.service.ListCarts()
+		all := h.service.ListCarts(r.Context())
 		out := make([]models.Cart, 0)
 		for _, c := range all {
 			if c.CustomerID == userID {
@@ -44,37 +42,44 @@ func (h *CartHandler) Carts(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, out)
 
 	case http.MethodPost:
-		c := h.service.CreateCart(userID)
+		c, err := h.service.CreateCart(r.Context(), userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 		writeJSON(w, http.StatusCreated, c)
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *CartHandler) CartByID(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	role := middleware.Role(r)
-
 	idStr := strings.TrimPrefix(r.URL.Path, "/carts/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 
-	c, items, err := h.service.GetCart(id)
+	c, items, err := h.service.GetCart(r.Context(), id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
 
-	if role != "admin" && c.CustomerID != userID {
-		writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+	owner := c.CustomerID == userID
+	if !owner && !middleware.Can(r, models.PermCartsRead) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	if r.Method != http.MethodGet && !owner && !middleware.Can(r, models.PermCartsWrite) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
 		return
 	}
 
@@ -85,41 +90,39 @@ func (h *CartHandler) CartByID(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPut:
 		var in models.Cart
 		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
 			return
 		}
 		in.ID = id
 
-		if role != "admin" {
+		if !middleware.Can(r, models.PermCartsWrite) {
 			in.CustomerID = userID
 		}
 
-		if err := h.service.UpdateCart(in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		if _, err := h.service.UpdateCart(r.Context(), in); err != nil {
+			writeError(w, err)
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "updated"})
 
 	case http.MethodDelete:
-		if err := h.service.DeleteCart(id); err != nil {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		if err := h.service.DeleteCart(r.Context(), id); err != nil {
+			writeError(w, err)
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "deleted"})
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *CartHandler) CartItems(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	role := middleware.Role(r)
-
 	path := strings.TrimPrefix(r.URL.Path, "/carts/")
 	parts := strings.Split(path, "/")
 	if len(parts) < 2 || parts[1] != "items" {
@@ -129,17 +132,17 @@ func (h *CartHandler) CartItems(w http.ResponseWriter, r *http.Request) {
 
 	cartID, err := strconv.Atoi(parts[0])
 	if err != nil || cartID <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cart id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid cart id")
 		return
 	}
 
-	c, _, err := h.service.GetCart(cartID)
+	c, _, err := h.service.GetCart(r.Context(), cartID)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
-	if role != "admin" && c.CustomerID != userID {
-		writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+	if c.CustomerID != userID && !middleware.Can(r, models.PermCartsWrite) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
 		return
 	}
 
@@ -150,30 +153,28 @@ func (h *CartHandler) CartItems(w http.ResponseWriter, r *http.Request) {
 			Qty    int `json:"qty"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
 			return
 		}
 
-		item, err := h.service.AddItem(cartID, in.BookID, in.Qty)
+		item, err := h.service.AddItem(r.Context(), cartID, in.BookID, in.Qty)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			writeError(w, err)
 			return
 		}
 		writeJSON(w, http.StatusCreated, item)
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *CartHandler) CartItemByID(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	role := middleware.Role(r)
-
 	path := strings.TrimPrefix(r.URL.Path, "/carts/")
 	parts := strings.Split(path, "/")
 	if len(parts) < 3 || parts[1] != "items" {
@@ -183,23 +184,23 @@ func (h *CartHandler) CartItemByID(w http.ResponseWriter, r *http.Request) {
 
 	cartID, err := strconv.Atoi(parts[0])
 	if err != nil || cartID <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cart id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid cart id")
 		return
 	}
 
 	itemID, err := strconv.Atoi(parts[2])
 	if err != nil || itemID <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid item id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid item id")
 		return
 	}
 
-	c, _, err := h.service.GetCart(cartID)
+	c, _, err := h.service.GetCart(r.Context(), cartID)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
-	if role != "admin" && c.CustomerID != userID {
-		writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+	if c.CustomerID != userID && !middleware.Can(r, models.PermCartsWrite) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
 		return
 	}
 
@@ -209,23 +210,23 @@ func (h *CartHandler) CartItemByID(w http.ResponseWriter, r *http.Request) {
 			Qty int `json:"qty"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
 			return
 		}
-		if err := h.service.UpdateItem(cartID, itemID, in.Qty); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		if err := h.service.UpdateItem(r.Context(), cartID, itemID, in.Qty); err != nil {
+			writeError(w, err)
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "updated"})
 
 	case http.MethodDelete:
-		if err := h.service.DeleteItem(cartID, itemID); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		if err := h.service.DeleteItem(r.Context(), cartID, itemID); err != nil {
+			writeError(w, err)
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "deleted"})
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
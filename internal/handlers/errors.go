@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"bookstore/internal/apperr"
+)
+
+// errorBody is the JSON shape of every API error response.
+type errorBody struct {
+	Error  string            `json:"error"`
+	Code   string            `json:"code"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+var statusByCode = map[string]int{
+	"not_found":         http.StatusNotFound,
+	"conflict":          http.StatusConflict,
+	"validation_failed": http.StatusBadRequest,
+	"forbidden":         http.StatusForbidden,
+	"unauthorized":      http.StatusUnauthorized,
+	"out_of_stock":      http.StatusConflict,
+	"locked":            http.StatusLocked,
+	"too_many_requests": http.StatusTooManyRequests,
+}
+
+// writeError translates err into a status code and error body. Errors that
+// are not an *apperr.Error are logged and reported as a bare 500 so driver
+// messages never reach the client.
+func writeError(w http.ResponseWriter, err error) {
+	e, ok := apperr.As(err)
+	if !ok {
+		log.Printf("[HTTP] internal error: %v\n", err)
+		writeErrorStatus(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	code := apperr.Code(e)
+	status, ok := statusByCode[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, errorBody{Error: e.Message, Code: code, Fields: e.Fields})
+}
+
+// writeErrorStatus writes an error that has no domain error behind it, such
+// as a malformed body or a missing session.
+func writeErrorStatus(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorBody{Error: msg, Code: codeForStatus(status)})
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusLocked:
+		return "locked"
+	case http.StatusPreconditionFailed:
+		return "precondition_failed"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	default:
+		return "internal"
+	}
+}
+
+// WriteError lets middleware report a domain error in the API's error shape.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	writeError(w, err)
+}
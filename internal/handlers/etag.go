@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bookstore/internal/apperr"
+)
+
+// Books and orders are served with a strong ETag made from their version. A
+// PUT that sends If-Match only applies while the resource still has that
+// version and is answered with 412 Precondition Failed otherwise. A PUT
+// without If-Match may carry the version in its body instead, and a stale
+// one gets the usual 409.
+
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", `"`+strconv.Itoa(version)+`"`)
+}
+
+// ifMatch reads the version named by the request's If-Match header. ok is
+// false when there is no header. "*" matches any version and yields 0. Tags
+// we never hand out, weak ones included, yield -1, which no stored version
+// has, so the write fails its precondition.
+func ifMatch(r *http.Request) (version int, ok bool) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		return 0, false
+	}
+	if h == "*" {
+		return 0, true
+	}
+	unquoted, found := strings.CutPrefix(h, `"`)
+	unquoted, closed := strings.CutSuffix(unquoted, `"`)
+	v, err := strconv.Atoi(unquoted)
+	if !found || !closed || err != nil || v <= 0 {
+		return -1, true
+	}
+	return v, true
+}
+
+// writeUpdateError is writeError for conditional writes: a stale version is
+// a failed precondition when the client asked with If-Match.
+func writeUpdateError(w http.ResponseWriter, r *http.Request, err error) {
+	if _, conditional := ifMatch(r); conditional && errors.Is(err, apperr.ErrStale) {
+		writeErrorStatus(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	writeError(w, err)
+}
@@ -1,30 +1,36 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
 	"bookstore/internal/models"
-
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type FrontendHandler struct {
 	tpls      map[string]*template.Template
 	books     *logic.BookService
 	auth      *logic.AuthService
+	account   *logic.AccountService
 	cart      *logic.CartCRUDService
 	orderSvc  *logic.OrderService
 	orderCRUD *logic.OrderCRUDService
 	wishlist  *logic.WishlistService
-
-	secret []byte
+	addresses *logic.AddressService
+	payments  *logic.PaymentService
+	refunds   *logic.RefundService
 }
 
 func parsePage(base string, page string) (*template.Template, error) {
@@ -37,16 +43,15 @@ func parsePage(base string, page string) (*template.Template, error) {
 func NewFrontendHandler(
 	books *logic.BookService,
 	auth *logic.AuthService,
+	account *logic.AccountService,
 	cart *logic.CartCRUDService,
 	orderSvc *logic.OrderService,
 	orderCRUD *logic.OrderCRUDService,
 	wishlist *logic.WishlistService,
-	secret string,
+	addresses *logic.AddressService,
+	payments *logic.PaymentService,
+	refunds *logic.RefundService,
 ) (*FrontendHandler, error) {
-	if secret == "" {
-		return nil, errors.New("JWT secret empty")
-	}
-
 	pages := map[string]string{
 		"home":          "home.html",
 		"catalog":       "catalog.html",
@@ -58,6 +63,12 @@ func NewFrontendHandler(
 		"order_details": "order_details.html",
 		"wishlists":     "wishlists.html",
 		"create_book":   "create_book.html",
+		"verify_email":  "verify_email.html",
+		"reset":         "reset_password.html",
+		"error":         "error.html",
+		"login_2fa":     "login_2fa.html",
+		"security":      "security.html",
+		"profile":       "profile.html",
 	}
 
 	tpls := make(map[string]*template.Template, len(pages))
@@ -73,15 +84,24 @@ func NewFrontendHandler(
 		tpls:      tpls,
 		books:     books,
 		auth:      auth,
+		account:   account,
 		cart:      cart,
 		orderSvc:  orderSvc,
 		orderCRUD: orderCRUD,
 		wishlist:  wishlist,
-		secret:    []byte(secret),
+		addresses: addresses,
+		payments:  payments,
+		refunds:   refunds,
 	}, nil
 }
 
 func (h *FrontendHandler) render(w http.ResponseWriter, pageKey string, data any) {
+	h.renderStatus(w, http.StatusOK, pageKey, data)
+}
+
+// renderStatus renders a page with a status other than 200, typically a
+// form shown again with what went wrong.
+func (h *FrontendHandler) renderStatus(w http.ResponseWriter, status int, pageKey string, data any) {
 	t, ok := h.tpls[pageKey]
 	if !ok {
 		http.Error(w, "template not found: "+pageKey, http.StatusInternalServerError)
@@ -89,12 +109,28 @@ func (h *FrontendHandler) render(w http.ResponseWriter, pageKey string, data any
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
 	if err := t.ExecuteTemplate(w, "base", data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// pageError is the status and message a page shows for err. Only domain
+// errors carry a message fit for the customer; anything else is logged.
+func pageError(err error) (int, string) {
+	e, ok := apperr.As(err)
+	if !ok {
+		log.Printf("[FRONTEND] internal error: %v\n", err)
+		return http.StatusInternalServerError, "Something went wrong. Please try again."
+	}
+	status, ok := statusByCode[apperr.Code(e)]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	return status, e.Message
+}
+
 func greetingByHour() string {
 	hh := time.Now().Hour()
 	switch {
@@ -109,67 +145,148 @@ func greetingByHour() string {
 	}
 }
 
-func (h *FrontendHandler) setTokenCookie(w http.ResponseWriter, token string) {
+const (
+	TokenCookie   = "token"
+	refreshCookie = "refresh_token"
+)
+
+func (h *FrontendHandler) setTokenCookies(w http.ResponseWriter, pair logic.TokenPair) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    token,
+		Name:     TokenCookie,
+		Value:    pair.AccessToken,
 		Path:     "/",
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(h.auth.AccessTTL()),
 	})
-}
-
-func (h *FrontendHandler) clearTokenCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
+		Name:     refreshCookie,
+		Value:    pair.RefreshToken,
 		Path:     "/",
 		HttpOnly: true,
-		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(h.auth.RefreshTTL()),
 	})
 }
 
+func (h *FrontendHandler) clearTokenCookies(w http.ResponseWriter) {
+	for _, name := range []string{TokenCookie, refreshCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			HttpOnly: true,
+			MaxAge:   -1,
+		})
+	}
+}
+
 func (h *FrontendHandler) currentUser(r *http.Request) (userID int, role string, ok bool) {
-	c, err := r.Cookie("token")
-	if err != nil || c.Value == "" {
+	p, ok := middleware.FromRequest(r)
+	if !ok {
 		return 0, "", false
 	}
+	return p.UserID, p.Role, true
+}
 
-	tok, err := jwt.Parse(c.Value, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
+func (h *FrontendHandler) WithSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := middleware.FromRequest(r); ok {
+			next(w, r)
+			return
 		}
-		return h.secret, nil
-	})
-	if err != nil || tok == nil || !tok.Valid {
-		return 0, "", false
-	}
 
-	claims, ok := tok.Claims.(jwt.MapClaims)
-	if !ok {
-		return 0, "", false
-	}
+		c, err := r.Cookie(refreshCookie)
+		if err != nil || c.Value == "" {
+			next(w, r)
+			return
+		}
 
-	idf, ok := claims["userId"].(float64)
-	if !ok {
-		return 0, "", false
+		pair, err := h.auth.Refresh(r.Context(), c.Value)
+		if err != nil {
+			h.clearTokenCookies(w)
+			next(w, withCookie(r, TokenCookie, ""))
+			return
+		}
+		h.setTokenCookies(w, pair)
+
+		r = withCookie(r, TokenCookie, pair.AccessToken)
+		if p, err := middleware.Cookie(h.auth, TokenCookie).Authenticate(r); err == nil {
+			r = middleware.WithPrincipal(r, p)
+		}
+		next(w, r)
 	}
+}
 
-	roleStr, _ := claims["role"].(string)
-	return int(idf), roleStr, true
+func withCookie(r *http.Request, name, value string) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Header.Del("Cookie")
+	for _, c := range r.Cookies() {
+		if c.Name != name {
+			r2.AddCookie(c)
+		}
+	}
+	if value != "" {
+		r2.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	return r2
 }
 
 func (h *FrontendHandler) baseData(r *http.Request, active string) map[string]any {
 	_, role, ok := h.currentUser(r)
 	return map[string]any{
-		"Greeting": greetingByHour(),
-		"IsAuth":   ok,
-		"Role":     role,
-		"Active":   active,
+		"Greeting":       greetingByHour(),
+		"IsAuth":         ok,
+		"Role":           role,
+		"CanManageBooks": middleware.Can(r, models.PermBooksWrite),
+		"Active":         active,
+		"CSRFToken":      middleware.CSRFToken(r),
+		"MFAPending":     mfaPending(r),
 	}
 }
 
+func mfaPending(r *http.Request) bool {
+	p, ok := middleware.FromRequest(r)
+	return ok && p.MFAPending
+}
+
+// IdempotencyFailure handles a checkout form submitted again while the first
+// submission is still being processed, or with a reused token. Either way
+// the order, if any, is on the orders page.
+func (h *FrontendHandler) IdempotencyFailure(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("[FRONTEND] repeated submission: %s %s: %v\n", r.Method, r.URL.Path, err)
+	http.Redirect(w, r, "/orders", http.StatusSeeOther)
+}
+
+func (h *FrontendHandler) CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[FRONTEND] csrf check failed: %s %s\n", r.Method, r.URL.Path)
+
+	back := "/"
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && ref.Path != "" {
+		back = ref.RequestURI()
+	}
+
+	data := h.baseData(r, "")
+	data["Title"] = "Session expired"
+	data["Heading"] = "This form has expired"
+	data["Message"] = "For your security we couldn't accept that submission. Go back, reload the page and try again."
+	data["Back"] = back
+
+	h.renderStatus(w, http.StatusForbidden, "error", data)
+}
+
+// renderError shows err on the error page with the status the API would
+// answer it with.
+func (h *FrontendHandler) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	status, msg := pageError(err)
+	data := h.baseData(r, "")
+	data["Title"] = http.StatusText(status)
+	data["Heading"] = http.StatusText(status)
+	data["Message"] = msg
+	data["Back"] = "/"
+	h.renderStatus(w, status, "error", data)
+}
+
 func (h *FrontendHandler) requireAuth(w http.ResponseWriter, r *http.Request) (int, bool) {
 	userID, _, ok := h.currentUser(r)
 	if !ok {
@@ -179,21 +296,21 @@ func (h *FrontendHandler) requireAuth(w http.ResponseWriter, r *http.Request) (i
 	return userID, true
 }
 
-func (h *FrontendHandler) requireAdmin(w http.ResponseWriter, r *http.Request) (int, bool) {
-	userID, role, ok := h.currentUser(r)
+func (h *FrontendHandler) requirePermission(w http.ResponseWriter, r *http.Request, perm models.Permission) (int, bool) {
+	userID, _, ok := h.currentUser(r)
 	if !ok {
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 		return 0, false
 	}
-	if role != "admin" {
-		http.Error(w, "forbidden (admin only)", http.StatusForbidden)
+	if !middleware.Can(r, perm) {
+		h.renderError(w, r, apperr.Forbidden("You need the "+string(perm)+" permission to open this page."))
 		return 0, false
 	}
 	return userID, true
 }
 
-func (h *FrontendHandler) ensureUserCart(userID int) (models.Cart, []models.CartItem) {
-	all := h.cart.ListCarts()
+func (h *FrontendHandler) ensureUserCart(ctx context.Context, userID int) (models.Cart, []models.CartItem) {
+	all := h.cart.ListCarts(ctx)
 	var found models.Cart
 	for _, c := range all {
 		if c.CustomerID == userID {
@@ -202,9 +319,14 @@ func (h *FrontendHandler) ensureUserCart(userID int) (models.Cart, []models.Cart
 		}
 	}
 	if found.ID == 0 {
-		found = h.cart.CreateCart(userID)
+		c, err := h.cart.CreateCart(ctx, userID)
+		if err != nil {
+			log.Printf("[FRONTEND] create cart failed: userId=%d err=%v\n", userID, err)
+			return models.Cart{}, []models.CartItem{}
+		}
+		found = c
 	}
-	c, items, err := h.cart.GetCart(found.ID)
+	c, items, err := h.cart.GetCart(ctx, found.ID)
 	if err != nil {
 		return found, []models.CartItem{}
 	}
@@ -283,6 +405,9 @@ func (h *FrontendHandler) About(w http.ResponseWriter, r *http.Request) {
 func (h *FrontendHandler) Login(w http.ResponseWriter, r *http.Request) {
 	data := h.baseData(r, "login")
 	data["Title"] = "Login"
+	if r.URL.Query().Get("reset") == "1" {
+		data["Notice"] = "Your password was changed. Log in with the new one."
+	}
 	h.render(w, "login", data)
 }
 
@@ -291,16 +416,62 @@ func (h *FrontendHandler) LoginPost(w http.ResponseWriter, r *http.Request) {
 	email := strings.TrimSpace(r.FormValue("email"))
 	pass := r.FormValue("password")
 
-	token, err := h.auth.Login(email, pass)
+	pair, err := h.auth.Login(r.Context(), email, pass, middleware.ClientIP(r))
+	if challenge := logic.MFAChallenge(err); challenge != "" {
+		data := h.baseData(r, "login")
+		data["Title"] = "Two-factor authentication"
+		data["MFAToken"] = challenge
+		h.render(w, "login_2fa", data)
+		return
+	}
 	if err != nil {
 		data := h.baseData(r, "login")
 		data["Title"] = "Login"
 		data["Error"] = "Invalid email or password"
+		status := http.StatusOK
+		if d := logic.RetryAfter(err); d > 0 {
+			setRetryAfter(w, d)
+			status, _ = pageError(err)
+			data["Error"] = fmt.Sprintf("Too many failed attempts. Try again in %s.", humanizeWait(d))
+		}
+		h.renderStatus(w, status, "login", data)
+		return
+	}
+
+	h.setTokenCookies(w, pair)
+	middleware.ClearCSRF(w)
+	http.Redirect(w, r, "/catalog", http.StatusSeeOther)
+}
+
+func (h *FrontendHandler) LoginMFAPost(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	challenge := r.FormValue("mfa_token")
+
+	pair, err := h.auth.CompleteMFA(r.Context(), challenge, r.FormValue("code"), middleware.ClientIP(r))
+	if errors.Is(err, logic.ErrInvalidToken) {
+		data := h.baseData(r, "login")
+		data["Title"] = "Login"
+		data["Error"] = "Your sign-in took too long. Please log in again."
 		h.render(w, "login", data)
 		return
 	}
+	if err != nil {
+		data := h.baseData(r, "login")
+		data["Title"] = "Two-factor authentication"
+		data["MFAToken"] = challenge
+		data["Error"] = "That code didn't work. Try again."
+		status := http.StatusOK
+		if d := logic.RetryAfter(err); d > 0 {
+			setRetryAfter(w, d)
+			status, _ = pageError(err)
+			data["Error"] = fmt.Sprintf("Too many failed attempts. Try again in %s.", humanizeWait(d))
+		}
+		h.renderStatus(w, status, "login_2fa", data)
+		return
+	}
 
-	h.setTokenCookie(w, token)
+	h.setTokenCookies(w, pair)
+	middleware.ClearCSRF(w)
 	http.Redirect(w, r, "/catalog", http.StatusSeeOther)
 }
 
@@ -315,7 +486,7 @@ func (h *FrontendHandler) RegisterPost(w http.ResponseWriter, r *http.Request) {
 	email := strings.TrimSpace(r.FormValue("email"))
 	pass := r.FormValue("password")
 
-	if err := h.auth.Register(email, pass); err != nil {
+	if err := h.auth.Register(r.Context(), email, pass); err != nil {
 		data := h.baseData(r, "register")
 		data["Title"] = "Register"
 		data["Error"] = err.Error()
@@ -323,25 +494,148 @@ func (h *FrontendHandler) RegisterPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.auth.Login(email, pass)
+	if err := h.account.SendVerificationEmailTo(r.Context(), email); err != nil {
+		log.Printf("[FRONTEND] verification email failed: %v\n", err)
+	}
+
+	pair, err := h.auth.Login(r.Context(), email, pass, middleware.ClientIP(r))
 	if err == nil {
-		h.setTokenCookie(w, token)
+		h.setTokenCookies(w, pair)
+		middleware.ClearCSRF(w)
 	}
 	http.Redirect(w, r, "/catalog", http.StatusSeeOther)
 }
 
+func (h *FrontendHandler) VerifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	data := h.baseData(r, "")
+	data["Title"] = "Verify email"
+
+	if err := h.account.VerifyEmail(r.Context(), r.URL.Query().Get("token")); err != nil {
+		if !errors.Is(err, logic.ErrInvalidToken) {
+			log.Printf("[FRONTEND] verify email failed: %v\n", err)
+		}
+		data["Error"] = "This verification link is invalid, expired or has already been used."
+	} else {
+		data["Verified"] = true
+	}
+	h.render(w, "verify_email", data)
+}
+
+func (h *FrontendHandler) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	data := h.baseData(r, "login")
+	data["Title"] = "Reset password"
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		data["Mode"] = "request"
+		h.render(w, "reset", data)
+		return
+	}
+
+	data["Mode"] = "reset"
+	data["Token"] = token
+	if err := h.account.CheckResetToken(r.Context(), token); err != nil {
+		data["Mode"] = "request"
+		data["Error"] = "This reset link is invalid or has expired. Request a new one below."
+	}
+	h.render(w, "reset", data)
+}
+
+func (h *FrontendHandler) ResetRequestPost(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	email := strings.TrimSpace(r.FormValue("email"))
+
+	if err := h.account.RequestPasswordReset(r.Context(), email); err != nil {
+		log.Printf("[FRONTEND] password reset email failed: %v\n", err)
+	}
+
+	data := h.baseData(r, "login")
+	data["Title"] = "Reset password"
+	data["Mode"] = "sent"
+	h.render(w, "reset", data)
+}
+
+func (h *FrontendHandler) ResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	token := r.FormValue("token")
+	pass := r.FormValue("password")
+
+	renderErr := func(msg string) {
+		data := h.baseData(r, "login")
+		data["Title"] = "Reset password"
+		data["Mode"] = "reset"
+		data["Token"] = token
+		data["Error"] = msg
+		h.render(w, "reset", data)
+	}
+
+	if pass != r.FormValue("confirm") {
+		renderErr("Passwords do not match.")
+		return
+	}
+
+	err := h.account.ResetPassword(r.Context(), token, pass)
+	if errors.Is(err, logic.ErrInvalidToken) {
+		renderErr("This reset link is invalid or has expired.")
+		return
+	}
+	if err != nil {
+		renderErr(err.Error())
+		return
+	}
+
+	h.clearTokenCookies(w)
+	http.Redirect(w, r, "/login?reset=1", http.StatusSeeOther)
+}
+
 func (h *FrontendHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	h.clearTokenCookie(w)
+	var claims logic.AccessClaims
+	if p, ok := middleware.FromRequest(r); ok {
+		claims = logic.AccessClaims{UserID: p.UserID, JTI: p.TokenID, ExpiresAt: p.ExpiresAt}
+	}
+
+	refresh := ""
+	if c, err := r.Cookie(refreshCookie); err == nil {
+		refresh = c.Value
+	}
+
+	if err := h.auth.Logout(r.Context(), claims, refresh); err != nil {
+		http.Error(w, "logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.clearTokenCookies(w)
+	middleware.ClearCSRF(w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+func (h *FrontendHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.auth.LogoutAll(r.Context(), userID); err != nil {
+		http.Error(w, "logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	h.clearTokenCookies(w)
+	middleware.ClearCSRF(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
 func (h *FrontendHandler) CartPage(w http.ResponseWriter, r *http.Request) {
 	userID, ok := h.requireAuth(w, r)
 	if !ok {
 		return
 	}
 
-	c, items := h.ensureUserCart(userID)
+	h.render(w, "cart", h.cartData(r, userID))
+}
+
+func (h *FrontendHandler) cartData(r *http.Request, userID int) map[string]any {
+	c, items := h.ensureUserCart(r.Context(), userID)
 
 	books, _ := h.books.ListBooks(r.Context(), models.BookQuery{})
 	bookMap := map[int]models.Book{}
@@ -376,13 +670,29 @@ func (h *FrontendHandler) CartPage(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	shipping := make([]models.Address, 0)
+	for _, a := range h.addresses.List(r.Context(), userID) {
+		if a.Kind == models.AddressShipping {
+			shipping = append(shipping, a)
+		}
+	}
+
 	data := h.baseData(r, "cart")
 	data["Title"] = "Cart"
 	data["Cart"] = c
+	data["IdempotencyKey"] = middleware.NewIdempotencyKey()
 	data["Rows"] = rows
 	data["Total"] = total
+	data["Addresses"] = shipping
+	return data
+}
 
-	h.render(w, "cart", data)
+// cartError shows the cart again with what went wrong, as a form would.
+func (h *FrontendHandler) cartError(w http.ResponseWriter, r *http.Request, userID int, err error) {
+	status, msg := pageError(err)
+	data := h.cartData(r, userID)
+	data["Error"] = msg
+	h.renderStatus(w, status, "cart", data)
 }
 
 func (h *FrontendHandler) CartAdd(w http.ResponseWriter, r *http.Request) {
@@ -397,8 +707,11 @@ func (h *FrontendHandler) CartAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, _ := h.ensureUserCart(userID)
-	_, _ = h.cart.AddItem(c.ID, bookID, 1)
+	c, _ := h.ensureUserCart(r.Context(), userID)
+	if _, err := h.cart.AddItem(r.Context(), c.ID, bookID, 1); err != nil {
+		h.cartError(w, r, userID, err)
+		return
+	}
 	http.Redirect(w, r, "/cart", http.StatusSeeOther)
 }
 
@@ -421,8 +734,11 @@ func (h *FrontendHandler) CartUpdateQty(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	c, _ := h.ensureUserCart(userID)
-	_ = h.cart.UpdateItem(c.ID, itemID, qty)
+	c, _ := h.ensureUserCart(r.Context(), userID)
+	if err := h.cart.UpdateItem(r.Context(), c.ID, itemID, qty); err != nil {
+		h.cartError(w, r, userID, err)
+		return
+	}
 	http.Redirect(w, r, "/cart", http.StatusSeeOther)
 }
 
@@ -438,8 +754,11 @@ func (h *FrontendHandler) CartDeleteItem(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	c, _ := h.ensureUserCart(userID)
-	_ = h.cart.DeleteItem(c.ID, itemID)
+	c, _ := h.ensureUserCart(r.Context(), userID)
+	if err := h.cart.DeleteItem(r.Context(), c.ID, itemID); err != nil {
+		h.cartError(w, r, userID, err)
+		return
+	}
 	http.Redirect(w, r, "/cart", http.StatusSeeOther)
 }
 
@@ -449,7 +768,7 @@ func (h *FrontendHandler) OrdersPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	all := h.orderCRUD.ListOrders()
+	all := h.orderCRUD.ListOrders(r.Context())
 	out := make([]models.Order, 0)
 	for _, o := range all {
 		if o.CustomerID == userID {
@@ -475,18 +794,22 @@ func (h *FrontendHandler) OrderDetailsPage(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	o, items, err := h.orderCRUD.GetOrder(id)
+	o, items, err := h.orderCRUD.GetOrder(r.Context(), id)
 	if err != nil || o.CustomerID != userID {
 		http.Redirect(w, r, "/orders", http.StatusSeeOther)
 		return
 	}
 
-	books, _ := h.books.ListBooks(r.Context(), models.BookQuery{})
+	h.render(w, "order_details", h.orderDetailsData(r, o, items))
+}
 
-	bookMap := map[int]models.Book{}
-	for _, b := range books {
-		bookMap[b.ID] = b
+func (h *FrontendHandler) orderDetailsData(r *http.Request, o models.Order, items []models.OrderItem) map[string]any {
+	// Books deleted since the order was placed still belong in its history.
+	bookIDs := make([]int, 0, len(items))
+	for _, it := range items {
+		bookIDs = append(bookIDs, it.BookID)
 	}
+	bookMap, _ := h.books.BooksByID(r.Context(), bookIDs)
 
 	type row struct {
 		Item models.OrderItem
@@ -502,7 +825,43 @@ func (h *FrontendHandler) OrderDetailsPage(w http.ResponseWriter, r *http.Reques
 	data["Title"] = "Order Details"
 	data["Order"] = o
 	data["Rows"] = rows
-	h.render(w, "order_details", data)
+	data["Payments"] = h.payments.Payments(r.Context(), o.ID)
+	data["Refunds"] = h.refunds.Refunds(r.Context(), o.ID)
+	data["CanCancel"] = logic.CanCancel(o)
+	return data
+}
+
+func (h *FrontendHandler) OrderCancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	if id <= 0 {
+		http.Redirect(w, r, "/orders", http.StatusSeeOther)
+		return
+	}
+
+	o, _, err := h.orderCRUD.GetOrder(r.Context(), id)
+	if err != nil || o.CustomerID != userID {
+		http.Redirect(w, r, "/orders", http.StatusSeeOther)
+		return
+	}
+
+	_ = r.ParseForm()
+	if _, err := h.refunds.CancelOrder(r.Context(), id, userID, strings.TrimSpace(r.FormValue("reason"))); err != nil {
+		// Show the order as it is now, e.g. already shipped, next to why it
+		// was not cancelled.
+		status, msg := pageError(err)
+		if cur, items, gerr := h.orderCRUD.GetOrder(r.Context(), id); gerr == nil {
+			data := h.orderDetailsData(r, cur, items)
+			data["Error"] = msg
+			h.renderStatus(w, status, "order_details", data)
+			return
+		}
+	}
+	http.Redirect(w, r, "/orders/"+strconv.Itoa(id), http.StatusSeeOther)
 }
 
 func (h *FrontendHandler) CreateOrderFromCart(w http.ResponseWriter, r *http.Request) {
@@ -511,13 +870,19 @@ func (h *FrontendHandler) CreateOrderFromCart(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	c, items := h.ensureUserCart(userID)
+	c, items := h.ensureUserCart(r.Context(), userID)
 	if len(items) == 0 {
 		http.Redirect(w, r, "/cart", http.StatusSeeOther)
 		return
 	}
 
-	_, _, _ = h.orderSvc.CreateOrderFromCart(userID, c.ID)
+	_ = r.ParseForm()
+	addressID, _ := strconv.Atoi(r.FormValue("addressId"))
+
+	if _, _, err := h.orderSvc.CreateOrderFromCart(r.Context(), userID, c.ID, addressID); err != nil {
+		h.cartError(w, r, userID, err)
+		return
+	}
 	http.Redirect(w, r, "/orders", http.StatusSeeOther)
 }
 
@@ -527,7 +892,11 @@ func (h *FrontendHandler) WishlistsPage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	all := h.wishlist.ListWishlists()
+	h.render(w, "wishlists", h.wishlistsData(r, userID))
+}
+
+func (h *FrontendHandler) wishlistsData(r *http.Request, userID int) map[string]any {
+	all := h.wishlist.ListWishlists(r.Context())
 
 	var myWL models.Wishlist
 	for _, wli := range all {
@@ -537,8 +906,8 @@ func (h *FrontendHandler) WishlistsPage(w http.ResponseWriter, r *http.Request)
 		}
 	}
 	if myWL.ID == 0 {
-		myWL = h.wishlist.CreateWishlist(userID)
-		all = h.wishlist.ListWishlists()
+		myWL = h.wishlist.CreateWishlist(r.Context(), userID)
+		all = h.wishlist.ListWishlists(r.Context())
 	}
 
 	books, _ := h.books.ListBooks(r.Context(), models.BookQuery{})
@@ -547,7 +916,7 @@ func (h *FrontendHandler) WishlistsPage(w http.ResponseWriter, r *http.Request)
 		bookMap[b.ID] = b
 	}
 
-	myObj, myItems, _ := h.wishlist.GetWishlist(myWL.ID)
+	myObj, myItems, _ := h.wishlist.GetWishlist(r.Context(), myWL.ID)
 	myRows := make([]WishlistRowView, 0, len(myItems))
 	var myTotal float64
 	for _, it := range myItems {
@@ -572,7 +941,7 @@ func (h *FrontendHandler) WishlistsPage(w http.ResponseWriter, r *http.Request)
 			continue
 		}
 
-		wObj, items, err := h.wishlist.GetWishlist(wl.ID)
+		wObj, items, err := h.wishlist.GetWishlist(r.Context(), wl.ID)
 		if err != nil {
 			continue
 		}
@@ -599,10 +968,18 @@ func (h *FrontendHandler) WishlistsPage(w http.ResponseWriter, r *http.Request)
 
 	data := h.baseData(r, "wishlists")
 	data["Title"] = "Wishlists"
+	data["IdempotencyKey"] = middleware.NewIdempotencyKey()
 	data["My"] = myBlock
 	data["Others"] = others
+	return data
+}
 
-	h.render(w, "wishlists", data)
+// wishlistsError shows the wishlists again with what went wrong.
+func (h *FrontendHandler) wishlistsError(w http.ResponseWriter, r *http.Request, userID int, err error) {
+	status, msg := pageError(err)
+	data := h.wishlistsData(r, userID)
+	data["Error"] = msg
+	h.renderStatus(w, status, "wishlists", data)
 }
 
 func (h *FrontendHandler) WishlistAdd(w http.ResponseWriter, r *http.Request) {
@@ -617,7 +994,7 @@ func (h *FrontendHandler) WishlistAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	all := h.wishlist.ListWishlists()
+	all := h.wishlist.ListWishlists(r.Context())
 	var wl models.Wishlist
 	for _, wli := range all {
 		if wli.CustomerID == userID {
@@ -626,10 +1003,13 @@ func (h *FrontendHandler) WishlistAdd(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	if wl.ID == 0 {
-		wl = h.wishlist.CreateWishlist(userID)
+		wl = h.wishlist.CreateWishlist(r.Context(), userID)
 	}
 
-	_, _ = h.wishlist.AddItem(wl.ID, bookID, 1)
+	if _, err := h.wishlist.AddItem(r.Context(), wl.ID, bookID, 1); err != nil {
+		h.wishlistsError(w, r, userID, err)
+		return
+	}
 	http.Redirect(w, r, "/wishlists", http.StatusSeeOther)
 }
 
@@ -645,11 +1025,16 @@ func (h *FrontendHandler) WishlistGift(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, _, _, _ = h.wishlist.GiftFromWishlist(wishlistID, buyerID)
+	// A failure has to answer with an error status: the idempotency
+	// middleware stores redirects as the final answer for this key.
+	if _, _, _, err := h.wishlist.GiftFromWishlist(r.Context(), wishlistID, buyerID); err != nil {
+		h.wishlistsError(w, r, buyerID, err)
+		return
+	}
 	http.Redirect(w, r, "/orders", http.StatusSeeOther)
 }
 func (h *FrontendHandler) AdminCreateBookPage(w http.ResponseWriter, r *http.Request) {
-	_, ok := h.requireAdmin(w, r)
+	_, ok := h.requirePermission(w, r, models.PermBooksWrite)
 	if !ok {
 		return
 	}
@@ -669,7 +1054,7 @@ func (h *FrontendHandler) AdminCreateBookPage(w http.ResponseWriter, r *http.Req
 }
 
 func (h *FrontendHandler) AdminCreateBookPost(w http.ResponseWriter, r *http.Request) {
-	_, ok := h.requireAdmin(w, r)
+	_, ok := h.requirePermission(w, r, models.PermBooksWrite)
 	if !ok {
 		return
 	}
@@ -717,7 +1102,7 @@ func (h *FrontendHandler) AdminCreateBookPost(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	_, err = h.books.CreateBook(models.Book{
+	_, err = h.books.CreateBook(r.Context(), models.Book{
 		Title:       title,
 		Author:      author,
 		Genre:       genre,
@@ -747,3 +1132,245 @@ type WishlistBlockView struct {
 	Rows     []WishlistRowView
 	Total    float64
 }
+
+func humanizeWait(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d seconds", int(math.Ceil(d.Seconds())))
+	}
+	m := int(math.Ceil(d.Minutes()))
+	if m == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", m)
+}
+
+func (h *FrontendHandler) securityData(r *http.Request, userID int) map[string]any {
+	data := h.baseData(r, "security")
+	data["Title"] = "Account security"
+
+	enabled, since, err := h.auth.TwoFactorStatus(r.Context(), userID)
+	if err != nil {
+		log.Printf("[FRONTEND] two-factor status failed: userId=%d err=%v\n", userID, err)
+	}
+	data["TOTPEnabled"] = enabled
+	if since != nil {
+		data["TOTPEnabledAt"] = since.Format("2006-01-02")
+	}
+	_, role, _ := h.currentUser(r)
+	data["MFARequired"] = h.auth.MFARequiredFor(role)
+	return data
+}
+
+func (h *FrontendHandler) SecurityPage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	h.render(w, "security", h.securityData(r, userID))
+}
+
+func (h *FrontendHandler) TOTPSetupPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+
+	data := h.securityData(r, userID)
+	setup, err := h.auth.BeginTOTPSetup(r.Context(), userID)
+	if err != nil {
+		data["Error"] = err.Error()
+	} else {
+		data["Setup"] = setup
+	}
+	h.render(w, "security", data)
+}
+
+func (h *FrontendHandler) TOTPEnablePost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	_ = r.ParseForm()
+
+	codes, pair, err := h.auth.EnableTOTP(r.Context(), userID, r.FormValue("code"))
+	if err != nil {
+		data := h.securityData(r, userID)
+		data["Error"] = "That code didn't match. Scan the key again and retry."
+		h.render(w, "security", data)
+		return
+	}
+
+	// The old session predates the second factor; swap it for the elevated
+	// one EnableTOTP issued.
+	if p, ok := middleware.FromRequest(r); ok {
+		claims := logic.AccessClaims{UserID: p.UserID, JTI: p.TokenID, ExpiresAt: p.ExpiresAt}
+		refresh := ""
+		if c, err := r.Cookie(refreshCookie); err == nil {
+			refresh = c.Value
+		}
+		_ = h.auth.Logout(r.Context(), claims, refresh)
+	}
+	h.setTokenCookies(w, pair)
+
+	data := h.securityData(r, userID)
+	data["MFAPending"] = false
+	data["Notice"] = "Two-factor authentication is now on."
+	data["RecoveryCodes"] = codes
+	h.render(w, "security", data)
+}
+
+func (h *FrontendHandler) TOTPDisablePost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	_ = r.ParseForm()
+
+	if err := h.auth.DisableTOTP(r.Context(), userID, r.FormValue("password"), r.FormValue("code")); err != nil {
+		data := h.securityData(r, userID)
+		data["Error"] = err.Error()
+		h.render(w, "security", data)
+		return
+	}
+
+	data := h.securityData(r, userID)
+	data["Notice"] = "Two-factor authentication has been turned off."
+	h.render(w, "security", data)
+}
+
+func (h *FrontendHandler) RecoveryCodesPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	_ = r.ParseForm()
+
+	data := h.securityData(r, userID)
+	codes, err := h.auth.RegenerateRecoveryCodes(r.Context(), userID, r.FormValue("code"))
+	if err != nil {
+		data["Error"] = err.Error()
+	} else {
+		data["Notice"] = "Your old recovery codes no longer work."
+		data["RecoveryCodes"] = codes
+	}
+	h.render(w, "security", data)
+}
+
+func (h *FrontendHandler) profileData(r *http.Request, userID int) map[string]any {
+	data := h.baseData(r, "profile")
+	data["Title"] = "Profile"
+
+	u, err := h.account.Profile(r.Context(), userID)
+	if err != nil {
+		log.Printf("[FRONTEND] load profile failed: userId=%d err=%v\n", userID, err)
+	}
+	data["User"] = u
+	data["Addresses"] = h.addresses.List(r.Context(), userID)
+	return data
+}
+
+func (h *FrontendHandler) ProfilePage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	h.render(w, "profile", h.profileData(r, userID))
+}
+
+func (h *FrontendHandler) ProfilePost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	_ = r.ParseForm()
+
+	_, err := h.account.UpdateProfile(r.Context(), userID, logic.ProfileUpdate{
+		Name:  r.FormValue("name"),
+		Phone: r.FormValue("phone"),
+	})
+	data := h.profileData(r, userID)
+	if err != nil {
+		data["Error"] = err.Error()
+	} else {
+		data["Notice"] = "Profile saved."
+	}
+	h.render(w, "profile", data)
+}
+
+func (h *FrontendHandler) ChangePasswordPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	_ = r.ParseForm()
+
+	if r.FormValue("new_password") != r.FormValue("confirm_password") {
+		data := h.profileData(r, userID)
+		data["Error"] = "The new passwords don't match."
+		h.render(w, "profile", data)
+		return
+	}
+
+	err := h.account.ChangePassword(r.Context(), userID, r.FormValue("current_password"), r.FormValue("new_password"))
+	if err != nil {
+		data := h.profileData(r, userID)
+		data["Error"] = err.Error()
+		h.render(w, "profile", data)
+		return
+	}
+
+	h.clearTokenCookies(w)
+	middleware.ClearCSRF(w)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (h *FrontendHandler) AddressAddPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	_ = r.ParseForm()
+
+	_, err := h.addresses.Create(r.Context(), userID, models.Address{
+		Label:      r.FormValue("label"),
+		Kind:       r.FormValue("kind"),
+		Recipient:  r.FormValue("recipient"),
+		Line1:      r.FormValue("line1"),
+		Line2:      r.FormValue("line2"),
+		City:       r.FormValue("city"),
+		Region:     r.FormValue("region"),
+		PostalCode: r.FormValue("postal_code"),
+		Country:    r.FormValue("country"),
+		Phone:      r.FormValue("phone"),
+		IsDefault:  r.FormValue("default") == "on",
+	})
+	if err != nil {
+		data := h.profileData(r, userID)
+		data["Error"] = err.Error()
+		h.render(w, "profile", data)
+		return
+	}
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
+
+func (h *FrontendHandler) AddressDefaultPost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	_, _ = h.addresses.SetDefault(r.Context(), userID, id)
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
+
+func (h *FrontendHandler) AddressDeletePost(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAuth(w, r)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	_ = h.addresses.Delete(r.Context(), userID, id)
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
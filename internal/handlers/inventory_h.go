@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+)
+
+type InventoryHandler struct {
+	service *logic.InventoryService
+}
+
+func NewInventoryHandler(service *logic.InventoryService) *InventoryHandler {
+	return &InventoryHandler{service: service}
+}
+
+// Adjust changes a book's stock by the delta in the body, e.g. +20 for a
+// delivery or -1 for a damaged copy, and returns the ledger entry.
+func (h *InventoryHandler) Adjust(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var req struct {
+		Delta *int   `json:"delta"`
+		Note  string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Delta == nil {
+		writeError(w, apperr.Invalid("delta", "delta required"))
+		return
+	}
+
+	actorID, _ := middleware.UserID(r)
+	m, err := h.service.Adjust(r.Context(), id, *req.Delta, actorID, req.Note)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (h *InventoryHandler) Movements(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	writeJSON(w, http.StatusOK, h.service.Movements(r.Context(), id, limit))
+}
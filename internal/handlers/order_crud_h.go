@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/logic"
 	"bookstore/internal/middleware"
 	"bookstore/internal/models"
@@ -22,16 +23,14 @@ func NewOrderCRUDHandler(crud *logic.OrderCRUDService) *OrderCRUDHandler {
 func (h *OrderCRUDHandler) Orders(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	role := middleware.Role(r)
-
 	switch r.Method {
 	case http.MethodGet:
-		all := h.crud.ListOrders()
+		all := h.crud.ListOrders(r.Context())
 
-		if role == "admin" {
+		if middleware.Can(r, models.PermOrdersRead) {
 			writeJSON(w, http.StatusOK, all)
 			return
 		}
@@ -45,72 +44,132 @@ func (h *OrderCRUDHandler) Orders(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, out)
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (h *OrderCRUDHandler) OrderByID(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
-	role := middleware.Role(r)
-
-	idStr := strings.TrimPrefix(r.URL.Path, "/orders/")
+	idStr := strings.TrimPrefix(r.URL.Path, "/orders_api/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 
-	o, items, err := h.crud.GetOrder(id)
+	o, items, err := h.crud.GetOrder(r.Context(), id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
 
-	if role != "admin" && o.CustomerID != userID {
-		writeJSON(w, http.StatusForbidden, map[string]string{"error": "forbidden"})
+	if o.CustomerID != userID && !middleware.Can(r, models.PermOrdersRead) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
+		setETag(w, o.Version)
 		writeJSON(w, http.StatusOK, map[string]any{"order": o, "items": items})
 
 	case http.MethodPut:
-		if role != "admin" {
-			writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+		if !middleware.Can(r, models.PermOrdersWrite) {
+			writeErrorStatus(w, http.StatusForbidden, "forbidden")
 			return
 		}
 
 		var in models.Order
 		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
 			return
 		}
 		in.ID = id
+		if v, ok := ifMatch(r); ok {
+			in.Version = v
+		}
 
-		if err := h.crud.UpdateOrder(in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		updated, err := h.crud.UpdateOrder(r.Context(), in)
+		if err != nil {
+			writeUpdateError(w, r, err)
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]string{"message": "updated"})
+		setETag(w, updated.Version)
+		writeJSON(w, http.StatusOK, map[string]any{"message": "updated", "order": updated})
 
 	case http.MethodDelete:
-		if role != "admin" {
-			writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin only"})
+		if !middleware.Can(r, models.PermOrdersDelete) {
+			writeErrorStatus(w, http.StatusForbidden, "forbidden")
 			return
 		}
 
-		if err := h.crud.DeleteOrder(id); err != nil {
-			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		if err := h.crud.DeleteOrder(r.Context(), id, userID); err != nil {
+			writeError(w, err)
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"message": "deleted"})
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *OrderCRUDHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	o, items, err := h.crud.RestoreOrder(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"order": o, "items": items})
+}
+
+// Transition moves an order along its lifecycle, e.g. {"status": "shipped"}.
+// Cancelling and refunding move money and stock too, so they have endpoints
+// of their own.
+func (h *OrderCRUDHandler) Transition(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var in struct {
+		Status  string `json:"status"`
+		Note    string `json:"note"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	switch in.Status {
+	case models.OrderCancelled:
+		writeError(w, apperr.Invalid("status", "cancel through /orders_api/{id}/cancel"))
+		return
+	case models.OrderRefunded:
+		writeError(w, apperr.Invalid("status", "refund through /orders_api/{id}/refunds"))
+		return
+	}
+	if v, ok := ifMatch(r); ok {
+		in.Version = v
+	}
+
+	actorID, _ := middleware.UserID(r)
+	o, err := h.crud.TransitionOrder(r.Context(), id, in.Version, in.Status, actorID, in.Note)
+	if err != nil {
+		writeUpdateError(w, r, err)
+		return
 	}
+	setETag(w, o.Version)
+	writeJSON(w, http.StatusOK, o)
 }
@@ -19,29 +19,30 @@ func NewOrderHandler(svc *logic.OrderService) *OrderHandler {
 func (h *OrderHandler) Orders(w http.ResponseWriter, r *http.Request) {
 	userID, ok := middleware.UserID(r)
 	if !ok {
-		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
 	switch r.Method {
 	case http.MethodPost:
 		var in struct {
-			CartID int `json:"cartId"`
+			CartID    int `json:"cartId"`
+			AddressID int `json:"addressId"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
 			return
 		}
 
-		o, items, err := h.svc.CreateOrderFromCart(userID, in.CartID)
+		o, items, err := h.svc.CreateOrderFromCart(r.Context(), userID, in.CartID, in.AddressID)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			writeError(w, err)
 			return
 		}
 
 		writeJSON(w, http.StatusCreated, map[string]any{"order": o, "items": items})
 
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/models"
+	"bookstore/internal/payments"
+)
+
+// maxWebhookBody caps what a webhook request may send.
+const maxWebhookBody = 64 << 10
+
+type PaymentHandler struct {
+	service *logic.PaymentService
+	orders  *logic.OrderCRUDService
+	fake    *payments.Fake
+}
+
+// NewPaymentHandler serves payments. fake is set when the fake provider is
+// in use, and enables FakeSettle.
+func NewPaymentHandler(service *logic.PaymentService, orders *logic.OrderCRUDService, fake *payments.Fake) *PaymentHandler {
+	return &PaymentHandler{service: service, orders: orders, fake: fake}
+}
+
+// Webhook takes the provider's notifications. It is not behind auth; the
+// body's signature is what proves where it came from.
+func (h *PaymentHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid body")
+		return
+	}
+
+	if err := h.service.HandleWebhook(r.Context(), body, r.Header.Get(payments.SignatureHeader)); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "ok"})
+}
+
+// OrderPayments lists an order's payments on GET and starts a new one on
+// POST, e.g. after the last one was declined.
+func (h *PaymentHandler) OrderPayments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	o, _, err := h.orders.GetOrder(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if o.CustomerID != userID && !middleware.Can(r, models.PermOrdersRead) {
+			writeErrorStatus(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		writeJSON(w, http.StatusOK, h.service.Payments(r.Context(), o.ID))
+
+	case http.MethodPost:
+		if o.CustomerID != userID && !middleware.Can(r, models.PermOrdersWrite) {
+			writeErrorStatus(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		p, err := h.service.StartPayment(r.Context(), o)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, p)
+
+	default:
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// FakeSettle plays the fake provider's side for local runs: it completes an
+// intent and feeds the resulting webhook through the real webhook path. Only
+// the order's owner or staff who can manage orders may settle it.
+func (h *PaymentHandler) FakeSettle(w http.ResponseWriter, r *http.Request) {
+	if h.fake == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	p, err := h.service.PaymentByIntent(r.Context(), r.PathValue("intent"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	o, _, err := h.orders.GetOrder(r.Context(), p.OrderID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if o.CustomerID != userID && !middleware.Can(r, models.PermOrdersWrite) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	payload, signature := h.fake.Settle(p.IntentID, p.Total)
+	if err := h.service.HandleWebhook(r.Context(), payload, signature); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	updated, err := h.service.PaymentByIntent(r.Context(), p.IntentID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
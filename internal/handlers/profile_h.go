@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/models"
+)
+
+type ProfileHandler struct {
+	account   *logic.AccountService
+	addresses *logic.AddressService
+}
+
+func NewProfileHandler(account *logic.AccountService, addresses *logic.AddressService) *ProfileHandler {
+	return &ProfileHandler{account: account, addresses: addresses}
+}
+
+func (h *ProfileHandler) Me(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		u, err := h.account.Profile(r.Context(), userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, u)
+
+	case http.MethodPut:
+		var in logic.ProfileUpdate
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+
+		u, err := h.account.UpdateProfile(r.Context(), userID, in)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, u)
+
+	default:
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *ProfileHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var in struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	err := h.account.ChangePassword(r.Context(), userID, in.CurrentPassword, in.NewPassword)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "password changed, sign in again"})
+}
+
+func (h *ProfileHandler) Addresses(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, h.addresses.List(r.Context(), userID))
+
+	case http.MethodPost:
+		var in models.Address
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+
+		a, err := h.addresses.Create(r.Context(), userID, in)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, a)
+
+	default:
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *ProfileHandler) AddressByID(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		a, err := h.addresses.Get(r.Context(), userID, id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+
+	case http.MethodPut:
+		var in models.Address
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+
+		a, err := h.addresses.Update(r.Context(), userID, id, in)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+
+	case http.MethodDelete:
+		if err := h.addresses.Delete(r.Context(), userID, id); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"message": "deleted"})
+
+	default:
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *ProfileHandler) SetDefaultAddress(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	a, err := h.addresses.SetDefault(r.Context(), userID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/models"
+)
+
+type RefundHandler struct {
+	service *logic.RefundService
+	orders  *logic.OrderCRUDService
+}
+
+func NewRefundHandler(service *logic.RefundService, orders *logic.OrderCRUDService) *RefundHandler {
+	return &RefundHandler{service: service, orders: orders}
+}
+
+// Cancel lets a customer cancel their own order until it ships. The body,
+// {"reason": "..."}, is optional.
+func (h *RefundHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var in struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	o, _, err := h.orders.GetOrder(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if o.CustomerID != userID && !middleware.Can(r, models.PermOrdersWrite) {
+		writeErrorStatus(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	cancelled, err := h.service.CancelOrder(r.Context(), id, userID, in.Reason)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setETag(w, cancelled.Version)
+	writeJSON(w, http.StatusOK, cancelled)
+}
+
+// Refunds lists an order's refunds on GET. POST issues one, e.g.
+// {"items": [{"orderItemId": 3, "qty": 1}], "reason": "damaged"}; without
+// items everything not refunded yet is refunded.
+func (h *RefundHandler) Refunds(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		o, _, err := h.orders.GetOrder(r.Context(), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if o.CustomerID != userID && !middleware.Can(r, models.PermOrdersRead) {
+			writeErrorStatus(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		writeJSON(w, http.StatusOK, h.service.Refunds(r.Context(), id))
+
+	case http.MethodPost:
+		var in struct {
+			Items  []logic.RefundLine `json:"items"`
+			Reason string             `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+			writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+
+		rf, err := h.service.Refund(r.Context(), id, in.Items, userID, in.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, rf)
+
+	default:
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+)
+
+type UserHandler struct {
+	service *logic.UserService
+}
+
+func NewUserHandler(service *logic.UserService) *UserHandler {
+	return &UserHandler{service: service}
+}
+
+func (h *UserHandler) Users(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.service.ListUsers(r.Context()))
+}
+
+func (h *UserHandler) UserByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	u, err := h.service.GetUser(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *UserHandler) Roles(w http.ResponseWriter, r *http.Request) {
+	out := make(map[string]any)
+	for _, role := range logic.Roles() {
+		out[role] = logic.PermissionsFor(role)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *UserHandler) AssignRole(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	var in struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	u, err := h.service.AssignRole(r.Context(), actorID, id, in.Role)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *UserHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	actorID, ok := middleware.UserID(r)
+	if !ok {
+		writeErrorStatus(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	u, err := h.service.Unlock(r.Context(), actorID, id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *UserHandler) LoginAttempts(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	writeJSON(w, http.StatusOK, h.service.LoginAttempts(r.Context(), r.URL.Query().Get("email"), limit))
+}
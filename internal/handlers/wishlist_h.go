@@ -20,16 +20,16 @@ func NewWishlistHandler(service *logic.WishlistService) *WishlistHandler {
 func (h *WishlistHandler) Wishlists(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		writeJSON(w, http.StatusOK, h.service.ListWishlists())
+		writeJSON(w, http.StatusOK, h.service.ListWishlists(r.Context()))
 	case http.MethodPost:
 		var in struct {
 			CustomerID int `json:"customerId"`
 		}
 		_ = json.NewDecoder(r.Body).Decode(&in)
-		wl := h.service.CreateWishlist(in.CustomerID)
+		wl := h.service.CreateWishlist(r.Context(), in.CustomerID)
 		writeJSON(w, http.StatusCreated, wl)
 	default:
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
@@ -37,13 +37,13 @@ func (h *WishlistHandler) WishlistByID(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/wishlists/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || id <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
 		return
 	}
 
-	wl, items, err := h.service.GetWishlist(id)
+	wl, items, err := h.service.GetWishlist(r.Context(), id)
 	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusOK, map[string]any{
@@ -62,12 +62,12 @@ func (h *WishlistHandler) WishlistItems(w http.ResponseWriter, r *http.Request)
 
 	wishlistID, err := strconv.Atoi(parts[0])
 	if err != nil || wishlistID <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid wishlist id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid wishlist id")
 		return
 	}
 
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
@@ -76,13 +76,13 @@ func (h *WishlistHandler) WishlistItems(w http.ResponseWriter, r *http.Request)
 		Qty    int `json:"qty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 
-	item, err := h.service.AddItem(wishlistID, in.BookID, in.Qty)
+	item, err := h.service.AddItem(r.Context(), wishlistID, in.BookID, in.Qty)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
 	writeJSON(w, http.StatusCreated, item)
@@ -97,21 +97,21 @@ func (h *WishlistHandler) Gift(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method != http.MethodPost {
-		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		writeErrorStatus(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	wishlistID, err := strconv.Atoi(parts[0])
 	if err != nil || wishlistID <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid wishlist id"})
+		writeErrorStatus(w, http.StatusBadRequest, "invalid wishlist id")
 		return
 	}
 
 	buyerID, _ := strconv.Atoi(r.URL.Query().Get("buyerCustomerId"))
 
-	order, items, giftForCustomerID, err := h.service.GiftFromWishlist(wishlistID, buyerID)
+	order, items, giftForCustomerID, err := h.service.GiftFromWishlist(r.Context(), wishlistID, buyerID)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		writeError(w, err)
 		return
 	}
 
@@ -121,3 +121,21 @@ func (h *WishlistHandler) Gift(w http.ResponseWriter, r *http.Request) {
 		"giftForCustomerId": giftForCustomerID,
 	})
 }
+
+func (h *WishlistHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeErrorStatus(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	wl, items, err := h.service.RestoreWishlist(r.Context(), id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"wishlist": wl,
+		"items":    items,
+	})
+}
@@ -0,0 +1,270 @@
+package logic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/mailer"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+
+	VerifyEmailTTL   = 48 * time.Hour
+	ResetPasswordTTL = time.Hour
+)
+
+var ErrWrongPassword = apperr.Forbidden("current password is incorrect")
+
+type AccountService struct {
+	users   repository.UserRepository
+	auth    *AuthService
+	mail    mailer.Mailer
+	baseURL string
+}
+
+func NewAccountService(users repository.UserRepository, auth *AuthService, mail mailer.Mailer, baseURL string) *AccountService {
+	return &AccountService{
+		users:   users,
+		auth:    auth,
+		mail:    mail,
+		baseURL: baseURL,
+	}
+}
+
+func (s *AccountService) SendVerificationEmail(ctx context.Context, userID int) error {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.sendVerification(ctx, u)
+}
+
+func (s *AccountService) SendVerificationEmailTo(ctx context.Context, email string) error {
+	u, err := s.users.GetByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+	return s.sendVerification(ctx, u)
+}
+
+func (s *AccountService) sendVerification(ctx context.Context, u models.User) error {
+	if u.EmailVerified {
+		return nil
+	}
+
+	token, err := s.auth.signActionToken(purposeVerifyEmail, u.ID, VerifyEmailTTL, jwt.MapClaims{
+		"email": u.Email,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Welcome to Online Bookstore!\n\nConfirm your email address by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			s.link("/auth/verify", token), VerifyEmailTTL,
+		),
+	})
+}
+
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	claims, err := s.auth.parseActionToken(token, purposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	userID, _ := claims["userId"].(float64)
+	u, err := s.users.GetByID(ctx, int(userID))
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if email, _ := claims["email"].(string); email != u.Email {
+		return ErrInvalidToken
+	}
+
+	if err := s.auth.consumeActionToken(ctx, claims); err != nil {
+		return err
+	}
+	if u.EmailVerified {
+		return nil
+	}
+
+	now := time.Now()
+	u.EmailVerified = true
+	u.EmailVerifiedAt = &now
+	return s.users.Update(ctx, u)
+}
+
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	u, err := s.users.GetByEmail(ctx, normalizeEmail(email))
+	if err != nil {
+		// Do not reveal whether the address is registered.
+		log.Printf("[ACCOUNT] password reset requested for unknown email\n")
+		return nil
+	}
+
+	token, err := s.auth.signActionToken(purposeResetPassword, u.ID, ResetPasswordTTL, jwt.MapClaims{
+		"pwh": passwordFingerprint(u.Password),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Online Bookstore account.\n\nChoose a new password here:\n\n%s\n\nThe link expires in %s. If it wasn't you, ignore this email.\n",
+			s.link("/auth/reset", token), ResetPasswordTTL,
+		),
+	})
+}
+
+func (s *AccountService) CheckResetToken(ctx context.Context, token string) error {
+	claims, err := s.auth.parseActionToken(token, purposeResetPassword)
+	if err != nil {
+		return err
+	}
+	_, err = s.resetTarget(ctx, claims)
+	return err
+}
+
+func (s *AccountService) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	if len(newPassword) < 4 {
+		return apperr.Invalid("password", "password must be at least 4 characters")
+	}
+
+	claims, err := s.auth.parseActionToken(token, purposeResetPassword)
+	if err != nil {
+		return err
+	}
+	u, err := s.resetTarget(ctx, claims)
+	if err != nil {
+		return err
+	}
+	if err := s.auth.consumeActionToken(ctx, claims); err != nil {
+		return err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.Password = string(hash)
+
+	// Following the emailed link proves control of the address.
+	if !u.EmailVerified {
+		now := time.Now()
+		u.EmailVerified = true
+		u.EmailVerifiedAt = &now
+	}
+
+	if err := s.users.Update(ctx, u); err != nil {
+		return err
+	}
+
+	if err := s.auth.LogoutAll(ctx, u.ID); err != nil {
+		log.Printf("[ACCOUNT] revoke sessions after password reset failed: userId=%d err=%v\n", u.ID, err)
+	}
+	// Proving control of the mailbox is as good as an admin unlock.
+	if err := s.users.ResetLoginFailures(ctx, u.ID); err != nil {
+		log.Printf("[ACCOUNT] clear lockout after password reset failed: userId=%d err=%v\n", u.ID, err)
+	}
+	return nil
+}
+
+type ProfileUpdate struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+func (s *AccountService) Profile(ctx context.Context, userID int) (models.User, error) {
+	return s.users.GetByID(ctx, userID)
+}
+
+func (s *AccountService) UpdateProfile(ctx context.Context, userID int, in ProfileUpdate) (models.User, error) {
+	name := strings.TrimSpace(in.Name)
+	phone := strings.TrimSpace(in.Phone)
+	if len(name) > 100 {
+		return models.User{}, apperr.Invalid("name", "name must be at most 100 characters")
+	}
+	if len(phone) > 32 {
+		return models.User{}, apperr.Invalid("phone", "phone must be at most 32 characters")
+	}
+
+	if err := s.users.UpdateProfile(ctx, userID, name, phone); err != nil {
+		return models.User{}, err
+	}
+	return s.users.GetByID(ctx, userID)
+}
+
+// ChangePassword signs the account out everywhere, including the session
+// that made the change, so the caller has to log in with the new password.
+func (s *AccountService) ChangePassword(ctx context.Context, userID int, current, newPassword string) error {
+	if len(newPassword) < 4 {
+		return apperr.Invalid("password", "password must be at least 4 characters")
+	}
+
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !s.auth.checkPassword(ctx, &u, current) {
+		return ErrWrongPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.Password = string(hash)
+	if err := s.users.Update(ctx, u); err != nil {
+		return err
+	}
+
+	if err := s.auth.LogoutAll(ctx, u.ID); err != nil {
+		log.Printf("[ACCOUNT] revoke sessions after password change failed: userId=%d err=%v\n", u.ID, err)
+	}
+	log.Printf("[ACCOUNT] password changed: userId=%d\n", u.ID)
+	return nil
+}
+
+func (s *AccountService) resetTarget(ctx context.Context, claims jwt.MapClaims) (models.User, error) {
+	userID, _ := claims["userId"].(float64)
+	u, err := s.users.GetByID(ctx, int(userID))
+	if err != nil {
+		return models.User{}, ErrInvalidToken
+	}
+
+	// The token is bound to the password it was issued for, so it dies as soon
+	// as the password changes through any path.
+	if pwh, _ := claims["pwh"].(string); pwh != passwordFingerprint(u.Password) {
+		return models.User{}, ErrInvalidToken
+	}
+	return u, nil
+}
+
+func (s *AccountService) link(path string, token string) string {
+	return s.baseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+func passwordFingerprint(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,220 @@
+package logic
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+const maxAddressesPerUser = 20
+
+var ErrAddressNotFound = apperr.NotFound("address not found")
+
+type AddressService struct {
+	repo repository.AddressRepository
+}
+
+func NewAddressService(repo repository.AddressRepository) *AddressService {
+	return &AddressService{repo: repo}
+}
+
+func (s *AddressService) List(ctx context.Context, userID int) []models.Address {
+	return s.repo.ListByUser(ctx, userID)
+}
+
+// Get only returns addresses owned by userID; anything else is reported as
+// not found so ids of other customers cannot be probed.
+func (s *AddressService) Get(ctx context.Context, userID int, id int) (models.Address, error) {
+	if id <= 0 {
+		return models.Address{}, ErrAddressNotFound
+	}
+	a, err := s.repo.GetByID(ctx, id)
+	if err != nil || a.UserID != userID {
+		return models.Address{}, ErrAddressNotFound
+	}
+	return a, nil
+}
+
+func (s *AddressService) Create(ctx context.Context, userID int, a models.Address) (models.Address, error) {
+	a = normalizeAddress(a)
+	if err := validateAddress(a); err != nil {
+		return models.Address{}, err
+	}
+
+	existing := s.repo.ListByUser(ctx, userID)
+	if len(existing) >= maxAddressesPerUser {
+		return models.Address{}, apperr.Conflict("address book is full")
+	}
+
+	a.ID = 0
+	a.UserID = userID
+	wantDefault := a.IsDefault || !hasDefault(existing, a.Kind)
+	a.IsDefault = false
+
+	created, err := s.repo.Create(ctx, a)
+	if err != nil {
+		return models.Address{}, err
+	}
+	if wantDefault {
+		if err := s.repo.SetDefault(ctx, userID, created.Kind, created.ID); err != nil {
+			return models.Address{}, err
+		}
+		created.IsDefault = true
+	}
+	return created, nil
+}
+
+func (s *AddressService) Update(ctx context.Context, userID int, id int, in models.Address) (models.Address, error) {
+	cur, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return models.Address{}, err
+	}
+
+	in = normalizeAddress(in)
+	if err := validateAddress(in); err != nil {
+		return models.Address{}, err
+	}
+
+	in.ID = cur.ID
+	in.UserID = userID
+	wantDefault := in.IsDefault
+	in.IsDefault = cur.IsDefault && in.Kind == cur.Kind
+
+	if err := s.repo.Update(ctx, in); err != nil {
+		return models.Address{}, err
+	}
+
+	if cur.IsDefault && in.Kind != cur.Kind {
+		s.promoteDefault(ctx, userID, cur.Kind)
+	}
+	if wantDefault || !hasDefault(s.repo.ListByUser(ctx, userID), in.Kind) {
+		if err := s.repo.SetDefault(ctx, userID, in.Kind, in.ID); err != nil {
+			return models.Address{}, err
+		}
+		in.IsDefault = true
+	}
+	return in, nil
+}
+
+func (s *AddressService) Delete(ctx context.Context, userID int, id int) error {
+	a, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, a.ID); err != nil {
+		return err
+	}
+	if a.IsDefault {
+		s.promoteDefault(ctx, userID, a.Kind)
+	}
+	return nil
+}
+
+func (s *AddressService) SetDefault(ctx context.Context, userID int, id int) (models.Address, error) {
+	a, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return models.Address{}, err
+	}
+	if err := s.repo.SetDefault(ctx, userID, a.Kind, a.ID); err != nil {
+		return models.Address{}, err
+	}
+	a.IsDefault = true
+	return a, nil
+}
+
+// Shipping resolves the address an order ships to: the given one when id is
+// set, otherwise the default shipping address. A nil result means the
+// customer has not saved any shipping address.
+func (s *AddressService) Shipping(ctx context.Context, userID int, id int) (*models.Address, error) {
+	if id > 0 {
+		a, err := s.Get(ctx, userID, id)
+		if err != nil {
+			return nil, err
+		}
+		if a.Kind != models.AddressShipping {
+			return nil, apperr.Invalid("addressId", "not a shipping address")
+		}
+		return &a, nil
+	}
+
+	for _, a := range s.repo.ListByUser(ctx, userID) {
+		if a.Kind == models.AddressShipping && a.IsDefault {
+			return &a, nil
+		}
+	}
+	return nil, nil
+}
+
+// promoteDefault hands the default flag to the oldest remaining address of
+// the kind after the previous default went away.
+func (s *AddressService) promoteDefault(ctx context.Context, userID int, kind string) {
+	for _, a := range s.repo.ListByUser(ctx, userID) {
+		if a.Kind != kind {
+			continue
+		}
+		if err := s.repo.SetDefault(ctx, userID, kind, a.ID); err != nil {
+			log.Printf("[ADDRESSES] promote default failed: userId=%d kind=%s err=%v\n", userID, kind, err)
+		}
+		return
+	}
+}
+
+func hasDefault(list []models.Address, kind string) bool {
+	for _, a := range list {
+		if a.Kind == kind && a.IsDefault {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeAddress(a models.Address) models.Address {
+	a.Label = strings.TrimSpace(a.Label)
+	a.Kind = strings.ToLower(strings.TrimSpace(a.Kind))
+	if a.Kind == "" {
+		a.Kind = models.AddressShipping
+	}
+	a.Recipient = strings.TrimSpace(a.Recipient)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.Region = strings.TrimSpace(a.Region)
+	a.PostalCode = strings.TrimSpace(a.PostalCode)
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.Phone = strings.TrimSpace(a.Phone)
+	return a
+}
+
+func validateAddress(a models.Address) error {
+	if a.Kind != models.AddressShipping && a.Kind != models.AddressBilling {
+		return apperr.Invalid("kind", "kind must be shipping or billing")
+	}
+	if a.Label == "" {
+		return apperr.Invalid("label", "label required")
+	}
+	if a.Recipient == "" {
+		return apperr.Invalid("recipient", "recipient required")
+	}
+	if a.Line1 == "" {
+		return apperr.Invalid("line1", "line1 required")
+	}
+	if a.City == "" {
+		return apperr.Invalid("city", "city required")
+	}
+	if a.PostalCode == "" {
+		return apperr.Invalid("postalCode", "postalCode required")
+	}
+	if len(a.Country) != 2 {
+		return apperr.Invalid("country", "country must be a two-letter code")
+	}
+	for _, f := range []string{a.Label, a.Recipient, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Phone} {
+		if len(f) > 200 {
+			return apperr.Invalid("", "address fields must be at most 200 characters")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+const (
+	apiKeyPrefix      = "bsk_"
+	apiKeyDisplayLen  = len(apiKeyPrefix) + 8
+	maxAPIKeysPerUser = 20
+	maxAPIKeyNameLen  = 64
+	apiKeyTouchEvery  = time.Minute
+	MaxAPIKeyLifetime = 365 * 24 * time.Hour
+)
+
+var ErrInvalidAPIKey = apperr.Unauthorized("invalid or expired api key")
+
+// APIKeyClaims is what a validated key grants. Scopes are already narrowed to
+// what the owner's current role allows, so a demotion takes effect on keys
+// created before it.
+type APIKeyClaims struct {
+	KeyID     int
+	UserID    int
+	Role      string
+	Scopes    []models.Permission
+	ExpiresAt time.Time
+}
+
+// NewAPIKey is returned once on creation; only the hash of Key is stored.
+type NewAPIKey struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+type APIKeyService struct {
+	keys  repository.APIKeyRepository
+	users repository.UserRepository
+}
+
+func NewAPIKeyService(keys repository.APIKeyRepository, users repository.UserRepository) *APIKeyService {
+	return &APIKeyService{keys: keys, users: users}
+}
+
+func (s *APIKeyService) Create(ctx context.Context, userID int, name string, scopes []models.Permission, ttl time.Duration) (NewAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return NewAPIKey{}, apperr.Invalid("name", "name required")
+	}
+	if len(name) > maxAPIKeyNameLen {
+		return NewAPIKey{}, apperr.Invalid("name", fmt.Sprintf("name must be at most %d characters", maxAPIKeyNameLen))
+	}
+	if len(scopes) == 0 {
+		return NewAPIKey{}, apperr.Invalid("scopes", "at least one scope required")
+	}
+	if ttl < 0 || ttl > MaxAPIKeyLifetime {
+		return NewAPIKey{}, apperr.Invalid("expiresInDays", fmt.Sprintf("expiry must be within %d days", int(MaxAPIKeyLifetime.Hours()/24)))
+	}
+
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+
+	allowed := PermissionsFor(u.Role)
+	for _, sc := range scopes {
+		if !slices.Contains(allowed, sc) {
+			return NewAPIKey{}, apperr.Forbidden(fmt.Sprintf("scope %q is not allowed for your role", sc))
+		}
+	}
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	active := 0
+	for _, k := range s.keys.ListByUser(ctx, userID) {
+		if apiKeyUsable(k, time.Now()) {
+			active++
+		}
+	}
+	if active >= maxAPIKeysPerUser {
+		return NewAPIKey{}, apperr.Conflict(fmt.Sprintf("at most %d active api keys allowed", maxAPIKeysPerUser))
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	raw := apiKeyPrefix + secret
+
+	now := time.Now()
+	k := models.APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    raw[:apiKeyDisplayLen],
+		KeyHash:   hashToken(raw),
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		exp := now.Add(ttl)
+		k.ExpiresAt = &exp
+	}
+
+	k, err = s.keys.Create(ctx, k)
+	if err != nil {
+		return NewAPIKey{}, err
+	}
+	log.Printf("[AUTH] api key created: userId=%d keyId=%d scopes=%v\n", userID, k.ID, scopes)
+	return NewAPIKey{APIKey: k, Key: raw}, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context, userID int) []models.APIKey {
+	return s.keys.ListByUser(ctx, userID)
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, userID int, id int) error {
+	if id <= 0 {
+		return apperr.Invalid("id", "invalid id")
+	}
+	if err := s.keys.Revoke(ctx, userID, id); err != nil {
+		return err
+	}
+	log.Printf("[AUTH] api key revoked: userId=%d keyId=%d\n", userID, id)
+	return nil
+}
+
+func (s *APIKeyService) ValidateAPIKey(ctx context.Context, raw string) (APIKeyClaims, error) {
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return APIKeyClaims{}, ErrInvalidAPIKey
+	}
+
+	k, err := s.keys.GetByHash(ctx, hashToken(raw))
+	if err != nil {
+		return APIKeyClaims{}, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if !apiKeyUsable(k, now) {
+		return APIKeyClaims{}, ErrInvalidAPIKey
+	}
+
+	u, err := s.users.GetByID(ctx, k.UserID)
+	if err != nil {
+		return APIKeyClaims{}, ErrInvalidAPIKey
+	}
+
+	// Keys used in a tight loop would otherwise write on every request.
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchEvery {
+		if err := s.keys.TouchLastUsed(ctx, k.ID, now); err != nil {
+			log.Printf("[AUTH] api key last-used update failed: keyId=%d err=%v\n", k.ID, err)
+		}
+	}
+
+	allowed := PermissionsFor(u.Role)
+	scopes := slices.DeleteFunc(slices.Clone(k.Scopes), func(p models.Permission) bool {
+		return !slices.Contains(allowed, p)
+	})
+
+	out := APIKeyClaims{
+		KeyID:  k.ID,
+		UserID: u.ID,
+		Role:   u.Role,
+		Scopes: scopes,
+	}
+	if k.ExpiresAt != nil {
+		out.ExpiresAt = *k.ExpiresAt
+	}
+	return out, nil
+}
+
+func apiKeyUsable(k models.APIKey, now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
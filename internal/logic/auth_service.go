@@ -1,5 +1,465 @@
 package logic
 
-func Login(email, password string) bool {
-	return email == "test@mail.com" && password == "1234"
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidCredentials = apperr.Unauthorized("invalid email or password")
+	ErrInvalidToken       = apperr.Unauthorized("invalid or expired token")
+)
+
+// dummyHash is compared against when a login names no account.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("no such account"), bcrypt.DefaultCost)
+	return h
+})
+
+type TokenPair struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	TokenType    string `json:"tokenType"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+type AccessClaims struct {
+	UserID int
+	Role   string
+	JTI    string
+	MFA    bool
+	// MFAPending marks a session whose role requires two-factor
+	// authentication that this login did not complete.
+	MFAPending bool
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+}
+
+type AuthService struct {
+	users       repository.UserRepository
+	refresh     repository.RefreshTokenRepository
+	revocations repository.RevocationRepository
+	secret      []byte
+	guard       *loginGuard
+	mfaRoles    []string
+
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+func NewAuthService(
+	users repository.UserRepository,
+	refresh repository.RefreshTokenRepository,
+	revocations repository.RevocationRepository,
+	secret string,
+) *AuthService {
+	return &AuthService{
+		users:       users,
+		refresh:     refresh,
+		revocations: revocations,
+		secret:      []byte(secret),
+		guard:       &loginGuard{users: users, policy: DefaultLockoutPolicy},
+		accessTTL:   AccessTokenTTL,
+		refreshTTL:  RefreshTokenTTL,
+	}
+}
+
+func (s *AuthService) AccessTTL() time.Duration {
+	return s.accessTTL
+}
+
+func (s *AuthService) RefreshTTL() time.Duration {
+	return s.refreshTTL
+}
+
+func (s *AuthService) Register(ctx context.Context, email, password string) error {
+	email = normalizeEmail(email)
+	if email == "" || !strings.Contains(email, "@") {
+		return apperr.Invalid("email", "valid email required")
+	}
+	if len(password) < 4 {
+		return apperr.Invalid("password", "password must be at least 4 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.users.Create(ctx, models.User{
+		Email:    email,
+		Password: string(hash),
+		Role:     models.RoleCustomer,
+	})
+}
+
+func (s *AuthService) SetLockoutPolicy(p LockoutPolicy) {
+	s.guard.policy = p
+}
+
+func (s *AuthService) Login(ctx context.Context, email, password, ip string) (TokenPair, error) {
+	email = normalizeEmail(email)
+	now := time.Now()
+
+	if err := s.guard.checkIP(ctx, ip, now); err != nil {
+		s.guard.audit(ctx, email, 0, ip, false, "ip_throttled")
+		return TokenPair{}, err
+	}
+
+	u, err := s.users.GetByEmail(ctx, email)
+	if err != nil {
+		// Spend as long as a wrong password would, so response times do not
+		// tell which addresses have accounts.
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		s.guard.audit(ctx, email, 0, ip, false, "unknown_email")
+		return TokenPair{}, ErrInvalidCredentials
+	}
+
+	// A locked account is rejected before the password is checked so the
+	// lock window cannot be used to keep guessing.
+	if err := s.guard.checkAccount(u, now); err != nil {
+		s.guard.audit(ctx, email, u.ID, ip, false, "locked")
+		return TokenPair{}, err
+	}
+
+	if !s.checkPassword(ctx, &u, password) {
+		s.guard.failed(ctx, u, now)
+		s.guard.audit(ctx, email, u.ID, ip, false, "bad_password")
+		return TokenPair{}, ErrInvalidCredentials
+	}
+
+	// The failure counter is only cleared once the second factor is in, so
+	// knowing the password does not reset the lockout on code guessing.
+	if u.TOTPEnabled {
+		challenge, err := s.signActionToken("mfa", u.ID, MFAChallengeTTL, nil)
+		if err != nil {
+			return TokenPair{}, err
+		}
+		s.guard.audit(ctx, email, u.ID, ip, true, "mfa_challenge")
+		return TokenPair{}, &mfaChallenge{token: challenge}
+	}
+
+	s.guard.succeeded(ctx, u)
+	s.guard.audit(ctx, email, u.ID, ip, true, "")
+	return s.issueTokens(ctx, u, "", false)
+}
+
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	if refreshToken == "" {
+		return TokenPair{}, ErrInvalidToken
+	}
+	hash := hashToken(refreshToken)
+
+	t, err := s.refresh.GetByHash(ctx, hash)
+	if err != nil {
+		return TokenPair{}, ErrInvalidToken
+	}
+
+	// A refresh token that was already rotated is being replayed: assume it
+	// leaked and kill every token descended from the same login.
+	if t.RevokedAt != nil {
+		log.Printf("[AUTH] refresh token reuse detected: userId=%d family=%s\n", t.UserID, t.FamilyID)
+		_ = s.refresh.RevokeFamily(ctx, t.FamilyID)
+		return TokenPair{}, ErrInvalidToken
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return TokenPair{}, ErrInvalidToken
+	}
+
+	if err := s.refresh.Revoke(ctx, hash); err != nil {
+		_ = s.refresh.RevokeFamily(ctx, t.FamilyID)
+		return TokenPair{}, ErrInvalidToken
+	}
+
+	u, err := s.users.GetByID(ctx, t.UserID)
+	if err != nil {
+		return TokenPair{}, ErrInvalidToken
+	}
+
+	return s.issueTokens(ctx, u, t.FamilyID, t.MFA)
+}
+
+func (s *AuthService) Logout(ctx context.Context, claims AccessClaims, refreshToken string) error {
+	if claims.JTI != "" {
+		err := s.revocations.Revoke(ctx, models.RevokedToken{
+			JTI:       claims.JTI,
+			UserID:    claims.UserID,
+			ExpiresAt: claims.ExpiresAt,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if refreshToken != "" {
+		_ = s.refresh.Revoke(ctx, hashToken(refreshToken))
+	}
+	return nil
+}
+
+func (s *AuthService) LogoutAll(ctx context.Context, userID int) error {
+	if userID <= 0 {
+		return apperr.Invalid("userId", "userId must be positive")
+	}
+	if err := s.revocations.RevokeAllForUser(ctx, userID, time.Now()); err != nil {
+		return err
+	}
+	return s.refresh.RevokeAllForUser(ctx, userID)
+}
+
+func (s *AuthService) ParseAccessToken(ctx context.Context, token string) (AccessClaims, error) {
+	tok, err := jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return s.secret, nil
+	}, jwt.WithExpirationRequired(), jwt.WithIssuedAt())
+	if err != nil || tok == nil || !tok.Valid {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	claims, ok := tok.Claims.(jwt.MapClaims)
+	if !ok {
+		return AccessClaims{}, ErrInvalidToken
+	}
+	if typ, _ := claims["typ"].(string); typ != "access" {
+		return AccessClaims{}, ErrInvalidToken
+	}
+
+	idf, ok := claims["userId"].(float64)
+	if !ok {
+		return AccessClaims{}, ErrInvalidToken
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return AccessClaims{}, ErrInvalidToken
+	}
+	role, _ := claims["role"].(string)
+	mfa, _ := claims["mfa"].(bool)
+	iat, _ := claims.GetIssuedAt()
+	exp, _ := claims.GetExpirationTime()
+
+	out := AccessClaims{
+		UserID: int(idf),
+		Role:   role,
+		JTI:    jti,
+		MFA:    mfa,
+
+		MFAPending: !mfa && s.MFARequiredFor(role),
+	}
+	if iat != nil {
+		out.IssuedAt = iat.Time
+	}
+	if us, ok := claims["iatUs"].(float64); ok {
+		out.IssuedAt = time.UnixMicro(int64(us))
+	}
+	if exp != nil {
+		out.ExpiresAt = exp.Time
+	}
+
+	if err := s.checkRevocation(ctx, out); err != nil {
+		return AccessClaims{}, err
+	}
+	return out, nil
+}
+
+func (s *AuthService) checkRevocation(ctx context.Context, c AccessClaims) error {
+	revoked, err := s.revocations.IsRevoked(ctx, c.JTI)
+	if err != nil {
+		log.Printf("[AUTH] revocation lookup failed: jti=%s err=%v\n", c.JTI, err)
+		return ErrInvalidToken
+	}
+	if revoked {
+		return ErrInvalidToken
+	}
+
+	cutoff, err := s.revocations.RevokedBefore(ctx, c.UserID)
+	if err != nil {
+		log.Printf("[AUTH] revocation lookup failed: userId=%d err=%v\n", c.UserID, err)
+		return ErrInvalidToken
+	}
+	// The cutoff is kept to the microsecond, like iatUs, so a token issued
+	// in the same second as a logout is still judged by which came first.
+	if !cutoff.IsZero() && !c.IssuedAt.After(cutoff) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *AuthService) signActionToken(purpose string, userID int, ttl time.Duration, extra jwt.MapClaims) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":    jti,
+		"userId": userID,
+		"typ":    purpose,
+		"iat":    now.Unix(),
+		"exp":    now.Add(ttl).Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
+}
+
+func (s *AuthService) parseActionToken(token string, purpose string) (jwt.MapClaims, error) {
+	tok, err := jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return s.secret, nil
+	}, jwt.WithExpirationRequired())
+	if err != nil || tok == nil || !tok.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := tok.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if typ, _ := claims["typ"].(string); typ != purpose {
+		return nil, ErrInvalidToken
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return nil, ErrInvalidToken
+	}
+	if _, ok := claims["userId"].(float64); !ok {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (s *AuthService) consumeActionToken(ctx context.Context, claims jwt.MapClaims) error {
+	jti, _ := claims["jti"].(string)
+	userID, _ := claims["userId"].(float64)
+
+	var expiresAt time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
+	}
+
+	fresh, err := s.revocations.RevokeOnce(ctx, models.RevokedToken{
+		JTI:       jti,
+		UserID:    int(userID),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *AuthService) checkPassword(ctx context.Context, u *models.User, password string) bool {
+	if strings.HasPrefix(u.Password, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+	}
+
+	// Accounts created before hashing was introduced still hold the plain
+	// password; accept it once and upgrade the stored value in place.
+	if subtle.ConstantTimeCompare([]byte(u.Password), []byte(password)) != 1 {
+		return false
+	}
+	if hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err == nil {
+		u.Password = string(hash)
+		if err := s.users.Update(ctx, *u); err != nil {
+			log.Printf("[AUTH] password rehash failed: userId=%d err=%v\n", u.ID, err)
+		}
+	}
+	return true
+}
+
+func (s *AuthService) issueTokens(ctx context.Context, u models.User, familyID string, mfa bool) (TokenPair, error) {
+	now := time.Now()
+	jti, err := randomToken(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":    jti,
+		"userId": u.ID,
+		"role":   u.Role,
+		"typ":    "access",
+		"mfa":    mfa,
+		"iat":    now.Unix(),
+		// iat only has whole seconds; logout-all cutoffs need finer.
+		"iatUs": now.UnixMicro(),
+		"exp":   now.Add(s.accessTTL).Unix(),
+	}).SignedString(s.secret)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if familyID == "" {
+		if familyID, err = randomToken(16); err != nil {
+			return TokenPair{}, err
+		}
+	}
+
+	err = s.refresh.Create(ctx, models.RefreshToken{
+		TokenHash: hashToken(refresh),
+		UserID:    u.ID,
+		FamilyID:  familyID,
+		MFA:       mfa,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.refreshTTL),
+	})
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTTL.Seconds()),
+	}, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
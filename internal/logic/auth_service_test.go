@@ -0,0 +1,41 @@
+package logic
+
+import (
+	"testing"
+
+	"bookstore/internal/repository"
+)
+
+func TestLogoutAllCutoff(t *testing.T) {
+	ctx := t.Context()
+	stores := repository.NewMemoryStores()
+	s := NewAuthService(stores.Users, stores.RefreshTokens, stores.Revocations, "secret")
+	if err := s.Register(ctx, "a@example.com", "secret"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	u, _ := stores.Users.GetByEmail(ctx, "a@example.com")
+
+	login := func() string {
+		t.Helper()
+		pair, err := s.Login(ctx, "a@example.com", "secret", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		return pair.AccessToken
+	}
+
+	// Both logins usually land in the same second as the logout, which is
+	// where whole-second iat claims and the cutoff used to disagree.
+	before := login()
+	if err := s.LogoutAll(ctx, u.ID); err != nil {
+		t.Fatalf("LogoutAll: %v", err)
+	}
+	after := login()
+
+	if _, err := s.ParseAccessToken(ctx, before); err != ErrInvalidToken {
+		t.Errorf("token from before LogoutAll: err = %v, want invalid token", err)
+	}
+	if _, err := s.ParseAccessToken(ctx, after); err != nil {
+		t.Errorf("token from after LogoutAll: %v", err)
+	}
+}
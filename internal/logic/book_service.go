@@ -3,18 +3,21 @@ package logic
 import (
 	"context"
 	"errors"
+	"log"
 	"strings"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 	"bookstore/internal/repository"
 )
 
 type BookService struct {
-	repo repository.BookRepository
+	repo      repository.BookRepository
+	inventory *InventoryService
 }
 
-func NewBookService(repo repository.BookRepository) *BookService {
-	return &BookService{repo: repo}
+func NewBookService(repo repository.BookRepository, inventory *InventoryService) *BookService {
+	return &BookService{repo: repo, inventory: inventory}
 }
 
 func (s *BookService) ListBooks(ctx context.Context, q models.BookQuery) ([]models.Book, error) {
@@ -41,36 +44,85 @@ func (s *BookService) ListBooks(ctx context.Context, q models.BookQuery) ([]mode
 	return s.repo.Find(ctx, q)
 }
 
-func (s *BookService) GetBook(id int) (models.Book, error) {
-	return s.repo.GetByID(id)
+func (s *BookService) GetBook(ctx context.Context, id int) (models.Book, error) {
+	return s.repo.GetByID(ctx, id)
 }
 
-func (s *BookService) CreateBook(b models.Book) (models.Book, error) {
+func (s *BookService) CreateBook(ctx context.Context, b models.Book) (models.Book, error) {
 	if b.Title == "" || b.Author == "" {
-		return models.Book{}, errors.New("title and author are required")
+		return models.Book{}, apperr.Invalid("", "title and author are required")
 	}
 	if b.Price < 0 {
-		return models.Book{}, errors.New("price cannot be negative")
+		return models.Book{}, apperr.Invalid("price", "price cannot be negative")
 	}
-	return s.repo.Create(b)
+	if b.Stock != nil && *b.Stock < 0 {
+		return models.Book{}, apperr.Invalid("stock", "stock cannot be negative")
+	}
+
+	created, err := s.repo.Create(ctx, b)
+	if err != nil {
+		return models.Book{}, err
+	}
+	if err := s.inventory.recordInitial(ctx, created); err != nil {
+		log.Printf("[INVENTORY] failed to record initial stock: bookId=%d err=%v\n", created.ID, err)
+	}
+	return created, nil
 }
 
-func (s *BookService) UpdateBook(b models.Book) error {
+// UpdateBook saves b and returns it with its new version. When b.Version is
+// set the update only applies if nobody else has saved the book since.
+func (s *BookService) UpdateBook(ctx context.Context, b models.Book) (models.Book, error) {
 	if b.ID <= 0 {
-		return errors.New("invalid id")
+		return models.Book{}, apperr.Invalid("id", "invalid id")
 	}
 	if b.Title == "" || b.Author == "" {
-		return errors.New("title and author are required")
+		return models.Book{}, apperr.Invalid("", "title and author are required")
 	}
 	if b.Price < 0 {
-		return errors.New("price cannot be negative")
+		return models.Book{}, apperr.Invalid("price", "price cannot be negative")
+	}
+	return s.repo.Update(ctx, b)
+}
+
+// DeleteBook hides the book from the catalogue. It stays in the database,
+// so orders that contain it still render, until the purge job removes it.
+func (s *BookService) DeleteBook(ctx context.Context, id, actorID int) error {
+	if id <= 0 {
+		return apperr.Invalid("id", "invalid id")
 	}
-	return s.repo.Update(b)
+	return s.repo.Delete(ctx, id, actorID)
 }
 
-func (s *BookService) DeleteBook(id int) error {
+func (s *BookService) RestoreBook(ctx context.Context, id int) (models.Book, error) {
 	if id <= 0 {
-		return errors.New("invalid id")
+		return models.Book{}, apperr.Invalid("id", "invalid id")
+	}
+	if err := s.repo.Restore(ctx, id); err != nil {
+		return models.Book{}, err
+	}
+	return s.repo.GetByID(ctx, id)
+}
+
+// BooksByID looks up books by id, deleted ones included. It is meant for
+// rendering history, not for anything a customer can still buy.
+func (s *BookService) BooksByID(ctx context.Context, ids []int) (map[int]models.Book, error) {
+	books, err := s.repo.FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int]models.Book, len(books))
+	for _, b := range books {
+		out[b.ID] = b
+	}
+	return out, nil
+}
+
+// missingBook turns a failed lookup of a book the caller referred to by id
+// into a validation error. Anything other than "not found" is passed through
+// so a database outage is not reported as a bad request.
+func missingBook(err error) error {
+	if errors.Is(err, apperr.ErrNotFound) {
+		return apperr.Invalid("bookId", "book not found")
 	}
-	return s.repo.Delete(id)
+	return err
 }
@@ -1,58 +1,138 @@
 package logic
 
 import (
-	"errors"
+	"context"
+	"log"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 	"bookstore/internal/repository"
 )
 
+// CartCRUDService keeps a stock hold for every line in a cart, so what a
+// customer has in the cart cannot be sold to someone else until the hold
+// expires.
 type CartCRUDService struct {
-	repo     repository.CartRepository
-	bookRepo repository.BookRepository
+	repo      repository.CartRepository
+	bookRepo  repository.BookRepository
+	inventory *InventoryService
 }
 
-func NewCartCRUDService(repo repository.CartRepository, bookRepo repository.BookRepository) *CartCRUDService {
-	return &CartCRUDService{repo: repo, bookRepo: bookRepo}
+func NewCartCRUDService(repo repository.CartRepository, bookRepo repository.BookRepository, inventory *InventoryService) *CartCRUDService {
+	return &CartCRUDService{repo: repo, bookRepo: bookRepo, inventory: inventory}
 }
 
-func (s *CartCRUDService) CreateCart(customerID int) models.Cart {
+func (s *CartCRUDService) CreateCart(ctx context.Context, customerID int) (models.Cart, error) {
 	if customerID <= 0 {
 		customerID = 1
 	}
-	return s.repo.Create(customerID)
+	return s.repo.Create(ctx, customerID)
 }
 
-func (s *CartCRUDService) ListCarts() []models.Cart {
-	return s.repo.GetAll()
+func (s *CartCRUDService) ListCarts(ctx context.Context) []models.Cart {
+	return s.repo.GetAll(ctx)
 }
 
-func (s *CartCRUDService) GetCart(id int) (models.Cart, []models.CartItem, error) {
-	return s.repo.GetByID(id)
+func (s *CartCRUDService) GetCart(ctx context.Context, id int) (models.Cart, []models.CartItem, error) {
+	return s.repo.GetByID(ctx, id)
 }
 
-func (s *CartCRUDService) UpdateCart(c models.Cart) error {
+func (s *CartCRUDService) UpdateCart(ctx context.Context, c models.Cart) (models.Cart, error) {
 	if c.ID <= 0 {
-		return errors.New("cart id must be positive")
+		return models.Cart{}, apperr.Invalid("id", "cart id must be positive")
 	}
-	return s.repo.Update(c)
+	return s.repo.Update(ctx, c)
 }
 
-func (s *CartCRUDService) DeleteCart(id int) error {
-	return s.repo.Delete(id)
+func (s *CartCRUDService) DeleteCart(ctx context.Context, id int) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := s.inventory.ReleaseCart(ctx, id); err != nil {
+		log.Printf("[INVENTORY] failed to release holds of deleted cart: cartId=%d err=%v\n", id, err)
+	}
+	return nil
+}
+
+// AddItem holds the extra copies before adding them, and gives the hold back
+// if the cart refuses the item.
+func (s *CartCRUDService) AddItem(ctx context.Context, cartID int, bookID int, qty int) (models.CartItem, error) {
+	if qty <= 0 {
+		return models.CartItem{}, apperr.Invalid("qty", "qty must be positive")
+	}
+	if _, err := s.bookRepo.GetByID(ctx, bookID); err != nil {
+		return models.CartItem{}, missingBook(err)
+	}
+	_, items, err := s.repo.GetByID(ctx, cartID)
+	if err != nil {
+		return models.CartItem{}, err
+	}
+
+	held := 0
+	for _, it := range items {
+		if it.BookID == bookID {
+			held += it.Qty
+		}
+	}
+	if err := s.inventory.Hold(ctx, cartID, bookID, held+qty); err != nil {
+		return models.CartItem{}, err
+	}
+
+	item, err := s.repo.AddItem(ctx, cartID, bookID, qty)
+	if err != nil {
+		s.restoreHold(ctx, cartID, bookID, held)
+		return models.CartItem{}, err
+	}
+	return item, nil
 }
 
-func (s *CartCRUDService) AddItem(cartID int, bookID int, qty int) (models.CartItem, error) {
-	if _, err := s.bookRepo.GetByID(bookID); err != nil {
-		return models.CartItem{}, errors.New("book not found")
+func (s *CartCRUDService) UpdateItem(ctx context.Context, cartID int, itemID int, qty int) error {
+	if qty <= 0 {
+		return apperr.Invalid("qty", "qty must be positive")
+	}
+	item, err := s.item(ctx, cartID, itemID)
+	if err != nil {
+		return err
+	}
+	if err := s.inventory.Hold(ctx, cartID, item.BookID, qty); err != nil {
+		return err
 	}
-	return s.repo.AddItem(cartID, bookID, qty)
+	if err := s.repo.UpdateItem(ctx, cartID, itemID, qty); err != nil {
+		s.restoreHold(ctx, cartID, item.BookID, item.Qty)
+		return err
+	}
+	return nil
 }
 
-func (s *CartCRUDService) UpdateItem(cartID int, itemID int, qty int) error {
-	return s.repo.UpdateItem(cartID, itemID, qty)
+func (s *CartCRUDService) DeleteItem(ctx context.Context, cartID int, itemID int) error {
+	item, err := s.item(ctx, cartID, itemID)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteItem(ctx, cartID, itemID); err != nil {
+		return err
+	}
+	s.restoreHold(ctx, cartID, item.BookID, 0)
+	return nil
 }
 
-func (s *CartCRUDService) DeleteItem(cartID int, itemID int) error {
-	return s.repo.DeleteItem(cartID, itemID)
+func (s *CartCRUDService) item(ctx context.Context, cartID, itemID int) (models.CartItem, error) {
+	_, items, err := s.repo.GetByID(ctx, cartID)
+	if err != nil {
+		return models.CartItem{}, err
+	}
+	for _, it := range items {
+		if it.ID == itemID {
+			return it, nil
+		}
+	}
+	return models.CartItem{}, apperr.NotFound("item not found")
+}
+
+// restoreHold puts a hold back to qty after the cart change it was made for
+// did not happen. A failure only leaves the hold too large until it expires.
+func (s *CartCRUDService) restoreHold(ctx context.Context, cartID, bookID, qty int) {
+	if err := s.inventory.Hold(context.WithoutCancel(ctx), cartID, bookID, qty); err != nil {
+		log.Printf("[INVENTORY] failed to reset hold: cartId=%d bookId=%d err=%v\n", cartID, bookID, err)
+	}
 }
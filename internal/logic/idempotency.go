@@ -0,0 +1,79 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+// DefaultIdempotencyRetention is how long the response to a keyed request is
+// kept for replay.
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+const (
+	// idempotencyLease bounds how long a claimed key waits for its response.
+	// A request that dies half way frees its key once the lease runs out.
+	idempotencyLease = time.Minute
+
+	maxIdempotencyKey = 255
+)
+
+type IdempotencyService struct {
+	repo      repository.IdempotencyRepository
+	retention time.Duration
+}
+
+func NewIdempotencyService(repo repository.IdempotencyRepository, retention time.Duration) *IdempotencyService {
+	if retention <= 0 {
+		retention = DefaultIdempotencyRetention
+	}
+	return &IdempotencyService{repo: repo, retention: retention}
+}
+
+// Begin claims key for a request by userID to scope, the endpoint it was
+// sent to. It returns the stored record and true when the same request was
+// answered before, so the caller can replay the response. Reusing a key for a
+// different request, or while the first one is still running, is a conflict.
+func (s *IdempotencyService) Begin(ctx context.Context, userID int, scope, key, fingerprint string) (models.IdempotencyRecord, bool, error) {
+	if key == "" || len(key) > maxIdempotencyKey {
+		return models.IdempotencyRecord{}, false, apperr.Invalid("idempotencyKey",
+			fmt.Sprintf("idempotency key must be 1 to %d characters", maxIdempotencyKey))
+	}
+
+	now := time.Now()
+	rec, claimed, err := s.repo.Claim(ctx, models.IdempotencyRecord{
+		Key:         fmt.Sprintf("%d:%s:%s", userID, scope, key),
+		UserID:      userID,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(idempotencyLease),
+	})
+	if err != nil || claimed {
+		return rec, false, err
+	}
+
+	if rec.Fingerprint != fingerprint {
+		return models.IdempotencyRecord{}, false, apperr.Conflict("idempotency key was already used for a different request")
+	}
+	if rec.Status == 0 {
+		return models.IdempotencyRecord{}, false, apperr.Conflict("a request with this idempotency key is still in progress")
+	}
+	return rec, true, nil
+}
+
+// Finish stores the response to a request claimed by Begin for the
+// retention window.
+func (s *IdempotencyService) Finish(ctx context.Context, rec models.IdempotencyRecord) error {
+	rec.ExpiresAt = time.Now().Add(s.retention)
+	return s.repo.Complete(ctx, rec)
+}
+
+// Abandon frees a claimed key without storing a response, for requests that
+// failed in a way the client should be able to retry.
+func (s *IdempotencyService) Abandon(ctx context.Context, rec models.IdempotencyRecord) error {
+	return s.repo.Release(ctx, rec)
+}
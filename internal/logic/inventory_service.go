@@ -0,0 +1,183 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+// DefaultReservationTTL is how long a cart holds stock after its last
+// change.
+const DefaultReservationTTL = 15 * time.Minute
+
+type InventoryService struct {
+	repo repository.InventoryRepository
+	tx   repository.Transactions
+	ttl  time.Duration
+}
+
+// NewInventoryService keeps stock in repo. tx must cover the order
+// repository too, so that taking stock and storing the order it was taken
+// for can commit together.
+func NewInventoryService(repo repository.InventoryRepository, tx repository.Transactions, ttl time.Duration) *InventoryService {
+	if ttl <= 0 {
+		ttl = DefaultReservationTTL
+	}
+	return &InventoryService{repo: repo, tx: tx, ttl: ttl}
+}
+
+// Hold sets cartID's hold on bookID to qty and restarts its expiry.
+func (s *InventoryService) Hold(ctx context.Context, cartID, bookID, qty int) error {
+	return s.repo.Reserve(ctx, cartID, bookID, qty, time.Now().Add(s.ttl))
+}
+
+func (s *InventoryService) ReleaseCart(ctx context.Context, cartID int) error {
+	return s.repo.ReleaseCart(ctx, cartID)
+}
+
+// takeFor takes items from stock, using up cartID's holds first, and calls
+// create to store the order they were taken for; create returns the new
+// order's id. Where the backend has transactions both happen in one.
+// Otherwise the stock is put back when create fails, and if that fails too
+// the error says so: the copies are then off the shelf with no order to
+// show for them.
+func (s *InventoryService) takeFor(ctx context.Context, cartID int, items []models.OrderItem, create func(ctx context.Context) (int, error)) error {
+	var taken []models.StockMovement
+	var orderID int
+	ran, err := s.tx.Run(ctx, func(ctx context.Context) error {
+		var err error
+		if taken, err = s.repo.Take(ctx, cartID, items); err != nil {
+			return err
+		}
+		orderID, err = create(ctx)
+		return err
+	})
+
+	if !ran {
+		taken, err = s.repo.Take(ctx, cartID, items)
+		if err != nil {
+			return err
+		}
+		orderID, err = create(ctx)
+		if err != nil {
+			if perr := s.putBack(ctx, taken); perr != nil {
+				return fmt.Errorf("order not created (%v) and its stock not put back: %w", err, perr)
+			}
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	s.commit(ctx, orderID, taken)
+	return nil
+}
+
+// commit records stock taken for an order in the ledger. The stock has
+// already left the shelf, so a failure here is logged rather than returned.
+func (s *InventoryService) commit(ctx context.Context, orderID int, taken []models.StockMovement) {
+	for i := range taken {
+		taken[i].OrderID = orderID
+	}
+	if _, err := s.repo.Record(ctx, taken...); err != nil {
+		log.Printf("[INVENTORY] failed to record stock movements: orderId=%d err=%v\n", orderID, err)
+	}
+}
+
+// putBack returns stock taken for an order that was never created. It runs
+// even when ctx is what made the order fail.
+func (s *InventoryService) putBack(ctx context.Context, taken []models.StockMovement) error {
+	items := make([]models.OrderItem, 0, len(taken))
+	for _, m := range taken {
+		items = append(items, models.OrderItem{BookID: m.BookID, Qty: -m.Delta})
+	}
+	_, err := s.repo.Restock(context.WithoutCancel(ctx), items)
+	return err
+}
+
+// restock puts copies from an order back on the shelf and records why,
+// e.g. models.MovementCancel. Money or status changes have already happened
+// by then, so failures are logged rather than returned.
+func (s *InventoryService) restock(ctx context.Context, orderID, actorID int, reason string, items []models.OrderItem) {
+	moved, err := s.repo.Restock(ctx, items)
+	if err != nil {
+		log.Printf("[INVENTORY] failed to restock order: orderId=%d err=%v\n", orderID, err)
+		return
+	}
+	for i := range moved {
+		moved[i].OrderID = orderID
+		moved[i].ActorID = actorID
+		moved[i].Reason = reason
+	}
+	if _, err := s.repo.Record(ctx, moved...); err != nil {
+		log.Printf("[INVENTORY] failed to record restock: orderId=%d err=%v\n", orderID, err)
+	}
+}
+
+// Adjust changes a book's stock by delta and records who did it and why. An
+// untracked book starts being tracked, so a delta of 0 tracks it at zero.
+func (s *InventoryService) Adjust(ctx context.Context, bookID, delta, actorID int, note string) (models.StockMovement, error) {
+	if bookID <= 0 {
+		return models.StockMovement{}, apperr.Invalid("bookId", "bookId must be positive")
+	}
+
+	m, err := s.repo.Adjust(ctx, bookID, delta)
+	if err != nil {
+		return models.StockMovement{}, err
+	}
+	m.ActorID = actorID
+	m.Note = note
+	recorded, err := s.repo.Record(ctx, m)
+	if err != nil {
+		return models.StockMovement{}, err
+	}
+	return recorded[0], nil
+}
+
+func (s *InventoryService) Movements(ctx context.Context, bookID, limit int) []models.StockMovement {
+	return s.repo.Movements(ctx, bookID, limit)
+}
+
+// recordInitial puts the stock a book was created with in the ledger.
+func (s *InventoryService) recordInitial(ctx context.Context, b models.Book) error {
+	if b.Stock == nil {
+		return nil
+	}
+	_, err := s.repo.Record(ctx, models.StockMovement{
+		BookID:     b.ID,
+		Delta:      *b.Stock,
+		StockAfter: *b.Stock,
+		Reason:     models.MovementInitial,
+	})
+	return err
+}
+
+// StartReservationSweeper releases expired cart holds every interval until
+// ctx is cancelled.
+func StartReservationSweeper(ctx context.Context, interval time.Duration, s *InventoryService) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				n, err := s.repo.ReleaseExpired(ctx, now)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("[INVENTORY] releasing expired holds failed: %v\n", err)
+				}
+				if n > 0 {
+					log.Printf("[INVENTORY] expired holds released: count=%d\n", n)
+				}
+			}
+		}
+	}()
+}
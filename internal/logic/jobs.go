@@ -1,6 +1,7 @@
 package logic
 
 import (
+	"context"
 	"log"
 
 	"bookstore/internal/repository"
@@ -23,27 +24,39 @@ type OrderJob struct {
 
 var OrderJobQueue = make(chan OrderJob, 100)
 
-func StartOrderWorkerPool(workerCount int, cartRepo repository.CartRepository, wishlistRepo repository.WishlistRepository) {
+// StartOrderWorkerPool runs workerCount workers until ctx is cancelled. Jobs
+// still queued at that point are dropped.
+func StartOrderWorkerPool(ctx context.Context, workerCount int, cartRepo repository.CartRepository, wishlistRepo repository.WishlistRepository) {
 	log.Printf("[ORDER WORKERS] starting %d workers...\n", workerCount)
 
 	for i := 1; i <= workerCount; i++ {
 		go func(workerID int) {
 			log.Printf("[ORDER WORKER %d] started\n", workerID)
 
-			for job := range OrderJobQueue {
+			for {
+				var job OrderJob
+				select {
+				case <-ctx.Done():
+					log.Printf("[ORDER WORKER %d] stopped\n", workerID)
+					return
+				case job = <-OrderJobQueue:
+				}
+
 				log.Printf("[ORDER WORKER %d] got job: type=%s orderId=%d cartId=%d wishlistId=%d\n",
 					workerID, job.Type, job.OrderID, job.CartID, job.WishlistID)
 
 				switch job.Type {
 				case JobClearCart:
-					if err := cartRepo.ClearCart(job.CartID); err != nil {
+					if err := cartRepo.ClearCart(ctx, job.CartID); err != nil {
 						log.Printf("[ORDER WORKER %d] ClearCart failed: %v\n", workerID, err)
 					} else {
 						log.Printf("[ORDER WORKER %d] cart cleared: cartId=%d\n", workerID, job.CartID)
 					}
 
 				case JobClearWishlist:
-					if err := wishlistRepo.Delete(job.WishlistID); err != nil {
+					// deletedBy 0 marks a deletion made by the system
+					// rather than by a user.
+					if err := wishlistRepo.Delete(ctx, job.WishlistID, 0); err != nil {
 						log.Printf("[ORDER WORKER %d] Delete wishlist failed: %v\n", workerID, err)
 					} else {
 						log.Printf("[ORDER WORKER %d] wishlist cleared: wishlistId=%d\n", workerID, job.WishlistID)
@@ -0,0 +1,145 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+var (
+	ErrAccountLocked   = apperr.Locked("account temporarily locked after repeated failed logins")
+	ErrTooManyAttempts = apperr.RateLimited("too many failed login attempts from this address")
+)
+
+type LockoutPolicy struct {
+	// Failed logins allowed before the account starts locking.
+	FreeAttempts int
+	BaseLockout  time.Duration
+	MaxLockout   time.Duration
+
+	IPWindow      time.Duration
+	IPMaxFailures int
+}
+
+var DefaultLockoutPolicy = LockoutPolicy{
+	FreeAttempts:  5,
+	BaseLockout:   30 * time.Second,
+	MaxLockout:    time.Hour,
+	IPWindow:      15 * time.Minute,
+	IPMaxFailures: 30,
+}
+
+// loginAttemptRetention is how long the login audit trail is kept. It has to
+// outlast IPWindow, which counts failures from it.
+const loginAttemptRetention = 90 * 24 * time.Hour
+
+// lockoutFor doubles the lock for every failure past the free attempts.
+func (p LockoutPolicy) lockoutFor(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	d := p.BaseLockout
+	for i := 1; i < over && d < p.MaxLockout; i++ {
+		d *= 2
+	}
+	if d > p.MaxLockout {
+		d = p.MaxLockout
+	}
+	return d
+}
+
+type throttleError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *throttleError) Error() string { return e.err.Error() }
+func (e *throttleError) Unwrap() error { return e.err }
+
+// RetryAfter reports how long a throttled login should wait, or zero.
+func RetryAfter(err error) time.Duration {
+	var te *throttleError
+	if errors.As(err, &te) {
+		return te.retryAfter
+	}
+	return 0
+}
+
+// ipFailureReasons are the audit reasons that count towards the per-IP limit.
+// The guard's own refusals are left out so a throttled address does not keep
+// extending its own window.
+var ipFailureReasons = []string{"bad_password", "unknown_email"}
+
+type loginGuard struct {
+	users  repository.UserRepository
+	policy LockoutPolicy
+}
+
+func (g *loginGuard) checkIP(ctx context.Context, ip string, now time.Time) error {
+	if ip == "" || g.policy.IPMaxFailures <= 0 {
+		return nil
+	}
+	n, err := g.users.CountFailedLoginsByIP(ctx, ip, now.Add(-g.policy.IPWindow), ipFailureReasons)
+	if err != nil {
+		log.Printf("[AUTH] login attempt count failed: ip=%s err=%v\n", ip, err)
+		return nil
+	}
+	if n >= g.policy.IPMaxFailures {
+		return &throttleError{err: ErrTooManyAttempts, retryAfter: g.policy.IPWindow}
+	}
+	return nil
+}
+
+func (g *loginGuard) checkAccount(u models.User, now time.Time) error {
+	if u.LockedUntil != nil && now.Before(*u.LockedUntil) {
+		return &throttleError{err: ErrAccountLocked, retryAfter: u.LockedUntil.Sub(now)}
+	}
+	return nil
+}
+
+func (g *loginGuard) failed(ctx context.Context, u models.User, now time.Time) {
+	updated, err := g.users.RecordLoginFailure(ctx, u.ID, now)
+	if err != nil {
+		log.Printf("[AUTH] record login failure: userId=%d err=%v\n", u.ID, err)
+		return
+	}
+	if d := g.policy.lockoutFor(updated.FailedLogins); d > 0 {
+		until := now.Add(d)
+		if err := g.users.SetLockout(ctx, u.ID, &until); err != nil {
+			log.Printf("[AUTH] set lockout: userId=%d err=%v\n", u.ID, err)
+			return
+		}
+		log.Printf("[AUTH] account locked: userId=%d failures=%d until=%s\n", u.ID, updated.FailedLogins, until.Format(time.RFC3339))
+	}
+}
+
+func (g *loginGuard) succeeded(ctx context.Context, u models.User) {
+	if u.FailedLogins == 0 && u.LockedUntil == nil {
+		return
+	}
+	if err := g.users.ResetLoginFailures(ctx, u.ID); err != nil {
+		log.Printf("[AUTH] reset login failures: userId=%d err=%v\n", u.ID, err)
+	}
+}
+
+func (g *loginGuard) audit(ctx context.Context, email string, userID int, ip string, success bool, reason string) {
+	now := time.Now()
+	err := g.users.AddLoginAttempt(ctx, models.LoginAttempt{
+		Email:     email,
+		UserID:    userID,
+		IP:        ip,
+		Success:   success,
+		Reason:    reason,
+		CreatedAt: now,
+		ExpiresAt: now.Add(loginAttemptRetention),
+	})
+	if err != nil {
+		log.Printf("[AUTH] login audit failed: email=%s err=%v\n", email, err)
+	}
+}
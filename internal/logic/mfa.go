@@ -0,0 +1,267 @@
+package logic
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+const (
+	MFAChallengeTTL = 5 * time.Minute
+
+	totpIssuer        = "Online Bookstore"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFARequired       = apperr.Unauthorized("two-factor code required")
+	ErrInvalidMFACode    = apperr.Unauthorized("invalid two-factor code")
+	ErrMFAAlreadyEnabled = apperr.Conflict("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = apperr.Conflict("two-factor authentication is not enabled")
+)
+
+type mfaChallenge struct {
+	token string
+}
+
+func (e *mfaChallenge) Error() string { return ErrMFARequired.Error() }
+func (e *mfaChallenge) Unwrap() error { return ErrMFARequired }
+
+// MFAChallenge returns the token to hand back with the second factor when
+// Login stopped at ErrMFARequired.
+func MFAChallenge(err error) string {
+	var c *mfaChallenge
+	if errors.As(err, &c) {
+		return c.token
+	}
+	return ""
+}
+
+type TOTPSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+func (s *AuthService) SetMFARequiredRoles(roles []string) {
+	s.mfaRoles = roles
+}
+
+func (s *AuthService) MFARequiredFor(role string) bool {
+	return slices.Contains(s.mfaRoles, role)
+}
+
+func (s *AuthService) TwoFactorStatus(ctx context.Context, userID int) (enabled bool, since *time.Time, err error) {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	return u.TOTPEnabled, u.TOTPEnabledAt, nil
+}
+
+func (s *AuthService) CompleteMFA(ctx context.Context, challenge, code, ip string) (TokenPair, error) {
+	claims, err := s.parseActionToken(challenge, "mfa")
+	if err != nil {
+		return TokenPair{}, err
+	}
+	userID, _ := claims["userId"].(float64)
+
+	now := time.Now()
+	if err := s.guard.checkIP(ctx, ip, now); err != nil {
+		return TokenPair{}, err
+	}
+
+	u, err := s.users.GetByID(ctx, int(userID))
+	if err != nil || !u.TOTPEnabled {
+		return TokenPair{}, ErrInvalidToken
+	}
+	if err := s.guard.checkAccount(u, now); err != nil {
+		s.guard.audit(ctx, u.Email, u.ID, ip, false, "locked")
+		return TokenPair{}, err
+	}
+
+	ok, err := s.verifySecondFactor(ctx, u, code)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if !ok {
+		s.guard.failed(ctx, u, now)
+		s.guard.audit(ctx, u.Email, u.ID, ip, false, "bad_mfa_code")
+		return TokenPair{}, ErrInvalidMFACode
+	}
+
+	if err := s.consumeActionToken(ctx, claims); err != nil {
+		return TokenPair{}, err
+	}
+
+	s.guard.succeeded(ctx, u)
+	s.guard.audit(ctx, u.Email, u.ID, ip, true, "")
+	return s.issueTokens(ctx, u, "", true)
+}
+
+func (s *AuthService) BeginTOTPSetup(ctx context.Context, userID int) (TOTPSetup, error) {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	if u.TOTPEnabled {
+		return TOTPSetup{}, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return TOTPSetup{}, err
+	}
+	if err := s.users.SetTOTP(ctx, u.ID, secret, false, nil); err != nil {
+		return TOTPSetup{}, err
+	}
+
+	return TOTPSetup{
+		Secret:     secret,
+		OTPAuthURI: otpauthURI(totpIssuer, u.Email, secret),
+	}, nil
+}
+
+// EnableTOTP confirms the pending secret and returns the recovery codes (shown
+// once) plus a fresh session that counts as two-factor authenticated.
+func (s *AuthService) EnableTOTP(ctx context.Context, userID int, code string) ([]string, TokenPair, error) {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	if u.TOTPEnabled {
+		return nil, TokenPair{}, ErrMFAAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, TokenPair{}, apperr.Conflict("start two-factor setup first")
+	}
+
+	step, ok := matchTOTP(u.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, TokenPair{}, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	if err := s.users.SetTOTP(ctx, u.ID, u.TOTPSecret, true, hashes); err != nil {
+		return nil, TokenPair{}, err
+	}
+	if _, err := s.users.UseTOTPStep(ctx, u.ID, step); err != nil {
+		log.Printf("[AUTH] totp step update failed: userId=%d err=%v\n", u.ID, err)
+	}
+	log.Printf("[AUTH] two-factor enabled: userId=%d\n", u.ID)
+
+	pair, err := s.issueTokens(ctx, u, "", true)
+	if err != nil {
+		return nil, TokenPair{}, err
+	}
+	return codes, pair, nil
+}
+
+func (s *AuthService) DisableTOTP(ctx context.Context, userID int, password, code string) error {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return ErrMFANotEnabled
+	}
+	if s.MFARequiredFor(u.Role) {
+		return apperr.Forbidden("your role requires two-factor authentication")
+	}
+	if !s.checkPassword(ctx, &u, password) {
+		return ErrInvalidCredentials
+	}
+	ok, err := s.verifySecondFactor(ctx, u, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	if err := s.users.SetTOTP(ctx, u.ID, "", false, nil); err != nil {
+		return err
+	}
+	log.Printf("[AUTH] two-factor disabled: userId=%d\n", u.ID)
+	return nil
+}
+
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TOTPEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	ok, err := s.verifySecondFactor(ctx, u, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.SetTOTP(ctx, u.ID, u.TOTPSecret, true, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ResetTOTP is the admin escape hatch for a lost device. Existing sessions
+// are cut so the account has to sign in again.
+func (s *AuthService) ResetTOTP(ctx context.Context, userID int) error {
+	if err := s.users.SetTOTP(ctx, userID, "", false, nil); err != nil {
+		return err
+	}
+	return s.LogoutAll(ctx, userID)
+}
+
+func (s *AuthService) verifySecondFactor(ctx context.Context, u models.User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	if step, ok := matchTOTP(u.TOTPSecret, code, time.Now()); ok {
+		// Each code is accepted once, so a shoulder-surfed code is useless.
+		return s.users.UseTOTPStep(ctx, u.ID, step)
+	}
+
+	if strings.Contains(code, "-") {
+		return s.users.UseRecoveryCode(ctx, u.ID, hashToken(normalizeRecoveryCode(code)))
+	}
+	return false, nil
+}
+
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	for range recoveryCodeCount {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		for i := range b {
+			b[i] = alphabet[int(b[i])%len(alphabet)]
+		}
+		code := string(b[:5]) + "-" + string(b[5:])
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, " ", ""))
+}
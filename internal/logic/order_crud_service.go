@@ -1,8 +1,9 @@
 package logic
 
 import (
-	"errors"
+	"context"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 	"bookstore/internal/repository"
 )
@@ -15,24 +16,40 @@ func NewOrderCRUDService(repo repository.OrderRepository) *OrderCRUDService {
 	return &OrderCRUDService{repo: repo}
 }
 
-func (s *OrderCRUDService) ListOrders() []models.Order {
-	return s.repo.GetAll()
+func (s *OrderCRUDService) ListOrders(ctx context.Context) []models.Order {
+	return s.repo.GetAll(ctx)
 }
 
-func (s *OrderCRUDService) GetOrder(id int) (models.Order, []models.OrderItem, error) {
-	return s.repo.GetByID(id)
+func (s *OrderCRUDService) GetOrder(ctx context.Context, id int) (models.Order, []models.OrderItem, error) {
+	return s.repo.GetByID(ctx, id)
 }
 
-func (s *OrderCRUDService) UpdateOrder(o models.Order) error {
+func (s *OrderCRUDService) UpdateOrder(ctx context.Context, o models.Order) (models.Order, error) {
 	if o.ID <= 0 {
-		return errors.New("order id must be positive")
+		return models.Order{}, apperr.Invalid("id", "order id must be positive")
 	}
 	if o.Total < 0 {
-		return errors.New("total cannot be negative")
+		return models.Order{}, apperr.Invalid("total", "total cannot be negative")
 	}
-	return s.repo.Update(o)
+	return s.repo.Update(ctx, o)
 }
 
-func (s *OrderCRUDService) DeleteOrder(id int) error {
-	return s.repo.Delete(id)
+// claim bumps the order's version, failing as stale if it moved on since o
+// was read.
+func (s *OrderCRUDService) claim(ctx context.Context, o models.Order) (models.Order, error) {
+	return s.repo.Claim(ctx, o.ID, o.Version)
+}
+
+func (s *OrderCRUDService) DeleteOrder(ctx context.Context, id, actorID int) error {
+	return s.repo.Delete(ctx, id, actorID)
+}
+
+func (s *OrderCRUDService) RestoreOrder(ctx context.Context, id int) (models.Order, []models.OrderItem, error) {
+	if id <= 0 {
+		return models.Order{}, nil, apperr.Invalid("id", "order id must be positive")
+	}
+	if err := s.repo.Restore(ctx, id); err != nil {
+		return models.Order{}, nil, err
+	}
+	return s.repo.GetByID(ctx, id)
 }
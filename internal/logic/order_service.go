@@ -1,36 +1,58 @@
 package logic
 
 import (
-	"errors"
+	"context"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 	"bookstore/internal/repository"
 )
 
 type OrderService struct {
-	repo     repository.OrderRepository
-	bookRepo repository.BookRepository
-	cartRepo repository.CartRepository
+	repo      repository.OrderRepository
+	bookRepo  repository.BookRepository
+	cartRepo  repository.CartRepository
+	addrs     *AddressService
+	inventory *InventoryService
+	payments  *PaymentService
 }
 
-func NewOrderService(repo repository.OrderRepository, bookRepo repository.BookRepository, cartRepo repository.CartRepository) *OrderService {
-	return &OrderService{repo: repo, bookRepo: bookRepo, cartRepo: cartRepo}
+func NewOrderService(
+	repo repository.OrderRepository,
+	bookRepo repository.BookRepository,
+	cartRepo repository.CartRepository,
+	addrs *AddressService,
+	inventory *InventoryService,
+	payments *PaymentService,
+) *OrderService {
+	return &OrderService{
+		repo:      repo,
+		bookRepo:  bookRepo,
+		cartRepo:  cartRepo,
+		addrs:     addrs,
+		inventory: inventory,
+		payments:  payments,
+	}
 }
 
-func (s *OrderService) CreateOrderFromCart(customerID int, cartID int) (models.Order, []models.OrderItem, error) {
+// CreateOrderFromCart ships to addressID, or to the customer's default
+// shipping address when addressID is 0. The cart's stock holds are turned
+// into a decrement; if any book is short, no stock moves and no order is
+// created. Payment for the new order is started straight away.
+func (s *OrderService) CreateOrderFromCart(ctx context.Context, customerID int, cartID int, addressID int) (models.Order, []models.OrderItem, error) {
 	if customerID <= 0 {
-		return models.Order{}, nil, errors.New("customerId must be positive")
+		return models.Order{}, nil, apperr.Invalid("customerId", "customerId must be positive")
 	}
 	if cartID <= 0 {
-		return models.Order{}, nil, errors.New("cartId must be positive")
+		return models.Order{}, nil, apperr.Invalid("cartId", "cartId must be positive")
 	}
 
-	_, cartItems, err := s.cartRepo.GetByID(cartID)
+	_, cartItems, err := s.cartRepo.GetByID(ctx, cartID)
 	if err != nil {
 		return models.Order{}, nil, err
 	}
 	if len(cartItems) == 0 {
-		return models.Order{}, nil, errors.New("cart is empty")
+		return models.Order{}, nil, apperr.Invalid("", "cart is empty")
 	}
 
 	items := make([]models.OrderItem, 0, len(cartItems))
@@ -38,15 +60,15 @@ func (s *OrderService) CreateOrderFromCart(customerID int, cartID int) (models.O
 
 	for _, ci := range cartItems {
 		if ci.BookID <= 0 {
-			return models.Order{}, nil, errors.New("invalid bookId in cart")
+			return models.Order{}, nil, apperr.Invalid("bookId", "invalid bookId in cart")
 		}
 		if ci.Qty <= 0 {
-			return models.Order{}, nil, errors.New("invalid qty in cart")
+			return models.Order{}, nil, apperr.Invalid("qty", "invalid qty in cart")
 		}
 
-		b, err := s.bookRepo.GetByID(ci.BookID)
+		b, err := s.bookRepo.GetByID(ctx, ci.BookID)
 		if err != nil {
-			return models.Order{}, nil, errors.New("book not found")
+			return models.Order{}, nil, missingBook(err)
 		}
 
 		items = append(items, models.OrderItem{
@@ -58,16 +80,29 @@ func (s *OrderService) CreateOrderFromCart(customerID int, cartID int) (models.O
 		total += b.Price * float64(ci.Qty)
 	}
 
+	shipTo, err := s.addrs.Shipping(ctx, customerID, addressID)
+	if err != nil {
+		return models.Order{}, nil, err
+	}
+
 	order := models.Order{
-		CustomerID: customerID,
-		CartID:     cartID,
-		Total:      total,
+		CustomerID:      customerID,
+		CartID:          cartID,
+		Total:           total,
+		ShippingAddress: shipTo,
 	}
 
-	createdOrder, createdItems, err := s.repo.Create(order, items)
+	var createdOrder models.Order
+	var createdItems []models.OrderItem
+	err = s.inventory.takeFor(ctx, cartID, items, func(ctx context.Context) (int, error) {
+		var err error
+		createdOrder, createdItems, err = s.repo.Create(ctx, order, items)
+		return createdOrder.ID, err
+	})
 	if err != nil {
 		return models.Order{}, nil, err
 	}
+	s.payments.startAfterCheckout(ctx, createdOrder)
 
 	select {
 	case OrderJobQueue <- OrderJob{Type: JobAuditOrderCreated, OrderID: createdOrder.ID, CartID: cartID}:
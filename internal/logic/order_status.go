@@ -0,0 +1,58 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+// orderTransitions is the order lifecycle: the statuses an order may move to
+// from each status. Cancelled and refunded orders are finished. An order can
+// be cancelled until it ships, and refunded once it has been paid for.
+var orderTransitions = map[string][]string{
+	models.OrderPending:   {models.OrderPaid, models.OrderCancelled},
+	models.OrderPaid:      {models.OrderPacked, models.OrderCancelled, models.OrderRefunded},
+	models.OrderPacked:    {models.OrderShipped, models.OrderCancelled, models.OrderRefunded},
+	models.OrderShipped:   {models.OrderDelivered, models.OrderRefunded},
+	models.OrderDelivered: {models.OrderRefunded},
+	models.OrderCancelled: nil,
+	models.OrderRefunded:  nil,
+}
+
+// CanCancel reports whether the order has not shipped yet.
+func CanCancel(o models.Order) bool {
+	return slices.Contains(orderTransitions[o.Status], models.OrderCancelled)
+}
+
+// TransitionOrder moves an order to status `to` if the lifecycle allows it
+// from where the order is now. When version is set the move only applies if
+// nobody else has changed the order since it was read.
+func (s *OrderCRUDService) TransitionOrder(ctx context.Context, id, version int, to string, actorID int, note string) (models.Order, error) {
+	if id <= 0 {
+		return models.Order{}, apperr.Invalid("id", "order id must be positive")
+	}
+	if _, ok := orderTransitions[to]; !ok {
+		return models.Order{}, apperr.Invalid("status", "unknown status")
+	}
+
+	o, _, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return models.Order{}, err
+	}
+	from := o.Status
+	if !slices.Contains(orderTransitions[from], to) {
+		return models.Order{}, apperr.Conflict(fmt.Sprintf("order cannot go from %s to %s", from, to))
+	}
+
+	return s.repo.Transition(ctx, id, version, models.StatusChange{
+		From: from,
+		To:   to,
+		At:   time.Now(),
+		By:   actorID,
+		Note: note,
+	})
+}
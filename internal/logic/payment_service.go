@@ -0,0 +1,197 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/payments"
+	"bookstore/internal/repository"
+)
+
+// PaymentService asks the payment provider for an order's total at checkout
+// and applies what the provider reports back: a successful payment moves its
+// order from pending to paid.
+type PaymentService struct {
+	repo     repository.PaymentRepository
+	provider payments.Provider
+	orders   *OrderCRUDService
+	refunds  *RefundService
+}
+
+func NewPaymentService(repo repository.PaymentRepository, provider payments.Provider, orders *OrderCRUDService) *PaymentService {
+	return &PaymentService{repo: repo, provider: provider, orders: orders}
+}
+
+// SetRefunds lets the service give back money that arrives for an order
+// cancelled while its customer was paying. RefundService needs payments in
+// turn, so it is wired in after construction.
+func (s *PaymentService) SetRefunds(refunds *RefundService) {
+	s.refunds = refunds
+}
+
+// StartPayment creates a payment intent for an order awaiting payment. If
+// one is still in progress it is returned instead of asking again.
+func (s *PaymentService) StartPayment(ctx context.Context, o models.Order) (models.Payment, error) {
+	if o.Status != models.OrderPending {
+		return models.Payment{}, apperr.Conflict("order is not awaiting payment")
+	}
+
+	existing := s.repo.ListByOrder(ctx, o.ID)
+	for _, p := range existing {
+		switch p.Status {
+		case models.PaymentPending:
+			return p, nil
+		case models.PaymentSucceeded:
+			return models.Payment{}, apperr.Conflict("order is already paid")
+		}
+	}
+
+	// The key makes a retried request reuse the intent of the attempt it
+	// repeats rather than open a second one.
+	key := fmt.Sprintf("order-%d-%d", o.ID, len(existing)+1)
+	intent, err := s.provider.CreateIntent(ctx, key, o.ID, o.Total)
+	if err != nil {
+		return models.Payment{}, err
+	}
+	return s.repo.Create(ctx, models.Payment{
+		OrderID:  o.ID,
+		Total:    intent.Amount,
+		Status:   models.PaymentPending,
+		Provider: s.provider.Name(),
+		IntentID: intent.ID,
+	})
+}
+
+// startAfterCheckout starts paying for a freshly placed order. The order
+// stands either way; a failed start can be retried from the order.
+func (s *PaymentService) startAfterCheckout(ctx context.Context, o models.Order) {
+	if _, err := s.StartPayment(ctx, o); err != nil {
+		log.Printf("[PAYMENT] failed to start payment: orderId=%d err=%v\n", o.ID, err)
+	}
+}
+
+func (s *PaymentService) Payments(ctx context.Context, orderID int) []models.Payment {
+	return s.repo.ListByOrder(ctx, orderID)
+}
+
+// settled returns the order's successful payment, if it has one.
+func (s *PaymentService) settled(ctx context.Context, orderID int) (models.Payment, bool) {
+	for _, p := range s.repo.ListByOrder(ctx, orderID) {
+		if p.Status == models.PaymentSucceeded {
+			return p, true
+		}
+	}
+	return models.Payment{}, false
+}
+
+func (s *PaymentService) refund(ctx context.Context, p models.Payment, key string, amount float64) (string, error) {
+	return s.provider.Refund(ctx, key, p.IntentID, amount)
+}
+
+func (s *PaymentService) PaymentByIntent(ctx context.Context, intentID string) (models.Payment, error) {
+	return s.repo.GetByIntent(ctx, s.provider.Name(), intentID)
+}
+
+// HandleWebhook applies a provider notification. Providers redeliver until
+// they get a 2xx, so every step tolerates having run before, and an event is
+// only marked as handled once all of them succeeded.
+func (s *PaymentService) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	ev, err := s.provider.ParseEvent(payload, signature)
+	if errors.Is(err, payments.ErrBadSignature) {
+		return apperr.Unauthorized("invalid webhook signature")
+	}
+	if err != nil {
+		return apperr.Invalid("", "malformed webhook").Wrap(err)
+	}
+
+	name := s.provider.Name()
+	seen, err := s.repo.EventSeen(ctx, name, ev.ID)
+	if err != nil {
+		return err
+	}
+	if seen {
+		log.Printf("[PAYMENT] duplicate webhook ignored: event=%s\n", ev.ID)
+		return nil
+	}
+
+	p, err := s.repo.GetByIntent(ctx, name, ev.IntentID)
+	if err != nil {
+		return err
+	}
+
+	switch ev.Status {
+	case models.PaymentSucceeded:
+		if err := s.setStatus(ctx, p, models.PaymentSucceeded, ""); err != nil {
+			return err
+		}
+		if err := s.markOrderPaid(ctx, p); err != nil {
+			return err
+		}
+	case models.PaymentFailed:
+		if err := s.setStatus(ctx, p, models.PaymentFailed, ev.Reason); err != nil {
+			return err
+		}
+	default:
+		log.Printf("[PAYMENT] webhook with unknown status ignored: event=%s status=%q\n", ev.ID, ev.Status)
+	}
+
+	log.Printf("[PAYMENT] webhook handled: event=%s paymentId=%d status=%s\n", ev.ID, p.ID, ev.Status)
+	return s.repo.MarkEvent(ctx, name, ev.ID)
+}
+
+// setStatus settles a pending payment. A payment settled by an earlier
+// delivery of the same news is left as it is.
+func (s *PaymentService) setStatus(ctx context.Context, p models.Payment, to, reason string) error {
+	_, err := s.repo.SetStatus(ctx, p.ID, models.PaymentPending, to, reason)
+	if errors.Is(err, apperr.ErrStale) {
+		return nil
+	}
+	return err
+}
+
+func (s *PaymentService) markOrderPaid(ctx context.Context, p models.Payment) error {
+	o, _, err := s.orders.GetOrder(ctx, p.OrderID)
+	if errors.Is(err, apperr.ErrNotFound) {
+		log.Printf("[PAYMENT] payment for a missing order: orderId=%d intent=%s\n", p.OrderID, p.IntentID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch o.Status {
+	case models.OrderPending:
+		_, err = s.orders.TransitionOrder(ctx, o.ID, o.Version, models.OrderPaid, 0, "payment "+p.IntentID)
+		return err
+	case models.OrderCancelled:
+		return s.refundCancelled(ctx, o, p)
+	case models.OrderPaid:
+		// An earlier delivery of the same news got here first.
+		return nil
+	default:
+		log.Printf("[PAYMENT] payment for an order not awaiting one: orderId=%d status=%s intent=%s\n", o.ID, o.Status, p.IntentID)
+		return nil
+	}
+}
+
+// refundCancelled gives back a payment for an order that was cancelled while
+// the customer was paying. An error makes the webhook fail so the provider
+// delivers it again and the refund is retried.
+func (s *PaymentService) refundCancelled(ctx context.Context, o models.Order, p models.Payment) error {
+	if s.refunds == nil {
+		log.Printf("[PAYMENT] payment for a cancelled order needs refunding: orderId=%d intent=%s\n", o.ID, p.IntentID)
+		return nil
+	}
+
+	_, err := s.refunds.Refund(ctx, o.ID, nil, 0, "payment arrived after cancellation")
+	if errors.Is(err, apperr.ErrConflict) && !errors.Is(err, apperr.ErrStale) {
+		// Refunded by an earlier delivery, or by hand.
+		log.Printf("[PAYMENT] cancelled order already refunded: orderId=%d intent=%s\n", o.ID, p.IntentID)
+		return nil
+	}
+	return err
+}
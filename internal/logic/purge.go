@@ -0,0 +1,92 @@
+package logic
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bookstore/internal/repository"
+)
+
+// DefaultPurgeRetention is how long soft-deleted records can be restored
+// before the purge job removes them for good.
+const DefaultPurgeRetention = 30 * 24 * time.Hour
+
+type PurgeService struct {
+	books     repository.BookRepository
+	orders    repository.OrderRepository
+	wishlists repository.WishlistRepository
+	retention time.Duration
+}
+
+func NewPurgeService(
+	books repository.BookRepository,
+	orders repository.OrderRepository,
+	wishlists repository.WishlistRepository,
+	retention time.Duration,
+) *PurgeService {
+	if retention <= 0 {
+		retention = DefaultPurgeRetention
+	}
+	return &PurgeService{
+		books:     books,
+		orders:    orders,
+		wishlists: wishlists,
+		retention: retention,
+	}
+}
+
+// Purge hard-deletes everything soft-deleted more than the retention window
+// ago. Orders go first so that books only they referred to can go in the
+// same run; a deleted book that a remaining order still refers to is kept,
+// otherwise that order could no longer show what was bought.
+func (s *PurgeService) Purge(ctx context.Context) error {
+	before := time.Now().Add(-s.retention)
+
+	n, err := s.orders.Purge(ctx, before)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PURGE] orders purged: count=%d\n", n)
+
+	n, err = s.wishlists.Purge(ctx, before)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PURGE] wishlists purged: count=%d\n", n)
+
+	keep, err := s.orders.BookIDs(ctx)
+	if err != nil {
+		return err
+	}
+	n, err = s.books.Purge(ctx, before, keep)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PURGE] books purged: count=%d\n", n)
+	return nil
+}
+
+// StartPurgeJob runs Purge every interval, and once straight away, until ctx
+// is cancelled.
+func StartPurgeJob(ctx context.Context, interval time.Duration, s *PurgeService) {
+	log.Printf("[PURGE] purging soft-deleted records older than %s every %s\n", s.retention, interval)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			if err := s.Purge(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("[PURGE] failed: %v\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				log.Println("[PURGE] stopped")
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
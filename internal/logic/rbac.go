@@ -0,0 +1,69 @@
+package logic
+
+import (
+	"slices"
+
+	"bookstore/internal/models"
+)
+
+var customerPermissions = []models.Permission{
+	models.PermCartsOwn,
+	models.PermOrdersPlace,
+	models.PermWishlistsOwn,
+}
+
+var rolePermissions = map[string][]models.Permission{
+	models.RoleCustomer: customerPermissions,
+	models.RoleSupport: append(slices.Clone(customerPermissions),
+		models.PermCartsRead,
+		models.PermOrdersRead,
+		models.PermUsersRead,
+	),
+	models.RoleOrderManager: append(slices.Clone(customerPermissions),
+		models.PermOrdersRead,
+		models.PermOrdersWrite,
+		models.PermOrdersRefund,
+	),
+	models.RoleCatalogEditor: append(slices.Clone(customerPermissions),
+		models.PermBooksWrite,
+	),
+	models.RoleAdmin: append(slices.Clone(customerPermissions),
+		models.PermBooksWrite,
+		models.PermCartsRead,
+		models.PermCartsWrite,
+		models.PermOrdersRead,
+		models.PermOrdersWrite,
+		models.PermOrdersDelete,
+		models.PermOrdersRefund,
+		models.PermWishlistsWrite,
+		models.PermUsersRead,
+		models.PermUsersWrite,
+	),
+}
+
+func Roles() []string {
+	return []string{
+		models.RoleCustomer,
+		models.RoleSupport,
+		models.RoleOrderManager,
+		models.RoleCatalogEditor,
+		models.RoleAdmin,
+	}
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func PermissionsFor(role string) []models.Permission {
+	// "user" is what accounts were created with before roles existed.
+	if role == "" || role == "user" {
+		role = models.RoleCustomer
+	}
+	return slices.Clone(rolePermissions[role])
+}
+
+func HasPermission(role string, p models.Permission) bool {
+	return slices.Contains(PermissionsFor(role), p)
+}
@@ -0,0 +1,223 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+// RefundService cancels orders and gives money back. Refunded copies go
+// back into stock, and every refund is paid out of the order's settled
+// payment.
+type RefundService struct {
+	repo      repository.RefundRepository
+	orders    *OrderCRUDService
+	payments  *PaymentService
+	inventory *InventoryService
+}
+
+func NewRefundService(
+	repo repository.RefundRepository,
+	orders *OrderCRUDService,
+	payments *PaymentService,
+	inventory *InventoryService,
+) *RefundService {
+	return &RefundService{
+		repo:      repo,
+		orders:    orders,
+		payments:  payments,
+		inventory: inventory,
+	}
+}
+
+// RefundLine asks for Qty copies of one order line back.
+type RefundLine struct {
+	OrderItemID int `json:"orderItemId"`
+	Qty         int `json:"qty"`
+}
+
+func (s *RefundService) Refunds(ctx context.Context, orderID int) []models.Refund {
+	return s.repo.ListByOrder(ctx, orderID)
+}
+
+// CancelOrder cancels an order that has not shipped. Its copies go back into
+// stock and, if it was paid for, whatever was not refunded yet is refunded.
+func (s *RefundService) CancelOrder(ctx context.Context, orderID, actorID int, reason string) (models.Order, error) {
+	o, items, err := s.orders.GetOrder(ctx, orderID)
+	if err != nil {
+		return models.Order{}, err
+	}
+	if !CanCancel(o) {
+		return models.Order{}, apperr.Conflict(fmt.Sprintf("a %s order can no longer be cancelled", o.Status))
+	}
+
+	// Claiming the status first means two cancellations racing each other
+	// cannot both hand out money.
+	cancelled, err := s.orders.TransitionOrder(ctx, o.ID, o.Version, models.OrderCancelled, actorID, reason)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	if _, paid := s.payments.settled(ctx, o.ID); !paid {
+		s.inventory.restock(ctx, o.ID, actorID, models.MovementCancel, items)
+		return cancelled, nil
+	}
+	if _, err := s.refund(ctx, cancelled, items, nil, actorID, reason, models.MovementCancel); err != nil {
+		// The order stays cancelled; the refund can be issued again by hand.
+		log.Printf("[REFUND] cancelled order not refunded: orderId=%d err=%v\n", o.ID, err)
+	}
+	return cancelled, nil
+}
+
+// Refund gives money back for the given lines of a paid order, or for
+// everything not refunded yet when lines is empty. Once nothing is left to
+// refund the order moves to refunded.
+func (s *RefundService) Refund(ctx context.Context, orderID int, lines []RefundLine, actorID int, reason string) (models.Refund, error) {
+	o, items, err := s.orders.GetOrder(ctx, orderID)
+	if err != nil {
+		return models.Refund{}, err
+	}
+
+	// A cancelled order's copies went back into stock when it was
+	// cancelled; refunding it later, e.g. for a payment that arrived after
+	// the cancellation, must not restock them twice.
+	restock := models.MovementRefund
+	switch o.Status {
+	case models.OrderPaid, models.OrderPacked, models.OrderShipped, models.OrderDelivered:
+	case models.OrderCancelled:
+		restock = ""
+	default:
+		return models.Refund{}, apperr.Conflict(fmt.Sprintf("a %s order cannot be refunded", o.Status))
+	}
+
+	rf, err := s.refund(ctx, o, items, lines, actorID, reason, restock)
+	if err != nil {
+		return models.Refund{}, err
+	}
+
+	if o.Status != models.OrderCancelled && len(s.left(ctx, o.ID, items)) == 0 {
+		if _, err := s.orders.TransitionOrder(ctx, o.ID, 0, models.OrderRefunded, actorID, reason); err != nil {
+			log.Printf("[REFUND] fully refunded order left in %s: orderId=%d err=%v\n", o.Status, o.ID, err)
+		}
+	}
+	return rf, nil
+}
+
+// left maps each order line that has copies not refunded yet to how many.
+func (s *RefundService) left(ctx context.Context, orderID int, items []models.OrderItem) map[int]int {
+	out := make(map[int]int, len(items))
+	for _, it := range items {
+		out[it.ID] = it.Qty
+	}
+	for _, rf := range s.repo.ListByOrder(ctx, orderID) {
+		for _, ri := range rf.Items {
+			out[ri.OrderItemID] -= ri.Qty
+		}
+	}
+	for id, n := range out {
+		if n <= 0 {
+			delete(out, id)
+		}
+	}
+	return out
+}
+
+// refund pays back lines of o and records it. restock names the stock
+// movement for the copies, or is empty to leave stock alone.
+func (s *RefundService) refund(ctx context.Context, o models.Order, items []models.OrderItem, lines []RefundLine, actorID int, reason, restock string) (models.Refund, error) {
+	p, ok := s.payments.settled(ctx, o.ID)
+	if !ok {
+		return models.Refund{}, apperr.Conflict("order has no settled payment to refund")
+	}
+
+	left := s.left(ctx, o.ID, items)
+	if len(lines) == 0 {
+		for _, it := range items {
+			if left[it.ID] > 0 {
+				lines = append(lines, RefundLine{OrderItemID: it.ID, Qty: left[it.ID]})
+			}
+		}
+	}
+
+	byID := make(map[int]models.OrderItem, len(items))
+	for _, it := range items {
+		byID[it.ID] = it
+	}
+
+	var amount float64
+	refundItems := make([]models.RefundItem, 0, len(lines))
+	stockItems := make([]models.OrderItem, 0, len(lines))
+	for _, l := range lines {
+		it, ok := byID[l.OrderItemID]
+		if !ok {
+			return models.Refund{}, apperr.Invalid("items", fmt.Sprintf("item %d is not part of order %d", l.OrderItemID, o.ID))
+		}
+		if l.Qty <= 0 {
+			return models.Refund{}, apperr.Invalid("items", "qty must be positive")
+		}
+		if l.Qty > left[it.ID] {
+			return models.Refund{}, apperr.Invalid("items", fmt.Sprintf("only %d of item %d left to refund", left[it.ID], it.ID))
+		}
+		left[it.ID] -= l.Qty
+
+		line := cents(it.Price * float64(l.Qty))
+		amount += line
+		refundItems = append(refundItems, models.RefundItem{OrderItemID: it.ID, BookID: it.BookID, Qty: l.Qty, Amount: line})
+		stockItems = append(stockItems, models.OrderItem{BookID: it.BookID, Qty: l.Qty})
+	}
+	if len(refundItems) == 0 {
+		return models.Refund{}, apperr.Conflict("nothing left to refund")
+	}
+
+	// Never pay back more than was taken, whatever the line prices say.
+	past := s.repo.ListByOrder(ctx, o.ID)
+	remaining := p.Total
+	for _, rf := range past {
+		remaining -= rf.Amount
+	}
+	amount = cents(math.Max(0, math.Min(amount, remaining)))
+
+	// Claim the order before paying out: bumping its version makes a
+	// concurrent refund worked out from the same order fail as stale. One
+	// that read the order after this claim but before the refund below is
+	// recorded computes the same key, and the repository turns it away.
+	if _, err := s.orders.claim(ctx, o); err != nil {
+		return models.Refund{}, err
+	}
+
+	// The key only moves on once a refund is recorded, so retrying after a
+	// failure below reaches the provider with the same key again.
+	key := fmt.Sprintf("order-%d-refund-%d", o.ID, len(past)+1)
+	providerID, err := s.payments.refund(ctx, p, key, amount)
+	if err != nil {
+		return models.Refund{}, err
+	}
+
+	rf, err := s.repo.Create(ctx, models.Refund{
+		OrderID:          o.ID,
+		PaymentID:        p.ID,
+		Amount:           amount,
+		Items:            refundItems,
+		Reason:           reason,
+		ProviderRefundID: providerID,
+		CreatedBy:        actorID,
+	})
+	if err != nil {
+		return models.Refund{}, err
+	}
+	log.Printf("[REFUND] refunded: orderId=%d refundId=%d amount=%.2f\n", o.ID, rf.ID, rf.Amount)
+
+	if restock != "" {
+		s.inventory.restock(ctx, o.ID, actorID, restock, stockItems)
+	}
+	return rf, nil
+}
+
+func cents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,141 @@
+package logic
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"bookstore/internal/repository"
+)
+
+// rfcSecret is the SHA-1 key from RFC 6238 appendix B, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 lists 8 digits; a 6 digit code is the last six of them.
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tc := range cases {
+		got, err := totpCode(rfcSecret, totpStep(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatalf("totpCode at %d: %v", tc.unix, err)
+		}
+		if got != tc.want {
+			t.Errorf("totpCode at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	cur := totpStep(now)
+	code := func(step int64) string {
+		c, err := totpCode(rfcSecret, step)
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		return c
+	}
+
+	cases := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, code(cur), cur, true},
+		{"one step behind", rfcSecret, code(cur - 1), cur - 1, true},
+		{"one step ahead", rfcSecret, code(cur + 1), cur + 1, true},
+		{"two steps behind", rfcSecret, code(cur - 2), 0, false},
+		{"two steps ahead", rfcSecret, code(cur + 2), 0, false},
+		{"spaces and padding", rfcSecret, " 005 924 ", cur, true},
+		{"lower-case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code(cur), cur, true},
+		{"too short", rfcSecret, "00592", 0, false},
+		{"too long", rfcSecret, "0059240", 0, false},
+		{"empty", rfcSecret, "", 0, false},
+		{"other secret", "JBSWY3DPEHPK3PXP", code(cur), 0, false},
+		{"undecodable secret", "not base32!", code(cur), 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			step, ok := matchTOTP(tc.secret, tc.code, now)
+			if ok != tc.wantOK || step != tc.wantStep {
+				t.Fatalf("matchTOTP = %d, %v; want %d, %v", step, ok, tc.wantStep, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestCompleteMFA(t *testing.T) {
+	ctx := t.Context()
+	stores := repository.NewMemoryStores()
+	s := NewAuthService(stores.Users, stores.RefreshTokens, stores.Revocations, "secret")
+
+	if err := s.Register(ctx, "a@example.com", "secret"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	u, _ := stores.Users.GetByEmail(ctx, "a@example.com")
+	setup, err := s.BeginTOTPSetup(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("BeginTOTPSetup: %v", err)
+	}
+
+	cur := totpStep(time.Now())
+	code := func(step int64) string {
+		c, err := totpCode(setup.Secret, step)
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		return c
+	}
+	recovery, _, err := s.EnableTOTP(ctx, u.ID, code(cur))
+	if err != nil {
+		t.Fatalf("EnableTOTP: %v", err)
+	}
+
+	// The steps share one account, so order matters: a used code or step
+	// stays used.
+	steps := []struct {
+		name    string
+		code    string
+		wantErr error
+	}{
+		{"wrong code", code(cur + 5), ErrInvalidMFACode},
+		{"code that enabled two-factor", code(cur), ErrInvalidMFACode},
+		{"next code", code(cur + 1), nil},
+		{"same code again", code(cur + 1), ErrInvalidMFACode},
+		{"older code after a newer one", code(cur - 1), ErrInvalidMFACode},
+		{"recovery code", recovery[0], nil},
+		{"recovery code again", recovery[0], ErrInvalidMFACode},
+		{"recovery code in upper case", "  " + strings.ToUpper(recovery[1]) + " ", nil},
+	}
+	for _, st := range steps {
+		_, err := s.Login(ctx, "a@example.com", "secret", "127.0.0.1")
+		challenge := MFAChallenge(err)
+		if challenge == "" {
+			t.Fatalf("%s: Login err = %v, want a two-factor challenge", st.name, err)
+		}
+
+		pair, err := s.CompleteMFA(ctx, challenge, st.code, "127.0.0.1")
+		if !errors.Is(err, st.wantErr) {
+			t.Fatalf("%s: CompleteMFA err = %v, want %v", st.name, err, st.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		claims, err := s.ParseAccessToken(ctx, pair.AccessToken)
+		if err != nil || !claims.MFA {
+			t.Fatalf("%s: session claims = %+v, %v; want two-factor authenticated", st.name, claims, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package logic
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+type UserService struct {
+	users       repository.UserRepository
+	revocations repository.RevocationRepository
+}
+
+func NewUserService(users repository.UserRepository, revocations repository.RevocationRepository) *UserService {
+	return &UserService{users: users, revocations: revocations}
+}
+
+func (s *UserService) ListUsers(ctx context.Context) []models.User {
+	return s.users.GetAll(ctx)
+}
+
+func (s *UserService) GetUser(ctx context.Context, id int) (models.User, error) {
+	if id <= 0 {
+		return models.User{}, apperr.Invalid("id", "invalid id")
+	}
+	return s.users.GetByID(ctx, id)
+}
+
+func (s *UserService) AssignRole(ctx context.Context, actorID int, userID int, role string) (models.User, error) {
+	if !ValidRole(role) {
+		return models.User{}, apperr.Invalid("role", "unknown role")
+	}
+	if actorID == userID {
+		return models.User{}, apperr.Forbidden("cannot change your own role")
+	}
+
+	u, err := s.users.GetByID(ctx, userID)
+	if err != nil {
+		return models.User{}, err
+	}
+	if u.Role == role {
+		return u, nil
+	}
+
+	u.Role = role
+	if err := s.users.Update(ctx, u); err != nil {
+		return models.User{}, err
+	}
+
+	// Access tokens carry the role, so cut the outstanding ones; the client's
+	// next refresh picks the new role up from the user record.
+	if err := s.revocations.RevokeAllForUser(ctx, u.ID, time.Now()); err != nil {
+		log.Printf("[USERS] revoke sessions after role change failed: userId=%d err=%v\n", u.ID, err)
+	}
+
+	log.Printf("[USERS] role changed: userId=%d role=%s by=%d\n", u.ID, role, actorID)
+	return u, nil
+}
+
+func (s *UserService) Unlock(ctx context.Context, actorID int, userID int) (models.User, error) {
+	if err := s.users.ResetLoginFailures(ctx, userID); err != nil {
+		return models.User{}, err
+	}
+	log.Printf("[USERS] account unlocked: userId=%d by=%d\n", userID, actorID)
+	return s.users.GetByID(ctx, userID)
+}
+
+func (s *UserService) LoginAttempts(ctx context.Context, email string, limit int) []models.LoginAttempt {
+	return s.users.ListLoginAttempts(ctx, normalizeEmail(email), limit)
+}
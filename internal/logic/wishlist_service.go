@@ -1,9 +1,11 @@
 package logic
 
 import (
+	"context"
 	"errors"
 	"log"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 	"bookstore/internal/repository"
 )
@@ -12,65 +14,81 @@ type WishlistService struct {
 	wRepo     repository.WishlistRepository
 	bookRepo  repository.BookRepository
 	orderRepo repository.OrderRepository
+	inventory *InventoryService
+	payments  *PaymentService
 }
 
 func NewWishlistService(
 	wRepo repository.WishlistRepository,
 	bookRepo repository.BookRepository,
 	orderRepo repository.OrderRepository,
+	inventory *InventoryService,
+	payments *PaymentService,
 ) *WishlistService {
 	return &WishlistService{
 		wRepo:     wRepo,
 		bookRepo:  bookRepo,
 		orderRepo: orderRepo,
+		inventory: inventory,
+		payments:  payments,
 	}
 }
 
-func (s *WishlistService) CreateWishlist(customerID int) models.Wishlist {
+func (s *WishlistService) CreateWishlist(ctx context.Context, customerID int) models.Wishlist {
 	if customerID <= 0 {
 		customerID = 1
 	}
-	return s.wRepo.Create(customerID)
+	return s.wRepo.Create(ctx, customerID)
 }
 
-func (s *WishlistService) ListWishlists() []models.Wishlist {
-	return s.wRepo.GetAll()
+func (s *WishlistService) ListWishlists(ctx context.Context) []models.Wishlist {
+	return s.wRepo.GetAll(ctx)
 }
 
-func (s *WishlistService) GetWishlist(id int) (models.Wishlist, []models.WishlistItem, error) {
-	return s.wRepo.GetByID(id)
+func (s *WishlistService) GetWishlist(ctx context.Context, id int) (models.Wishlist, []models.WishlistItem, error) {
+	return s.wRepo.GetByID(ctx, id)
 }
 
-func (s *WishlistService) AddItem(wishlistID, bookID, qty int) (models.WishlistItem, error) {
+func (s *WishlistService) RestoreWishlist(ctx context.Context, id int) (models.Wishlist, []models.WishlistItem, error) {
+	if id <= 0 {
+		return models.Wishlist{}, nil, apperr.Invalid("id", "wishlist id must be positive")
+	}
+	if err := s.wRepo.Restore(ctx, id); err != nil {
+		return models.Wishlist{}, nil, err
+	}
+	return s.wRepo.GetByID(ctx, id)
+}
+
+func (s *WishlistService) AddItem(ctx context.Context, wishlistID, bookID, qty int) (models.WishlistItem, error) {
 	if wishlistID <= 0 {
-		return models.WishlistItem{}, errors.New("wishlistId must be positive")
+		return models.WishlistItem{}, apperr.Invalid("wishlistId", "wishlistId must be positive")
 	}
 	if bookID <= 0 {
-		return models.WishlistItem{}, errors.New("bookId must be positive")
+		return models.WishlistItem{}, apperr.Invalid("bookId", "bookId must be positive")
 	}
 	if qty <= 0 {
-		return models.WishlistItem{}, errors.New("qty must be > 0")
+		return models.WishlistItem{}, apperr.Invalid("qty", "qty must be > 0")
 	}
-	if _, err := s.bookRepo.GetByID(bookID); err != nil {
-		return models.WishlistItem{}, errors.New("book not found")
+	if _, err := s.bookRepo.GetByID(ctx, bookID); err != nil {
+		return models.WishlistItem{}, missingBook(err)
 	}
-	return s.wRepo.AddItem(wishlistID, bookID, qty)
+	return s.wRepo.AddItem(ctx, wishlistID, bookID, qty)
 }
 
-func (s *WishlistService) GiftFromWishlist(wishlistID int, buyerID int) (models.Order, []models.OrderItem, int, error) {
+func (s *WishlistService) GiftFromWishlist(ctx context.Context, wishlistID int, buyerID int) (models.Order, []models.OrderItem, int, error) {
 	if wishlistID <= 0 {
-		return models.Order{}, nil, 0, errors.New("wishlistId must be positive")
+		return models.Order{}, nil, 0, apperr.Invalid("wishlistId", "wishlistId must be positive")
 	}
 	if buyerID <= 0 {
-		return models.Order{}, nil, 0, errors.New("buyerCustomerId must be positive")
+		return models.Order{}, nil, 0, apperr.Invalid("buyerCustomerId", "buyerCustomerId must be positive")
 	}
 
-	w, items, err := s.wRepo.GetByID(wishlistID)
+	w, items, err := s.wRepo.GetByID(ctx, wishlistID)
 	if err != nil {
 		return models.Order{}, nil, 0, err
 	}
 	if len(items) == 0 {
-		return models.Order{}, nil, 0, errors.New("wishlist is empty")
+		return models.Order{}, nil, 0, apperr.Invalid("", "wishlist is empty")
 	}
 
 	orderItems := make([]models.OrderItem, 0, len(items))
@@ -78,15 +96,15 @@ func (s *WishlistService) GiftFromWishlist(wishlistID int, buyerID int) (models.
 
 	for _, wi := range items {
 		if wi.BookID <= 0 {
-			return models.Order{}, nil, 0, errors.New("invalid bookId in wishlist")
+			return models.Order{}, nil, 0, apperr.Invalid("bookId", "invalid bookId in wishlist")
 		}
 		if wi.Qty <= 0 {
-			return models.Order{}, nil, 0, errors.New("invalid qty in wishlist")
+			return models.Order{}, nil, 0, apperr.Invalid("qty", "invalid qty in wishlist")
 		}
 
-		book, err := s.bookRepo.GetByID(wi.BookID)
+		book, err := s.bookRepo.GetByID(ctx, wi.BookID)
 		if err != nil {
-			return models.Order{}, nil, 0, errors.New("book not found")
+			return models.Order{}, nil, 0, missingBook(err)
 		}
 		if book.Price < 0 {
 			return models.Order{}, nil, 0, errors.New("book price cannot be negative")
@@ -107,10 +125,18 @@ func (s *WishlistService) GiftFromWishlist(wishlistID int, buyerID int) (models.
 		Total:      total,
 	}
 
-	createdOrder, createdItems, err := s.orderRepo.Create(order, orderItems)
+	// Wishlists hold no stock, so the gift only gets what is on the shelf.
+	var createdOrder models.Order
+	var createdItems []models.OrderItem
+	err = s.inventory.takeFor(ctx, 0, orderItems, func(ctx context.Context) (int, error) {
+		var err error
+		createdOrder, createdItems, err = s.orderRepo.Create(ctx, order, orderItems)
+		return createdOrder.ID, err
+	})
 	if err != nil {
 		return models.Order{}, nil, 0, err
 	}
+	s.payments.startAfterCheckout(ctx, createdOrder)
 
 	log.Printf("[GIFT] enqueue clear wishlist job: wishlistId=%d orderId=%d\n", wishlistID, createdOrder.ID)
 
@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"os"
+	"strings"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@bookstore.local"
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return NewSMTPMailer(host, port, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD"), from)
+	}
+
+	if dir := strings.TrimSpace(os.Getenv("MAIL_DIR")); dir != "" {
+		return NewFileMailer(dir, from)
+	}
+	return NewLogMailer(from)
+}
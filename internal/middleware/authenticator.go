@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/models"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+const (
+	MethodBearer = "bearer"
+	MethodCookie = "cookie"
+	MethodAPIKey = "api_key"
+
+	APIKeyHeader = "X-API-Key"
+)
+
+type Principal struct {
+	UserID      int
+	Role        string
+	Permissions []models.Permission
+	Scopes      []string
+	Method      string
+	TokenID     string
+	ExpiresAt   time.Time
+
+	MFA        bool
+	MFAPending bool
+}
+
+func (p Principal) Can(perm models.Permission) bool {
+	return slices.Contains(p.Permissions, perm)
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+type AuthenticatorFunc func(r *http.Request) (Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (Principal, error) {
+	return f(r)
+}
+
+type TokenValidator interface {
+	ParseAccessToken(ctx context.Context, token string) (logic.AccessClaims, error)
+}
+
+func Bearer(tokens TokenValidator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return Principal{}, ErrNoCredentials
+		}
+		return principalFromToken(r.Context(), tokens, strings.TrimPrefix(auth, "Bearer "), MethodBearer)
+	})
+}
+
+func Cookie(tokens TokenValidator, name string) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		c, err := r.Cookie(name)
+		if err != nil || c.Value == "" {
+			return Principal{}, ErrNoCredentials
+		}
+		return principalFromToken(r.Context(), tokens, c.Value, MethodCookie)
+	})
+}
+
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string) (logic.APIKeyClaims, error)
+}
+
+// APIKey authenticates X-API-Key. The principal only carries the key's
+// scopes, never the full set of the owner's role.
+func APIKey(keys APIKeyValidator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			return Principal{}, ErrNoCredentials
+		}
+		claims, err := keys.ValidateAPIKey(r.Context(), key)
+		if err != nil {
+			return Principal{}, ErrInvalidCredentials
+		}
+
+		scopes := make([]string, 0, len(claims.Scopes))
+		for _, sc := range claims.Scopes {
+			scopes = append(scopes, string(sc))
+		}
+		return Principal{
+			UserID:      claims.UserID,
+			Role:        claims.Role,
+			Permissions: claims.Scopes,
+			Scopes:      scopes,
+			Method:      MethodAPIKey,
+			TokenID:     "apikey:" + strconv.Itoa(claims.KeyID),
+			ExpiresAt:   claims.ExpiresAt,
+		}, nil
+	})
+}
+
+func Chain(auths ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(r *http.Request) (Principal, error) {
+		for _, a := range auths {
+			p, err := a.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			return p, err
+		}
+		return Principal{}, ErrNoCredentials
+	})
+}
+
+func principalFromToken(ctx context.Context, tokens TokenValidator, token string, method string) (Principal, error) {
+	claims, err := tokens.ParseAccessToken(ctx, token)
+	if err != nil {
+		return Principal{}, ErrInvalidCredentials
+	}
+	perms := logic.PermissionsFor(claims.Role)
+	if claims.MFAPending {
+		// Until the second factor is set up the session only gets what a
+		// customer could do; the role itself is kept so the UI can nag.
+		perms = logic.PermissionsFor(models.RoleCustomer)
+	}
+	return Principal{
+		UserID:      claims.UserID,
+		Role:        claims.Role,
+		Permissions: perms,
+		Method:      method,
+		TokenID:     claims.JTI,
+		ExpiresAt:   claims.ExpiresAt,
+		MFA:         claims.MFA,
+		MFAPending:  claims.MFAPending,
+	}, nil
+}
+
+func WithPrincipal(r *http.Request, p Principal) *http.Request {
+	ctx := context.WithValue(r.Context(), CtxPrincipal, p)
+	ctx = context.WithValue(ctx, CtxUserID, p.UserID)
+	ctx = context.WithValue(ctx, CtxRole, p.Role)
+	return r.WithContext(ctx)
+}
+
+func FromRequest(r *http.Request) (Principal, bool) {
+	p, ok := r.Context().Value(CtxPrincipal).(Principal)
+	return p, ok
+}
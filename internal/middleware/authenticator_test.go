@@ -0,0 +1,197 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testSecret = "test-secret"
+	testCookie = "token"
+)
+
+// authFixture is a signed-in customer on in-memory stores, with the same
+// authenticator chain the API routes use.
+type authFixture struct {
+	auth   *logic.AuthService
+	keys   *logic.APIKeyService
+	chain  middleware.Authenticator
+	userID int
+}
+
+func newAuthFixture(t *testing.T) authFixture {
+	t.Helper()
+	stores := repository.NewMemoryStores()
+	auth := logic.NewAuthService(stores.Users, stores.RefreshTokens, stores.Revocations, testSecret)
+	keys := logic.NewAPIKeyService(stores.APIKeys, stores.Users)
+
+	if err := auth.Register(t.Context(), "a@example.com", "secret"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	u, err := stores.Users.GetByEmail(t.Context(), "a@example.com")
+	if err != nil {
+		t.Fatalf("GetByEmail: %v", err)
+	}
+
+	return authFixture{
+		auth: auth,
+		keys: keys,
+		chain: middleware.Chain(
+			middleware.Bearer(auth),
+			middleware.Cookie(auth, testCookie),
+			middleware.APIKey(keys),
+		),
+		userID: u.ID,
+	}
+}
+
+func (f authFixture) login(t *testing.T) string {
+	t.Helper()
+	pair, err := f.auth.Login(t.Context(), "a@example.com", "secret", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return pair.AccessToken
+}
+
+func (f authFixture) apiKey(t *testing.T, scopes ...models.Permission) string {
+	t.Helper()
+	k, err := f.keys.Create(t.Context(), f.userID, "test", scopes, 0)
+	if err != nil {
+		t.Fatalf("create api key: %v", err)
+	}
+	return k.Key
+}
+
+// signAccess signs an access token the way the auth service does, with
+// issue and expiry times of the caller's choosing.
+func signAccess(t *testing.T, userID int, iat, exp time.Time) string {
+	t.Helper()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"jti":    "jti-" + iat.String(),
+		"userId": userID,
+		"role":   models.RoleCustomer,
+		"typ":    "access",
+		"iat":    iat.Unix(),
+		"exp":    exp.Unix(),
+	}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return tok
+}
+
+func TestAuthenticatorChain(t *testing.T) {
+	f := newAuthFixture(t)
+
+	valid := f.login(t)
+
+	revoked := f.login(t)
+	claims, err := f.auth.ParseAccessToken(t.Context(), revoked)
+	if err != nil {
+		t.Fatalf("ParseAccessToken: %v", err)
+	}
+	if err := f.auth.Logout(t.Context(), claims, ""); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+
+	fresh := signAccess(t, f.userID, time.Now(), time.Now().Add(time.Minute))
+	expired := signAccess(t, f.userID, time.Now().Add(-time.Hour), time.Now().Add(-time.Minute))
+	ordersKey := f.apiKey(t, models.PermOrdersPlace)
+	wishlistsKey := f.apiKey(t, models.PermWishlistsOwn)
+
+	bearer := func(tok string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+tok) }
+	}
+	cookie := func(v string) func(*http.Request) {
+		return func(r *http.Request) { r.AddCookie(&http.Cookie{Name: testCookie, Value: v}) }
+	}
+	apiKey := func(k string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set(middleware.APIKeyHeader, k) }
+	}
+
+	cases := []struct {
+		name       string
+		creds      []func(*http.Request)
+		wantStatus int
+		wantMethod string
+	}{
+		{"no credentials", nil, http.StatusUnauthorized, ""},
+		{"valid token", []func(*http.Request){bearer(valid)}, http.StatusOK, middleware.MethodBearer},
+		{"valid cookie", []func(*http.Request){cookie(valid)}, http.StatusOK, middleware.MethodCookie},
+		{"freshly signed token", []func(*http.Request){bearer(fresh)}, http.StatusOK, middleware.MethodBearer},
+		{"expired token", []func(*http.Request){bearer(expired)}, http.StatusUnauthorized, ""},
+		{"tampered signature", []func(*http.Request){bearer(valid[:len(valid)-2] + "xx")}, http.StatusUnauthorized, ""},
+		{"revoked jti", []func(*http.Request){bearer(revoked)}, http.StatusUnauthorized, ""},
+		{"revoked jti in cookie", []func(*http.Request){cookie(revoked)}, http.StatusUnauthorized, ""},
+		{"bad cookie", []func(*http.Request){cookie("garbage")}, http.StatusUnauthorized, ""},
+		{"bad token does not fall back to cookie", []func(*http.Request){bearer("garbage"), cookie(valid)}, http.StatusUnauthorized, ""},
+		{"api key with orders scope", []func(*http.Request){apiKey(ordersKey)}, http.StatusOK, middleware.MethodAPIKey},
+		{"api key without orders scope", []func(*http.Request){apiKey(wishlistsKey)}, http.StatusForbidden, ""},
+		{"unknown api key", []func(*http.Request){apiKey("bsk_unknown")}, http.StatusUnauthorized, ""},
+	}
+
+	h := middleware.Scoped(f.chain, func(w http.ResponseWriter, r *http.Request) {
+		p, _ := middleware.FromRequest(r)
+		w.Header().Set("X-Method", p.Method)
+	}, models.PermOrdersPlace, models.PermOrdersRead)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders_api", nil)
+			for _, set := range tc.creds {
+				set(r)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("X-Method"); got != tc.wantMethod {
+				t.Fatalf("method = %q, want %q", got, tc.wantMethod)
+			}
+		})
+	}
+}
+
+func TestAPIKeyPrincipalCarriesOnlyScopes(t *testing.T) {
+	f := newAuthFixture(t)
+	key := f.apiKey(t, models.PermWishlistsOwn)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(middleware.APIKeyHeader, key)
+	p, err := f.chain.Authenticate(r)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if p.UserID != f.userID || !p.Can(models.PermWishlistsOwn) {
+		t.Fatalf("principal = %+v, want user %d with wishlists:own", p, f.userID)
+	}
+	if p.Can(models.PermOrdersPlace) {
+		t.Fatal("api key principal has its owner's orders:place")
+	}
+}
+
+func TestRevokedAPIKey(t *testing.T) {
+	f := newAuthFixture(t)
+	key := f.apiKey(t, models.PermOrdersPlace)
+	if err := f.keys.Revoke(t.Context(), f.userID, f.keys.List(t.Context(), f.userID)[0].ID); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(middleware.APIKeyHeader, key)
+	if _, err := f.chain.Authenticate(r); err != middleware.ErrInvalidCredentials {
+		t.Fatalf("Authenticate with revoked key: err = %v, want invalid credentials", err)
+	}
+}
@@ -0,0 +1,126 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"bookstore/internal/middleware"
+)
+
+func TestCSRF(t *testing.T) {
+	token := strings.Repeat("A", 43) // 32 zero bytes, base64url without padding
+	other := strings.Repeat("B", 43)
+
+	cases := []struct {
+		name    string
+		method  string
+		cookie  string
+		header  string
+		form    string
+		origin  string
+		referer string
+		wantOK  bool
+	}{
+		{name: "GET without cookie", method: http.MethodGet, wantOK: true},
+		{name: "GET with cookie", method: http.MethodGet, cookie: token, wantOK: true},
+		{name: "POST without cookie", method: http.MethodPost, header: token},
+		{name: "POST without token", method: http.MethodPost, cookie: token},
+		{name: "POST with token in header", method: http.MethodPost, cookie: token, header: token, wantOK: true},
+		{name: "POST with token in form", method: http.MethodPost, cookie: token, form: token, wantOK: true},
+		{name: "POST with wrong token", method: http.MethodPost, cookie: token, header: other},
+		{name: "POST with malformed cookie echoed back", method: http.MethodPost, cookie: "short", header: "short"},
+		{name: "DELETE with token", method: http.MethodDelete, cookie: token, header: token, wantOK: true},
+		{name: "PUT without token", method: http.MethodPut, cookie: token},
+		{name: "POST from same origin", method: http.MethodPost, cookie: token, form: token, origin: "http://example.com", wantOK: true},
+		{name: "POST from other origin", method: http.MethodPost, cookie: token, form: token, origin: "http://evil.test"},
+		{name: "POST from null origin", method: http.MethodPost, cookie: token, form: token, origin: "null"},
+		{name: "POST referred by other site", method: http.MethodPost, cookie: token, form: token, referer: "http://evil.test/page"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := ""
+			if tc.form != "" {
+				body = url.Values{middleware.CSRFField: {tc.form}}.Encode()
+			}
+			r := httptest.NewRequest(tc.method, "/cart", strings.NewReader(body))
+			if tc.form != "" {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tc.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: middleware.CSRFCookie, Value: tc.cookie})
+			}
+			if tc.header != "" {
+				r.Header.Set(middleware.CSRFHeader, tc.header)
+			}
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+
+			var seen string
+			reached := false
+			h := middleware.CSRF(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+			}, func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+				seen = middleware.CSRFToken(r)
+			})
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			if reached != tc.wantOK {
+				t.Fatalf("reached handler = %v, want %v (status %d)", reached, tc.wantOK, w.Code)
+			}
+			if !tc.wantOK {
+				if w.Code != http.StatusForbidden {
+					t.Fatalf("status = %d, want 403", w.Code)
+				}
+				return
+			}
+			if tc.cookie != "" && seen != tc.cookie {
+				t.Fatalf("token in context = %q, want the cookie's %q", seen, tc.cookie)
+			}
+		})
+	}
+}
+
+func TestCSRFIssuesToken(t *testing.T) {
+	var seen string
+	h := middleware.CSRF(nil, func(w http.ResponseWriter, r *http.Request) {
+		seen = middleware.CSRFToken(r)
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != middleware.CSRFCookie {
+		t.Fatalf("cookies = %v, want one %s", cookies, middleware.CSRFCookie)
+	}
+	c := cookies[0]
+	if c.Value == "" || c.Value != seen {
+		t.Fatalf("cookie %q and context token %q should match", c.Value, seen)
+	}
+	if !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("cookie = %+v, want HttpOnly and SameSite=Lax", c)
+	}
+
+	// The issued token is accepted on the next unsafe request.
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.AddCookie(c)
+	r.Header.Set(middleware.CSRFHeader, c.Value)
+	reached := false
+	middleware.CSRF(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}, func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	})(httptest.NewRecorder(), r)
+	if !reached {
+		t.Fatal("POST with the issued token was rejected")
+	}
+}
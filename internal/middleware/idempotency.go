@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"maps"
+	"mime"
+	"net/http"
+
+	"bookstore/internal/logic"
+)
+
+const (
+	IdempotencyHeader = "Idempotency-Key"
+	IdempotencyField  = "idempotency_key"
+
+	// ReplayedHeader marks a response that was served from a stored result
+	// rather than by running the request again.
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// Idempotent makes next safe to retry. A request carrying a key in the
+// Idempotency-Key header or the idempotency_key form field runs once per
+// user and endpoint; repeats within the retention window get the stored
+// response back. Requests without a key, or without a signed-in user, pass
+// straight through. Failures, such as a key reused for a different payload,
+// are handed to onError.
+//
+// Only successful responses, 2xx and 3xx, are stored. A failed attempt
+// changed nothing, so its key is freed and a retry runs the request again,
+// e.g. once the missing stock is back.
+func Idempotent(svc *logic.IdempotencyService, onError func(http.ResponseWriter, *http.Request, error), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := UserID(r)
+		key := submittedIdempotencyKey(r)
+		if !ok || key == "" {
+			next(w, r)
+			return
+		}
+
+		fp, err := fingerprint(r)
+		if err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		rec, replay, err := svc.Begin(r.Context(), userID, r.Method+" "+r.URL.Path, key, fp)
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		if replay {
+			if rec.ContentType != "" {
+				w.Header().Set("Content-Type", rec.ContentType)
+			}
+			if rec.Location != "" {
+				w.Header().Set("Location", rec.Location)
+			}
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(rec.Status)
+			_, _ = w.Write(rec.Body)
+			return
+		}
+
+		rw := &responseRecorder{ResponseWriter: w}
+		next(rw, r)
+
+		// The response has gone out already; don't let a client that hung
+		// up stop it from being stored.
+		ctx := context.WithoutCancel(r.Context())
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		if rw.status >= http.StatusBadRequest {
+			if err := svc.Abandon(ctx, rec); err != nil {
+				log.Printf("[IDEMPOTENCY] release failed: key=%s err=%v\n", rec.Key, err)
+			}
+			return
+		}
+
+		rec.Status = rw.status
+		rec.ContentType = w.Header().Get("Content-Type")
+		rec.Location = w.Header().Get("Location")
+		rec.Body = rw.body.Bytes()
+		if err := svc.Finish(ctx, rec); err != nil {
+			log.Printf("[IDEMPOTENCY] store failed: key=%s err=%v\n", rec.Key, err)
+		}
+	}
+}
+
+// NewIdempotencyKey returns a fresh key for a form to submit in the
+// idempotency_key field, so a double-submitted form places one order.
+func NewIdempotencyKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func submittedIdempotencyKey(r *http.Request) string {
+	if v := r.Header.Get(IdempotencyHeader); v != "" {
+		return v
+	}
+	if isForm(r) {
+		return r.PostFormValue(IdempotencyField)
+	}
+	return ""
+}
+
+// fingerprint hashes what makes two requests to the same endpoint the same:
+// the query string and the body. Form bodies are compared field by field,
+// leaving out the CSRF token and the key itself; other bodies byte for byte.
+func fingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, r.URL.Query().Encode())
+	h.Write([]byte{0})
+
+	if isForm(r) {
+		if err := r.ParseForm(); err != nil {
+			return "", err
+		}
+		form := maps.Clone(r.PostForm)
+		delete(form, CSRFField)
+		delete(form, IdempotencyField)
+		io.WriteString(h, form.Encode())
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	if r.Body != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isForm(r *http.Request) bool {
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return ct == "application/x-www-form-urlencoded"
+}
+
+// responseRecorder passes a response through while keeping a copy of its
+// status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,243 @@
+package middleware_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/repository"
+)
+
+type keyedCall struct {
+	user int
+	path string
+	key  string
+	body string
+	form bool
+
+	wantStatus   int
+	wantBody     string
+	wantReplayed bool
+}
+
+func (c keyedCall) request() *http.Request {
+	r := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+	if c.form {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if c.key != "" && !c.form {
+		r.Header.Set(middleware.IdempotencyHeader, c.key)
+	}
+	if c.user != 0 {
+		r = middleware.WithPrincipal(r, middleware.Principal{UserID: c.user})
+	}
+	return r
+}
+
+func idempotencyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, apperr.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, apperr.ErrValidation):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	created := func(user int, path, key, body string, wantBody string, replayed bool) keyedCall {
+		return keyedCall{user: user, path: path, key: key, body: body,
+			wantStatus: http.StatusCreated, wantBody: wantBody, wantReplayed: replayed}
+	}
+
+	cases := []struct {
+		name     string
+		status   int // what the wrapped handler answers with
+		calls    []keyedCall
+		wantRuns int
+	}{
+		{
+			name:   "repeat is replayed",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders", "k", `{"a":1}`, "run 1", false),
+				created(1, "/orders", "k", `{"a":1}`, "run 1", true),
+				created(1, "/orders", "k", `{"a":1}`, "run 1", true),
+			},
+			wantRuns: 1,
+		},
+		{
+			name:   "different payload conflicts",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders", "k", `{"a":1}`, "run 1", false),
+				{user: 1, path: "/orders", key: "k", body: `{"a":2}`, wantStatus: http.StatusConflict},
+			},
+			wantRuns: 1,
+		},
+		{
+			name:   "different query conflicts",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders?x=1", "k", "", "run 1", false),
+				{user: 1, path: "/orders?x=2", key: "k", wantStatus: http.StatusConflict},
+			},
+			wantRuns: 1,
+		},
+		{
+			name:   "new key runs again",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders", "k1", `{"a":1}`, "run 1", false),
+				created(1, "/orders", "k2", `{"a":1}`, "run 2", false),
+			},
+			wantRuns: 2,
+		},
+		{
+			name:   "keys are per endpoint",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders", "k", `{"a":1}`, "run 1", false),
+				created(1, "/wishlists", "k", `{"a":1}`, "run 2", false),
+			},
+			wantRuns: 2,
+		},
+		{
+			name:   "keys are per user",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders", "k", `{"a":1}`, "run 1", false),
+				created(2, "/orders", "k", `{"a":1}`, "run 2", false),
+			},
+			wantRuns: 2,
+		},
+		{
+			name:   "failures are not stored",
+			status: http.StatusConflict,
+			calls: []keyedCall{
+				{user: 1, path: "/orders", key: "k", body: `{"a":1}`, wantStatus: http.StatusConflict, wantBody: "run 1"},
+				{user: 1, path: "/orders", key: "k", body: `{"a":1}`, wantStatus: http.StatusConflict, wantBody: "run 2"},
+			},
+			wantRuns: 2,
+		},
+		{
+			name:   "no key",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(1, "/orders", "", `{"a":1}`, "run 1", false),
+				created(1, "/orders", "", `{"a":1}`, "run 2", false),
+			},
+			wantRuns: 2,
+		},
+		{
+			name:   "no signed-in user",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				created(0, "/orders", "k", `{"a":1}`, "run 1", false),
+				created(0, "/orders", "k", `{"a":1}`, "run 2", false),
+			},
+			wantRuns: 2,
+		},
+		{
+			name:   "oversized key",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				{user: 1, path: "/orders", key: strings.Repeat("k", 256), wantStatus: http.StatusBadRequest},
+			},
+			wantRuns: 0,
+		},
+		{
+			name:   "form resubmitted with a new csrf token is replayed",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				{user: 1, path: "/orders", form: true, body: "idempotency_key=k&csrf_token=one&cart=3",
+					wantStatus: http.StatusCreated, wantBody: "run 1"},
+				{user: 1, path: "/orders", form: true, body: "idempotency_key=k&csrf_token=two&cart=3",
+					wantStatus: http.StatusCreated, wantBody: "run 1", wantReplayed: true},
+			},
+			wantRuns: 1,
+		},
+		{
+			name:   "form with other fields conflicts",
+			status: http.StatusCreated,
+			calls: []keyedCall{
+				{user: 1, path: "/orders", form: true, body: "idempotency_key=k&cart=3",
+					wantStatus: http.StatusCreated, wantBody: "run 1"},
+				{user: 1, path: "/orders", form: true, body: "idempotency_key=k&cart=4",
+					wantStatus: http.StatusConflict},
+			},
+			wantRuns: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := logic.NewIdempotencyService(repository.NewMemoryIdempotencyRepo(), 0)
+			runs := 0
+			h := middleware.Idempotent(svc, idempotencyError, func(w http.ResponseWriter, r *http.Request) {
+				runs++
+				w.WriteHeader(tc.status)
+				fmt.Fprintf(w, "run %d", runs)
+			})
+
+			for i, c := range tc.calls {
+				w := httptest.NewRecorder()
+				h(w, c.request())
+
+				if w.Code != c.wantStatus {
+					t.Fatalf("call %d: status = %d, want %d (%s)", i, w.Code, c.wantStatus, w.Body)
+				}
+				if c.wantBody != "" && w.Body.String() != c.wantBody {
+					t.Fatalf("call %d: body = %q, want %q", i, w.Body, c.wantBody)
+				}
+				if got := w.Header().Get(middleware.ReplayedHeader) == "true"; got != c.wantReplayed {
+					t.Fatalf("call %d: replayed = %v, want %v", i, got, c.wantReplayed)
+				}
+			}
+			if runs != tc.wantRuns {
+				t.Fatalf("handler ran %d times, want %d", runs, tc.wantRuns)
+			}
+		})
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	svc := logic.NewIdempotencyService(repository.NewMemoryIdempotencyRepo(), 0)
+	call := keyedCall{user: 1, path: "/orders", key: "k", body: `{"a":1}`}
+
+	var inner *httptest.ResponseRecorder
+	var h http.HandlerFunc
+	h = middleware.Idempotent(svc, idempotencyError, func(w http.ResponseWriter, r *http.Request) {
+		// A retry that lands while the first attempt is still running.
+		if inner == nil {
+			inner = httptest.NewRecorder()
+			h(inner, call.request())
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	w := httptest.NewRecorder()
+	h(w, call.request())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first attempt: status = %d, want 201", w.Code)
+	}
+	if inner.Code != http.StatusConflict {
+		t.Fatalf("retry during first attempt: status = %d, want 409", inner.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h(w, call.request())
+	if w.Code != http.StatusCreated || w.Header().Get(middleware.ReplayedHeader) != "true" {
+		t.Fatalf("retry after first attempt: status = %d replayed = %q, want replayed 201",
+			w.Code, w.Header().Get(middleware.ReplayedHeader))
+	}
+}
@@ -0,0 +1,163 @@
+// Package migrate applies versioned changes to the Mongo schema: indexes and
+// one-off data backfills. Applied versions are recorded in the "migrations"
+// collection, so each migration runs once per database.
+//
+// Migrations are run by cmd/migrate, or by the server at startup when
+// MIGRATE_ON_START=true. Append new ones to All with the next version number;
+// never renumber or edit a migration that may already have been applied.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	recordsCollection = "migrations"
+	lockCollection    = "migrations_lock"
+
+	// A lock older than this is assumed to belong to a runner that died.
+	staleLockAfter = 10 * time.Minute
+)
+
+var ErrLocked = errors.New("another migration run is in progress")
+
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+type Record struct {
+	Version     int       `bson:"_id" json:"version"`
+	Description string    `bson:"description" json:"description"`
+	AppliedAt   time.Time `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Run applies every migration in list that has not been recorded yet, in
+// version order, and returns the ones it applied. It stops at the first
+// failure; migrations applied before it stay recorded.
+func Run(ctx context.Context, db *mongo.Database, list []Migration) ([]Migration, error) {
+	if err := validate(list); err != nil {
+		return nil, err
+	}
+
+	release, err := acquireLock(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	todo, err := Pending(ctx, db, list)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, m := range todo {
+		log.Printf("[MIGRATE] applying %d: %s\n", m.Version, m.Description)
+		if err := m.Up(ctx, db); err != nil {
+			return ran, fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+
+		rec := Record{Version: m.Version, Description: m.Description, AppliedAt: time.Now()}
+		if _, err := db.Collection(recordsCollection).InsertOne(ctx, rec); err != nil {
+			return ran, fmt.Errorf("record migration %d: %w", m.Version, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
+
+// Applied lists the recorded migrations, oldest first.
+func Applied(ctx context.Context, db *mongo.Database) ([]Record, error) {
+	cur, err := db.Collection(recordsCollection).Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []Record{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Pending returns the migrations in list that have not been applied.
+func Pending(ctx context.Context, db *mongo.Database, list []Migration) ([]Migration, error) {
+	done, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool, len(done))
+	for _, rec := range done {
+		seen[rec.Version] = true
+	}
+
+	var out []Migration
+	for _, m := range list {
+		if !seen[m.Version] {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func validate(list []Migration) error {
+	prev := 0
+	for _, m := range list {
+		if m.Version <= prev {
+			return fmt.Errorf("migration versions must be positive and increasing: %d after %d", m.Version, prev)
+		}
+		if m.Up == nil {
+			return fmt.Errorf("migration %d has no Up step", m.Version)
+		}
+		prev = m.Version
+	}
+	return nil
+}
+
+// acquireLock keeps two processes (say, a deploy starting several servers at
+// once) from running the same migration concurrently.
+func acquireLock(ctx context.Context, db *mongo.Database) (func(), error) {
+	col := db.Collection(lockCollection)
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d", host, os.Getpid())
+
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		_, err := col.InsertOne(ctx, bson.M{"_id": "lock", "owner": owner, "lockedAt": now})
+		if err == nil {
+			release := func() {
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+				defer cancel()
+				if _, err := col.DeleteOne(ctx, bson.M{"_id": "lock", "owner": owner}); err != nil {
+					log.Printf("[MIGRATE] failed to release lock: %v\n", err)
+				}
+			}
+			return release, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		res, err := col.DeleteOne(ctx, bson.M{"_id": "lock", "lockedAt": bson.M{"$lt": now.Add(-staleLockAfter)}})
+		if err != nil {
+			return nil, err
+		}
+		if res.DeletedCount == 0 {
+			return nil, ErrLocked
+		}
+		log.Println("[MIGRATE] removed stale lock")
+	}
+	return nil, ErrLocked
+}
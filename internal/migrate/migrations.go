@@ -0,0 +1,246 @@
+package migrate
+
+import (
+	"context"
+
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// All is the full migration history, oldest first.
+var All = []Migration{
+	{1, "unique id index on every collection with integer ids", uniqueIDs},
+	{2, "normalise user emails and make them unique", uniqueEmails},
+	{3, "give users without a role the customer role", defaultRoles},
+	{4, "indexes for item, address, key and token lookups", lookupIndexes},
+	{5, "book search, genre and price indexes", bookIndexes},
+	{6, "deletedAt indexes for the purge job", deletedAtIndexes},
+	{7, "start books, orders and carts at version 1", initialVersions},
+	{8, "stock reservation and movement indexes", inventoryIndexes},
+	{9, "give orders without a status the pending status", initialOrderStatus},
+	{10, "payment indexes", paymentIndexes},
+	{11, "refund indexes", refundIndexes},
+	{12, "expire idempotency keys", idempotencyIndexes},
+	{13, "expire session records and keep one logout cutoff per user", sessionIndexes},
+}
+
+func uniqueIDs(ctx context.Context, db *mongo.Database) error {
+	for _, name := range []string{
+		"books", "users", "carts", "cart_items", "orders", "order_items",
+		"wishlists", "wishlist_items", "addresses", "api_keys",
+	} {
+		err := createIndexes(ctx, db, name, mongo.IndexModel{
+			Keys:    bson.D{{Key: "id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueEmails lower-cases stored emails first: accounts created before login
+// normalised addresses could otherwise slip past the unique index. If two
+// accounts collapse onto the same address the index build fails and they
+// have to be merged by hand.
+func uniqueEmails(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").UpdateMany(ctx, bson.M{}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"email": bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}}}},
+	})
+	if err != nil {
+		return err
+	}
+	return createIndexes(ctx, db, "users", mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+func defaultRoles(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"role": bson.M{"$in": bson.A{nil, ""}}},
+		bson.M{"$set": bson.M{"role": models.RoleCustomer}},
+	)
+	return err
+}
+
+func lookupIndexes(ctx context.Context, db *mongo.Database) error {
+	steps := []struct {
+		collection string
+		indexes    []mongo.IndexModel
+	}{
+		{"cart_items", []mongo.IndexModel{{
+			// AddItem merges lines per book, so a second line for the same
+			// book can only come from a race.
+			Keys:    bson.D{{Key: "cartId", Value: 1}, {Key: "bookId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}}},
+		{"wishlist_items", []mongo.IndexModel{{
+			Keys: bson.D{{Key: "wishlistId", Value: 1}, {Key: "bookId", Value: 1}},
+		}}},
+		{"order_items", []mongo.IndexModel{{
+			Keys: bson.D{{Key: "orderId", Value: 1}},
+		}}},
+		{"addresses", []mongo.IndexModel{{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "kind", Value: 1}},
+		}}},
+		{"api_keys", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "keyHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+		}},
+		{"refresh_tokens", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "familyId", Value: 1}}},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+		}},
+		{"login_attempts", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "ip", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "email", Value: 1}, {Key: "createdAt", Value: -1}}},
+		}},
+	}
+	for _, s := range steps {
+		if err := createIndexes(ctx, db, s.collection, s.indexes...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bookIndexes backs the catalogue filters. The text index serves whole-word
+// search on title and author; BookRepository.Find's substring search still
+// scans, which is fine at catalogue sizes.
+func bookIndexes(ctx context.Context, db *mongo.Database) error {
+	return createIndexes(ctx, db, "books",
+		mongo.IndexModel{Keys: bson.D{{Key: "title", Value: "text"}, {Key: "author", Value: "text"}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "genre", Value: 1}, {Key: "id", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "price", Value: 1}, {Key: "id", Value: 1}}},
+	)
+}
+
+// deletedAtIndexes only cover soft-deleted documents, which keeps them small;
+// the purge job's range query on deletedAt is the only reader.
+func deletedAtIndexes(ctx context.Context, db *mongo.Database) error {
+	for _, name := range []string{"books", "orders", "wishlists"} {
+		err := createIndexes(ctx, db, name, mongo.IndexModel{
+			Keys: bson.D{{Key: "deletedAt", Value: 1}},
+			Options: options.Index().SetPartialFilterExpression(bson.M{
+				"deletedAt": bson.M{"$exists": true},
+			}),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initialVersions gives documents written before versioning a version, so
+// their ETags can be matched and conditional updates apply to them.
+func initialVersions(ctx context.Context, db *mongo.Database) error {
+	for _, name := range []string{"books", "orders", "carts"} {
+		_, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{"version": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"version": 1}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inventoryIndexes back cart holds, which are keyed by cart and book and
+// swept by expiry, and the per-book movement ledger, newest first.
+func inventoryIndexes(ctx context.Context, db *mongo.Database) error {
+	err := createIndexes(ctx, db, "stock_reservations",
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "cartId", Value: 1}, {Key: "bookId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		mongo.IndexModel{Keys: bson.D{{Key: "expiresAt", Value: 1}}},
+	)
+	if err != nil {
+		return err
+	}
+	return createIndexes(ctx, db, "stock_movements",
+		mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "bookId", Value: 1}, {Key: "createdAt", Value: -1}}},
+	)
+}
+
+// initialOrderStatus starts orders placed before the lifecycle existed at
+// pending, since transitions only apply to an order in the expected status.
+func initialOrderStatus(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("orders").UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": models.OrderPending}},
+	)
+	if err != nil {
+		return err
+	}
+	return createIndexes(ctx, db, "orders", mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}}})
+}
+
+// paymentIndexes make a provider's intent map to exactly one payment, which
+// is how webhooks find what they are about.
+func paymentIndexes(ctx context.Context, db *mongo.Database) error {
+	return createIndexes(ctx, db, "payments",
+		mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "intentId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		mongo.IndexModel{Keys: bson.D{{Key: "orderId", Value: 1}}},
+	)
+}
+
+// refundIndexes make a provider refund count once per order: two refunds
+// worked out from the same state of an order reach the provider with the
+// same key, and only the first is recorded.
+func refundIndexes(ctx context.Context, db *mongo.Database) error {
+	return createIndexes(ctx, db, "refunds",
+		mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "orderId", Value: 1}, {Key: "providerRefundId", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+}
+
+// idempotencyIndexes let Mongo drop stored responses once their retention
+// window is over; expiresAt already holds the deadline.
+func idempotencyIndexes(ctx context.Context, db *mongo.Database) error {
+	return createIndexes(ctx, db, "idempotency_keys", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+// sessionIndexes let Mongo drop revocations, refresh tokens and login
+// attempts once they can no longer matter, and stop concurrent logouts from
+// upserting two cutoffs for one user. If duplicates already exist the unique
+// build fails and the older cutoffs have to be removed by hand.
+func sessionIndexes(ctx context.Context, db *mongo.Database) error {
+	expire := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	for _, name := range []string{"revoked_tokens", "refresh_tokens", "login_attempts"} {
+		if err := createIndexes(ctx, db, name, expire); err != nil {
+			return err
+		}
+	}
+	return createIndexes(ctx, db, "session_cutoffs", mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+}
+
+func createIndexes(ctx context.Context, db *mongo.Database, collection string, indexes ...mongo.IndexModel) error {
+	_, err := db.Collection(collection).Indexes().CreateMany(ctx, indexes)
+	return err
+}
@@ -9,6 +9,46 @@ type Book struct {
 	Genre       string  `json:"genre" bson:"genre"`
 	Price       float64 `json:"price" bson:"price"`
 	Description string  `json:"description" bson:"description"`
+
+	Version int `json:"version" bson:"version"`
+
+	// Stock is nil for books whose inventory is not tracked; those never
+	// run out. Reserved is the part of Stock held by carts.
+	Stock    *int `json:"stock,omitempty" bson:"stock,omitempty"`
+	Reserved int  `json:"reserved,omitempty" bson:"reserved,omitempty"`
+
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy int        `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
+}
+
+// StockReservation holds Qty of a book for a cart until ExpiresAt.
+type StockReservation struct {
+	CartID    int       `json:"cartId" bson:"cartId"`
+	BookID    int       `json:"bookId" bson:"bookId"`
+	Qty       int       `json:"qty" bson:"qty"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+const (
+	MovementInitial    = "initial"
+	MovementAdjustment = "adjustment"
+	MovementOrder      = "order"
+	MovementCancel     = "cancel"
+	MovementRefund     = "refund"
+)
+
+// StockMovement is one entry in the inventory ledger. Delta is negative for
+// stock leaving the shelf.
+type StockMovement struct {
+	ID         int       `json:"id" bson:"id"`
+	BookID     int       `json:"bookId" bson:"bookId"`
+	Delta      int       `json:"delta" bson:"delta"`
+	StockAfter int       `json:"stockAfter" bson:"stockAfter"`
+	Reason     string    `json:"reason" bson:"reason"`
+	OrderID    int       `json:"orderId,omitempty" bson:"orderId,omitempty"`
+	ActorID    int       `json:"actorId,omitempty" bson:"actorId,omitempty"`
+	Note       string    `json:"note,omitempty" bson:"note,omitempty"`
+	CreatedAt  time.Time `json:"createdAt" bson:"createdAt"`
 }
 
 type BookQuery struct {
@@ -20,25 +60,148 @@ type BookQuery struct {
 	Order    string
 }
 
+const (
+	RoleAdmin         = "admin"
+	RoleCatalogEditor = "catalog-editor"
+	RoleOrderManager  = "order-manager"
+	RoleSupport       = "support"
+	RoleCustomer      = "customer"
+)
+
+type Permission string
+
+const (
+	PermBooksWrite     Permission = "books:write"
+	PermCartsOwn       Permission = "carts:own"
+	PermCartsRead      Permission = "carts:read"
+	PermCartsWrite     Permission = "carts:write"
+	PermOrdersPlace    Permission = "orders:place"
+	PermOrdersRead     Permission = "orders:read"
+	PermOrdersWrite    Permission = "orders:write"
+	PermOrdersDelete   Permission = "orders:delete"
+	PermOrdersRefund   Permission = "orders:refund"
+	PermWishlistsOwn   Permission = "wishlists:own"
+	PermWishlistsWrite Permission = "wishlists:write"
+	PermUsersRead      Permission = "users:read"
+	PermUsersWrite     Permission = "users:write"
+)
+
 type User struct {
 	ID       int    `json:"id" bson:"id"`
 	Email    string `json:"email" bson:"email"`
-	Password string `json:"password" bson:"password"`
+	Password string `json:"-" bson:"password"`
 	Role     string `json:"role" bson:"role"`
 	Address  string `json:"address,omitempty" bson:"address,omitempty"`
+	Name     string `json:"name,omitempty" bson:"name,omitempty"`
+	Phone    string `json:"phone,omitempty" bson:"phone,omitempty"`
+
+	EmailVerified   bool       `json:"emailVerified" bson:"emailVerified"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty" bson:"emailVerifiedAt,omitempty"`
+
+	FailedLogins      int        `json:"failedLogins" bson:"failedLogins"`
+	LastFailedLoginAt *time.Time `json:"lastFailedLoginAt,omitempty" bson:"lastFailedLoginAt,omitempty"`
+	LockedUntil       *time.Time `json:"lockedUntil,omitempty" bson:"lockedUntil,omitempty"`
+
+	TOTPSecret    string     `json:"-" bson:"totpSecret,omitempty"`
+	TOTPEnabled   bool       `json:"totpEnabled" bson:"totpEnabled"`
+	TOTPEnabledAt *time.Time `json:"totpEnabledAt,omitempty" bson:"totpEnabledAt,omitempty"`
+	TOTPLastStep  int64      `json:"-" bson:"totpLastStep,omitempty"`
+	RecoveryCodes []string   `json:"-" bson:"recoveryCodes,omitempty"`
+}
+
+const (
+	AddressShipping = "shipping"
+	AddressBilling  = "billing"
+)
+
+type Address struct {
+	ID         int    `json:"id" bson:"id"`
+	UserID     int    `json:"userId" bson:"userId"`
+	Label      string `json:"label" bson:"label"`
+	Kind       string `json:"kind" bson:"kind"`
+	Recipient  string `json:"recipient" bson:"recipient"`
+	Line1      string `json:"line1" bson:"line1"`
+	Line2      string `json:"line2,omitempty" bson:"line2,omitempty"`
+	City       string `json:"city" bson:"city"`
+	Region     string `json:"region,omitempty" bson:"region,omitempty"`
+	PostalCode string `json:"postalCode" bson:"postalCode"`
+	Country    string `json:"country" bson:"country"`
+	Phone      string `json:"phone,omitempty" bson:"phone,omitempty"`
+	IsDefault  bool   `json:"isDefault" bson:"isDefault"`
+}
+
+type LoginAttempt struct {
+	Email     string    `json:"email" bson:"email"`
+	UserID    int       `json:"userId,omitempty" bson:"userId,omitempty"`
+	IP        string    `json:"ip" bson:"ip"`
+	Success   bool      `json:"success" bson:"success"`
+	Reason    string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time `json:"-" bson:"expiresAt"`
+}
+
+type RefreshToken struct {
+	TokenHash string     `json:"-" bson:"tokenHash"`
+	UserID    int        `json:"userId" bson:"userId"`
+	FamilyID  string     `json:"familyId" bson:"familyId"`
+	MFA       bool       `json:"mfa" bson:"mfa"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+type RevokedToken struct {
+	JTI       string    `json:"jti" bson:"_id"`
+	UserID    int       `json:"userId" bson:"userId"`
+	RevokedAt time.Time `json:"revokedAt" bson:"revokedAt"`
+	ExpiresAt time.Time `json:"expiresAt" bson:"expiresAt"`
+}
+
+type APIKey struct {
+	ID         int          `json:"id" bson:"id"`
+	UserID     int          `json:"userId" bson:"userId"`
+	Name       string       `json:"name" bson:"name"`
+	Prefix     string       `json:"prefix" bson:"prefix"`
+	KeyHash    string       `json:"-" bson:"keyHash"`
+	Scopes     []Permission `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time    `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  *time.Time   `json:"expiresAt,omitempty" bson:"expiresAt,omitempty"`
+	LastUsedAt *time.Time   `json:"lastUsedAt,omitempty" bson:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time   `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
 }
 
 type Cart struct {
-	ID         int
-	CustomerID int
-	CreatedAt  time.Time
+	ID         int       `bson:"id"`
+	CustomerID int       `bson:"customerId"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	Version    int       `bson:"version"`
 }
 
 type CartItem struct {
-	ID     int
-	CartID int
-	BookID int
-	Qty    int
+	ID     int `bson:"id"`
+	CartID int `bson:"cartId"`
+	BookID int `bson:"bookId"`
+	Qty    int `bson:"qty"`
+}
+
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderPacked    = "packed"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+)
+
+// StatusChange is one step of an order's lifecycle. The first entry of an
+// order's history has no From.
+type StatusChange struct {
+	From string    `json:"from,omitempty" bson:"from,omitempty"`
+	To   string    `json:"to" bson:"to"`
+	At   time.Time `json:"at" bson:"at"`
+	By   int       `json:"by,omitempty" bson:"by,omitempty"`
+	Note string    `json:"note,omitempty" bson:"note,omitempty"`
 }
 
 type Order struct {
@@ -46,6 +209,17 @@ type Order struct {
 	CustomerID int     `json:"customerId" bson:"customerId"`
 	CartID     int     `json:"cartId" bson:"cartId"`
 	Total      float64 `json:"total" bson:"total"`
+	Version    int     `json:"version" bson:"version"`
+
+	Status  string         `json:"status" bson:"status"`
+	History []StatusChange `json:"history,omitempty" bson:"history,omitempty"`
+
+	// ShippingAddress is a copy taken when the order is placed, so later
+	// edits to the address book do not rewrite order history.
+	ShippingAddress *Address `json:"shippingAddress,omitempty" bson:"shippingAddress,omitempty"`
+
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy int        `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
 
 type OrderItem struct {
@@ -56,16 +230,54 @@ type OrderItem struct {
 	Price   float64 `json:"price" bson:"price"`
 }
 
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// Payment is one attempt to collect an order's total through a payment
+// provider. An order can have several, e.g. a declined one and a retry.
 type Payment struct {
-	ID      int
-	OrderID int
-	Total   float64
-	Status  string
+	ID      int     `json:"id" bson:"id"`
+	OrderID int     `json:"orderId" bson:"orderId"`
+	Total   float64 `json:"total" bson:"total"`
+	Status  string  `json:"status" bson:"status"`
+
+	Provider      string    `json:"provider" bson:"provider"`
+	IntentID      string    `json:"intentId" bson:"intentId"`
+	FailureReason string    `json:"failureReason,omitempty" bson:"failureReason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// RefundItem is the part of one order line that a refund covers.
+type RefundItem struct {
+	OrderItemID int     `json:"orderItemId" bson:"orderItemId"`
+	BookID      int     `json:"bookId" bson:"bookId"`
+	Qty         int     `json:"qty" bson:"qty"`
+	Amount      float64 `json:"amount" bson:"amount"`
+}
+
+// Refund is money given back against an order's settled payment.
+type Refund struct {
+	ID               int          `json:"id" bson:"id"`
+	OrderID          int          `json:"orderId" bson:"orderId"`
+	PaymentID        int          `json:"paymentId" bson:"paymentId"`
+	Amount           float64      `json:"amount" bson:"amount"`
+	Items            []RefundItem `json:"items" bson:"items"`
+	Reason           string       `json:"reason,omitempty" bson:"reason,omitempty"`
+	ProviderRefundID string       `json:"providerRefundId" bson:"providerRefundId"`
+	CreatedBy        int          `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt        time.Time    `json:"createdAt" bson:"createdAt"`
 }
 
 type Wishlist struct {
 	ID         int `json:"id" bson:"id"`
 	CustomerID int `json:"customerId" bson:"customerId"`
+
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	DeletedBy int        `json:"deletedBy,omitempty" bson:"deletedBy,omitempty"`
 }
 
 type WishlistItem struct {
@@ -74,3 +286,18 @@ type WishlistItem struct {
 	BookID     int `json:"bookId" bson:"bookId"`
 	Qty        int `json:"qty" bson:"qty"`
 }
+
+// IdempotencyRecord remembers the response to a request sent with an
+// idempotency key so a retry gets the same answer instead of repeating the
+// work. Status stays 0 while the first request is still running.
+type IdempotencyRecord struct {
+	Key         string    `json:"key" bson:"_id"`
+	UserID      int       `json:"userId" bson:"userId"`
+	Fingerprint string    `json:"fingerprint" bson:"fingerprint"`
+	Status      int       `json:"status" bson:"status"`
+	ContentType string    `json:"contentType,omitempty" bson:"contentType,omitempty"`
+	Location    string    `json:"location,omitempty" bson:"location,omitempty"`
+	Body        []byte    `json:"-" bson:"body,omitempty"`
+	CreatedAt   time.Time `json:"createdAt" bson:"createdAt"`
+	ExpiresAt   time.Time `json:"expiresAt" bson:"expiresAt"`
+}
@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"bookstore/internal/models"
+)
+
+// Fake is a provider for local runs and tests; it never moves money. The
+// outcome depends only on the amount: totals ending in 13 cents are
+// declined and everything else is paid. Nothing is sent by itself either;
+// Settle produces the webhook a real provider would deliver.
+type Fake struct {
+	secret []byte
+}
+
+func NewFake(secret string) *Fake {
+	return &Fake{secret: []byte(secret)}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) CreateIntent(ctx context.Context, key string, orderID int, amount float64) (Intent, error) {
+	if key == "" {
+		return Intent{}, errors.New("payments: intent key required")
+	}
+	return Intent{ID: "fake_pi_" + key, Amount: amount}, nil
+}
+
+func (f *Fake) ParseEvent(payload []byte, signature string) (Event, error) {
+	if err := verify(f.secret, payload, signature); err != nil {
+		return Event{}, err
+	}
+	var ev Event
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return Event{}, err
+	}
+	if ev.ID == "" || ev.IntentID == "" {
+		return Event{}, errors.New("payments: event without id or intent")
+	}
+	return ev, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, key, intentID string, amount float64) (string, error) {
+	if key == "" {
+		return "", errors.New("payments: refund key required")
+	}
+	return "fake_re_" + key, nil
+}
+
+// Settle returns the signed webhook body for the completion of an intent.
+func (f *Fake) Settle(intentID string, amount float64) (payload []byte, signature string) {
+	ev := Event{ID: "evt_" + intentID, IntentID: intentID, Status: models.PaymentSucceeded}
+	if int(math.Round(amount*100))%100 == 13 {
+		ev.Status = models.PaymentFailed
+		ev.Reason = "card_declined"
+	}
+	payload, _ = json.Marshal(ev)
+	return payload, sign(f.secret, payload)
+}
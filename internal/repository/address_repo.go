@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type AddressRepository interface {
+	Create(ctx context.Context, a models.Address) (models.Address, error)
+	GetByID(ctx context.Context, id int) (models.Address, error)
+	ListByUser(ctx context.Context, userID int) []models.Address
+	Update(ctx context.Context, a models.Address) error
+	Delete(ctx context.Context, id int) error
+	SetDefault(ctx context.Context, userID int, kind string, id int) error
+}
+
+type AddressRepo struct {
+	col *mongo.Collection
+	ids IDGenerator
+}
+
+func NewAddressRepo(db *mongo.Database, ids *IDs) *AddressRepo {
+	return &AddressRepo{
+		col: db.Collection("addresses"),
+		ids: ids.For("addresses"),
+	}
+}
+
+func (r *AddressRepo) Create(ctx context.Context, a models.Address) (models.Address, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if a.UserID <= 0 {
+		return models.Address{}, apperr.Invalid("userId", "userId must be positive")
+	}
+
+	id, err := r.ids.Next(ctx)
+	if err != nil {
+		return models.Address{}, err
+	}
+	a.ID = id
+
+	if _, err := r.col.InsertOne(ctx, a); err != nil {
+		return models.Address{}, err
+	}
+	return a, nil
+}
+
+func (r *AddressRepo) GetByID(ctx context.Context, id int) (models.Address, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var a models.Address
+	err := r.col.FindOne(ctx, bson.M{"id": id}).Decode(&a)
+	if err == mongo.ErrNoDocuments {
+		return models.Address{}, apperr.NotFound("address not found")
+	}
+	return a, err
+}
+
+func (r *AddressRepo) ListByUser(ctx context.Context, userID int) []models.Address {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.col.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return []models.Address{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Address{}
+	for cur.Next(ctx) {
+		var a models.Address
+		if cur.Decode(&a) == nil {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func (r *AddressRepo) Update(ctx context.Context, a models.Address) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if a.ID <= 0 {
+		return apperr.Invalid("id", "invalid address id")
+	}
+
+	// Replace rather than $set so cleared optional fields are dropped.
+	res, err := r.col.ReplaceOne(ctx, bson.M{"id": a.ID, "userId": a.UserID}, a)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("address not found")
+	}
+	return nil
+}
+
+func (r *AddressRepo) Delete(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return apperr.NotFound("address not found")
+	}
+	return nil
+}
+
+func (r *AddressRepo) SetDefault(ctx context.Context, userID int, kind string, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"id": id, "userId": userID, "kind": kind},
+		bson.M{"$set": bson.M{"isDefault": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("address not found")
+	}
+
+	_, err = r.col.UpdateMany(
+		ctx,
+		bson.M{"userId": userID, "kind": kind, "id": bson.M{"$ne": id}},
+		bson.M{"$set": bson.M{"isDefault": false}},
+	)
+	return err
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, k models.APIKey) (models.APIKey, error)
+	GetByHash(ctx context.Context, hash string) (models.APIKey, error)
+	ListByUser(ctx context.Context, userID int) []models.APIKey
+	Revoke(ctx context.Context, userID int, id int) error
+	TouchLastUsed(ctx context.Context, id int, at time.Time) error
+}
+
+type APIKeyRepo struct {
+	col *mongo.Collection
+	ids IDGenerator
+}
+
+func NewAPIKeyRepo(db *mongo.Database, ids *IDs) *APIKeyRepo {
+	return &APIKeyRepo{
+		col: db.Collection("api_keys"),
+		ids: ids.For("api_keys"),
+	}
+}
+
+func (r *APIKeyRepo) Create(ctx context.Context, k models.APIKey) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if k.KeyHash == "" {
+		return models.APIKey{}, errors.New("key hash required")
+	}
+	if k.UserID <= 0 {
+		return models.APIKey{}, apperr.Invalid("userId", "userId must be positive")
+	}
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+
+	id, err := r.ids.Next(ctx)
+	if err != nil {
+		return models.APIKey{}, err
+	}
+	k.ID = id
+
+	if _, err := r.col.InsertOne(ctx, k); err != nil {
+		return models.APIKey{}, err
+	}
+	return k, nil
+}
+
+func (r *APIKeyRepo) GetByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var k models.APIKey
+	err := r.col.FindOne(ctx, bson.M{"keyHash": hash}).Decode(&k)
+	if err == mongo.ErrNoDocuments {
+		return models.APIKey{}, apperr.NotFound("api key not found")
+	}
+	return k, err
+}
+
+func (r *APIKeyRepo) ListByUser(ctx context.Context, userID int) []models.APIKey {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.col.Find(ctx, bson.M{"userId": userID}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return []models.APIKey{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.APIKey{}
+	for cur.Next(ctx) {
+		var k models.APIKey
+		if cur.Decode(&k) == nil {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func (r *APIKeyRepo) Revoke(ctx context.Context, userID int, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"id": id, "userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("api key not found or already revoked")
+	}
+	return nil
+}
+
+func (r *APIKeyRepo) TouchLastUsed(ctx context.Context, id int, at time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.col.UpdateOne(ctx, bson.M{"id": id}, bson.M{"$max": bson.M{"lastUsedAt": at}})
+	return err
+}
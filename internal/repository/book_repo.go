@@ -2,9 +2,9 @@ package repository
 
 import (
 	"context"
-	"errors"
 	"time"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,36 +13,49 @@ import (
 )
 
 type BookRepository interface {
-	Create(book models.Book) (models.Book, error)
-	GetByID(id int) (models.Book, error)
-	GetAll() []models.Book
-	Update(book models.Book) error
-	Delete(id int) error
-
+	Create(ctx context.Context, book models.Book) (models.Book, error)
+	GetByID(ctx context.Context, id int) (models.Book, error)
+	GetAll(ctx context.Context) []models.Book
+	// Update stores book and returns it with its new version. A non-zero
+	// book.Version must match the stored one.
+	Update(ctx context.Context, book models.Book) (models.Book, error)
 	Find(ctx context.Context, q models.BookQuery) ([]models.Book, error)
+
+	// FindByIDs also returns soft-deleted books, so past orders can still
+	// show what was bought.
+	FindByIDs(ctx context.Context, ids []int) ([]models.Book, error)
+
+	Delete(ctx context.Context, id, deletedBy int) error
+	Restore(ctx context.Context, id int) error
+	// Purge hard-deletes books soft-deleted before the cutoff, except the
+	// ones listed in keep.
+	Purge(ctx context.Context, before time.Time, keep []int) (int, error)
 }
 
 type BookRepo struct {
-	col      *mongo.Collection
-	counters *CounterRepo
+	col *mongo.Collection
+	ids IDGenerator
 }
 
-func NewBookRepo(db *mongo.Database) *BookRepo {
+func NewBookRepo(db *mongo.Database, ids *IDs) *BookRepo {
 	return &BookRepo{
-		col:      db.Collection("books"),
-		counters: NewCounterRepo(db),
+		col: db.Collection("books"),
+		ids: ids.For("books"),
 	}
 }
 
-func (r *BookRepo) Create(book models.Book) (models.Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *BookRepo) Create(ctx context.Context, book models.Book) (models.Book, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	id, err := r.counters.Next("books")
+	id, err := r.ids.Next(ctx)
 	if err != nil {
 		return models.Book{}, err
 	}
 	book.ID = id
+	book.DeletedAt, book.DeletedBy = nil, 0
+	book.Version = 1
+	book.Reserved = 0
 
 	_, err = r.col.InsertOne(ctx, book)
 	if err != nil {
@@ -52,23 +65,23 @@ func (r *BookRepo) Create(book models.Book) (models.Book, error) {
 	return book, nil
 }
 
-func (r *BookRepo) GetByID(id int) (models.Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *BookRepo) GetByID(ctx context.Context, id int) (models.Book, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var b models.Book
-	err := r.col.FindOne(ctx, bson.M{"id": id}).Decode(&b)
+	err := r.col.FindOne(ctx, live(bson.M{"id": id})).Decode(&b)
 	if err == mongo.ErrNoDocuments {
-		return models.Book{}, errors.New("book not found")
+		return models.Book{}, apperr.NotFound("book not found")
 	}
 	return b, err
 }
 
-func (r *BookRepo) GetAll() []models.Book {
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+func (r *BookRepo) GetAll(ctx context.Context) []models.Book {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	cur, err := r.col.Find(ctx, bson.M{})
+	cur, err := r.col.Find(ctx, live(bson.M{}))
 	if err != nil {
 		return []models.Book{}
 	}
@@ -84,36 +97,29 @@ func (r *BookRepo) GetAll() []models.Book {
 	return out
 }
 
-func (r *BookRepo) Update(book models.Book) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *BookRepo) Update(ctx context.Context, book models.Book) (models.Book, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	res, err := r.col.UpdateOne(ctx, bson.M{"id": book.ID}, bson.M{"$set": book})
+	var out models.Book
+	err := updateVersioned(ctx, r.col, live(bson.M{"id": book.ID}), book.Version, bson.M{
+		"title":       book.Title,
+		"author":      book.Author,
+		"genre":       book.Genre,
+		"price":       book.Price,
+		"description": book.Description,
+	}, &out, "book")
 	if err != nil {
-		return err
-	}
-	if res.MatchedCount == 0 {
-		return errors.New("book not found")
+		return models.Book{}, err
 	}
-	return nil
+	return out, nil
 }
 
-func (r *BookRepo) Delete(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *BookRepo) Find(ctx context.Context, q models.BookQuery) ([]models.Book, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	res, err := r.col.DeleteOne(ctx, bson.M{"id": id})
-	if err != nil {
-		return err
-	}
-	if res.DeletedCount == 0 {
-		return errors.New("book not found")
-	}
-	return nil
-}
-
-func (r *BookRepo) Find(ctx context.Context, q models.BookQuery) ([]models.Book, error) {
-	filter := bson.M{}
+	filter := live(bson.M{})
 
 	if q.Genre != "" {
 		filter["genre"] = q.Genre
@@ -168,3 +174,62 @@ func (r *BookRepo) Find(ctx context.Context, q models.BookQuery) ([]models.Book,
 	}
 	return out, nil
 }
+
+func (r *BookRepo) FindByIDs(ctx context.Context, ids []int) ([]models.Book, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.col.Find(ctx, bson.M{"id": bson.M{"$in": ids}}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Book{}
+	for cur.Next(ctx) {
+		var b models.Book
+		if cur.Decode(&b) == nil {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func (r *BookRepo) Delete(ctx context.Context, id, deletedBy int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ok, err := softDelete(ctx, r.col, id, deletedBy)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apperr.NotFound("book not found")
+	}
+	return nil
+}
+
+func (r *BookRepo) Restore(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ok, err := restore(ctx, r.col, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apperr.NotFound("deleted book not found")
+	}
+	return nil
+}
+
+func (r *BookRepo) Purge(ctx context.Context, before time.Time, keep []int) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ids, err := deletedBefore(ctx, r.col, before, keep)
+	if err != nil {
+		return 0, err
+	}
+	return purge(ctx, NoTransactions{}, r.col, nil, "", ids)
+}
@@ -1,183 +1,252 @@
 package repository
 
 import (
-	"errors"
-	"sync"
+	"context"
+	"time"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type CartRepository interface {
-	Create(customerID int) models.Cart
-	GetAll() []models.Cart
-	GetByID(id int) (models.Cart, []models.CartItem, error)
-	Update(cart models.Cart) error
-	Delete(id int) error
-
-	AddItem(cartID int, bookID int, qty int) (models.CartItem, error)
-	UpdateItem(cartID int, itemID int, qty int) error
-	DeleteItem(cartID int, itemID int) error
-
-	ClearCart(cartID int) error
+	Create(ctx context.Context, customerID int) (models.Cart, error)
+	GetAll(ctx context.Context) []models.Cart
+	GetByID(ctx context.Context, id int) (models.Cart, []models.CartItem, error)
+	// Update stores the cart's customer and creation time and returns the
+	// cart with its new version. A non-zero cart.Version must match the
+	// stored one.
+	Update(ctx context.Context, cart models.Cart) (models.Cart, error)
+	Delete(ctx context.Context, id int) error
+
+	AddItem(ctx context.Context, cartID int, bookID int, qty int) (models.CartItem, error)
+	UpdateItem(ctx context.Context, cartID int, itemID int, qty int) error
+	DeleteItem(ctx context.Context, cartID int, itemID int) error
+
+	ClearCart(ctx context.Context, cartID int) error
 }
 
 type CartRepo struct {
-	mu sync.RWMutex
-
-	nextCartID int
-	nextItemID int
-
-	carts map[int]models.Cart
-	items map[int][]models.CartItem
+	cartsCol *mongo.Collection
+	itemsCol *mongo.Collection
+	ids      IDGenerator
+	itemIDs  IDGenerator
 }
 
-func NewCartRepo() *CartRepo {
+func NewCartRepo(db *mongo.Database, ids *IDs) *CartRepo {
 	return &CartRepo{
-		nextCartID: 1,
-		nextItemID: 1,
-		carts:      make(map[int]models.Cart),
-		items:      make(map[int][]models.CartItem),
+		cartsCol: db.Collection("carts"),
+		itemsCol: db.Collection("cart_items"),
+		ids:      ids.For("carts"),
+		itemIDs:  ids.For("cart_items"),
 	}
 }
 
-func (r *CartRepo) Create(customerID int) models.Cart {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *CartRepo) Create(ctx context.Context, customerID int) (models.Cart, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if customerID <= 0 {
+		return models.Cart{}, apperr.Invalid("customerId", "customerId must be positive")
+	}
+
+	id, err := r.ids.Next(ctx)
+	if err != nil {
+		return models.Cart{}, err
+	}
 
 	c := models.Cart{
-		ID:         r.nextCartID,
+		ID:         id,
 		CustomerID: customerID,
+		CreatedAt:  time.Now(),
+		Version:    1,
 	}
-	r.nextCartID++
-	r.carts[c.ID] = c
-	r.items[c.ID] = []models.CartItem{}
-	return c
+	if _, err := r.cartsCol.InsertOne(ctx, c); err != nil {
+		return models.Cart{}, err
+	}
+	return c, nil
 }
 
-func (r *CartRepo) GetAll() []models.Cart {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+func (r *CartRepo) GetAll(ctx context.Context) []models.Cart {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	out := make([]models.Cart, 0, len(r.carts))
-	for _, c := range r.carts {
-		out = append(out, c)
+	cur, err := r.cartsCol.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return []models.Cart{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Cart{}
+	for cur.Next(ctx) {
+		var c models.Cart
+		if cur.Decode(&c) == nil {
+			out = append(out, c)
+		}
 	}
 	return out
 }
 
-func (r *CartRepo) GetByID(id int) (models.Cart, []models.CartItem, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+func (r *CartRepo) GetByID(ctx context.Context, id int) (models.Cart, []models.CartItem, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var c models.Cart
+	err := r.cartsCol.FindOne(ctx, bson.M{"id": id}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return models.Cart{}, nil, apperr.NotFound("cart not found")
+	}
+	if err != nil {
+		return models.Cart{}, nil, err
+	}
+
+	cur, err := r.itemsCol.Find(ctx, bson.M{"cartId": id}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return models.Cart{}, nil, err
+	}
+	defer cur.Close(ctx)
 
-	c, ok := r.carts[id]
-	if !ok {
-		return models.Cart{}, nil, errors.New("cart not found")
+	items := []models.CartItem{}
+	for cur.Next(ctx) {
+		var it models.CartItem
+		if cur.Decode(&it) == nil {
+			items = append(items, it)
+		}
 	}
-	items := append([]models.CartItem(nil), r.items[id]...)
 	return c, items, nil
 }
 
-func (r *CartRepo) Update(cart models.Cart) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if _, ok := r.carts[cart.ID]; !ok {
-		return errors.New("cart not found")
+func (r *CartRepo) Update(ctx context.Context, cart models.Cart) (models.Cart, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var out models.Cart
+	err := updateVersioned(ctx, r.cartsCol, bson.M{"id": cart.ID}, cart.Version, bson.M{
+		"customerId": cart.CustomerID,
+		"createdAt":  cart.CreatedAt,
+	}, &out, "cart")
+	if err != nil {
+		return models.Cart{}, err
 	}
-	r.carts[cart.ID] = cart
-	return nil
+	return out, nil
 }
 
-func (r *CartRepo) Delete(id int) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *CartRepo) Delete(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	if _, ok := r.carts[id]; !ok {
-		return errors.New("cart not found")
+	res, err := r.cartsCol.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return err
 	}
-	delete(r.carts, id)
-	delete(r.items, id)
+	if res.DeletedCount == 0 {
+		return apperr.NotFound("cart not found")
+	}
+
+	_, _ = r.itemsCol.DeleteMany(ctx, bson.M{"cartId": id})
 	return nil
 }
 
-func (r *CartRepo) AddItem(cartID int, bookID int, qty int) (models.CartItem, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *CartRepo) AddItem(ctx context.Context, cartID int, bookID int, qty int) (models.CartItem, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	if _, ok := r.carts[cartID]; !ok {
-		return models.CartItem{}, errors.New("cart not found")
+	if err := r.exists(ctx, cartID); err != nil {
+		return models.CartItem{}, err
 	}
 	if qty <= 0 {
-		return models.CartItem{}, errors.New("qty must be positive")
+		return models.CartItem{}, apperr.Invalid("qty", "qty must be positive")
 	}
 
-	items := r.items[cartID]
-	for i := range items {
-		if items[i].BookID == bookID {
-			items[i].Qty += qty
-			r.items[cartID] = items
-			return items[i], nil
-		}
+	// Adding a book that is already in the cart bumps its line instead of
+	// creating a second one.
+	var it models.CartItem
+	err := r.itemsCol.FindOneAndUpdate(
+		ctx,
+		bson.M{"cartId": cartID, "bookId": bookID},
+		bson.M{"$inc": bson.M{"qty": qty}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&it)
+	if err == nil {
+		return it, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return models.CartItem{}, err
 	}
 
-	it := models.CartItem{
-		ID:     r.nextItemID,
+	id, err := r.itemIDs.Next(ctx)
+	if err != nil {
+		return models.CartItem{}, err
+	}
+	it = models.CartItem{
+		ID:     id,
 		CartID: cartID,
 		BookID: bookID,
 		Qty:    qty,
 	}
-	r.nextItemID++
-	r.items[cartID] = append(r.items[cartID], it)
+	if _, err := r.itemsCol.InsertOne(ctx, it); err != nil {
+		return models.CartItem{}, err
+	}
 	return it, nil
 }
 
-func (r *CartRepo) UpdateItem(cartID int, itemID int, qty int) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *CartRepo) UpdateItem(ctx context.Context, cartID int, itemID int, qty int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
 	if qty <= 0 {
-		return errors.New("qty must be positive")
+		return apperr.Invalid("qty", "qty must be positive")
 	}
 
-	items := r.items[cartID]
-	for i := range items {
-		if items[i].ID == itemID {
-			items[i].Qty = qty
-			r.items[cartID] = items
-			return nil
-		}
+	res, err := r.itemsCol.UpdateOne(
+		ctx,
+		bson.M{"id": itemID, "cartId": cartID},
+		bson.M{"$set": bson.M{"qty": qty}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("item not found")
 	}
-	return errors.New("item not found")
+	return nil
 }
 
-func (r *CartRepo) DeleteItem(cartID int, itemID int) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	items := r.items[cartID]
-	out := make([]models.CartItem, 0, len(items))
-	found := false
-	for _, it := range items {
-		if it.ID == itemID {
-			found = true
-			continue
-		}
-		out = append(out, it)
+func (r *CartRepo) DeleteItem(ctx context.Context, cartID int, itemID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.itemsCol.DeleteOne(ctx, bson.M{"id": itemID, "cartId": cartID})
+	if err != nil {
+		return err
 	}
-	if !found {
-		return errors.New("item not found")
+	if res.DeletedCount == 0 {
+		return apperr.NotFound("item not found")
 	}
-	r.items[cartID] = out
 	return nil
 }
 
-func (r *CartRepo) ClearCart(cartID int) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *CartRepo) ClearCart(ctx context.Context, cartID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
-	if _, ok := r.carts[cartID]; !ok {
-		return errors.New("cart not found")
+	if err := r.exists(ctx, cartID); err != nil {
+		return err
+	}
+	_, err := r.itemsCol.DeleteMany(ctx, bson.M{"cartId": cartID})
+	return err
+}
+
+func (r *CartRepo) exists(ctx context.Context, cartID int) error {
+	n, err := r.cartsCol.CountDocuments(ctx, bson.M{"id": cartID}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return apperr.NotFound("cart not found")
 	}
-	r.items[cartID] = []models.CartItem{}
 	return nil
 }
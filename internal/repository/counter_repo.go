@@ -2,7 +2,7 @@ package repository
 
 import (
 	"context"
-	"time"
+	"errors"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -17,8 +17,8 @@ func NewCounterRepo(db *mongo.Database) *CounterRepo {
 	return &CounterRepo{col: db.Collection("counters")}
 }
 
-func (r *CounterRepo) Next(name string) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *CounterRepo) Next(ctx context.Context, name string) (int, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	opts := options.FindOneAndUpdate().
@@ -38,3 +38,32 @@ func (r *CounterRepo) Next(name string) (int, error) {
 	err := res.Decode(&out)
 	return out.Seq, err
 }
+
+// NextN reserves n consecutive values and returns the first one.
+func (r *CounterRepo) NextN(ctx context.Context, name string, n int) (int, error) {
+	if n <= 0 {
+		return 0, errors.New("n must be positive")
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	res := r.col.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": n}},
+		opts,
+	)
+
+	var out struct {
+		Seq int `bson:"seq"`
+	}
+	if err := res.Decode(&out); err != nil {
+		return 0, err
+	}
+	return out.Seq - n + 1, nil
+}
@@ -1 +1,65 @@
 package repository
+
+import "go.mongodb.org/mongo-driver/mongo"
+
+// Stores holds one implementation of every repository so the server can be
+// wired against Mongo or run entirely in memory.
+type Stores struct {
+	Books         BookRepository
+	Users         UserRepository
+	Carts         CartRepository
+	Wishlists     WishlistRepository
+	Orders        OrderRepository
+	RefreshTokens RefreshTokenRepository
+	Revocations   RevocationRepository
+	APIKeys       APIKeyRepository
+	Addresses     AddressRepository
+	Inventory     InventoryRepository
+	Payments      PaymentRepository
+	Refunds       RefundRepository
+	Idempotency   IdempotencyRepository
+	Transactions  Transactions
+}
+
+// NewMongoStores wires the Mongo repositories; ids decides how each entity
+// gets its ids.
+func NewMongoStores(db *mongo.Database, ids *IDs) Stores {
+	return Stores{
+		Books:         NewBookRepo(db, ids),
+		Users:         NewUserRepo(db, ids),
+		Carts:         NewCartRepo(db, ids),
+		Wishlists:     NewWishlistRepo(db, ids),
+		Orders:        NewOrderRepo(db, ids),
+		RefreshTokens: NewRefreshTokenRepo(db),
+		Revocations:   NewRevocationRepo(db),
+		APIKeys:       NewAPIKeyRepo(db, ids),
+		Addresses:     NewAddressRepo(db, ids),
+		Inventory:     NewInventoryRepo(db, ids),
+		Payments:      NewPaymentRepo(db, ids),
+		Refunds:       NewRefundRepo(db, ids),
+		Idempotency:   NewIdempotencyRepo(db),
+		Transactions:  NewMongoTransactions(db),
+	}
+}
+
+// NewMemoryStores keeps everything in process memory. Nothing survives a
+// restart; it exists for laptops, CI and demos without a database.
+func NewMemoryStores() Stores {
+	books := NewMemoryBookRepo()
+	return Stores{
+		Books:         books,
+		Users:         NewMemoryUserRepo(),
+		Carts:         NewMemoryCartRepo(),
+		Wishlists:     NewMemoryWishlistRepo(),
+		Orders:        NewMemoryOrderRepo(),
+		RefreshTokens: NewMemoryRefreshTokenRepo(),
+		Revocations:   NewMemoryRevocationRepo(),
+		APIKeys:       NewMemoryAPIKeyRepo(),
+		Addresses:     NewMemoryAddressRepo(),
+		Inventory:     NewMemoryInventoryRepo(books),
+		Payments:      NewMemoryPaymentRepo(),
+		Refunds:       NewMemoryRefundRepo(),
+		Idempotency:   NewMemoryIdempotencyRepo(),
+		Transactions:  NoTransactions{},
+	}
+}
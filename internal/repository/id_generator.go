@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IDGenerator hands out integer ids for one entity type.
+type IDGenerator interface {
+	Next(ctx context.Context) (int, error)
+	NextN(ctx context.Context, n int) ([]int, error)
+}
+
+type IDStrategy string
+
+const (
+	// IDCounter takes one value from the entity's counter document per id.
+	IDCounter IDStrategy = "counter"
+	// IDBatch reserves ids from the same counter in blocks, so most ids cost
+	// no round trip. Ids stay unique across processes but are no longer
+	// handed out in creation order, and a restart skips the rest of a block.
+	IDBatch IDStrategy = "batch"
+	// IDSnowflake builds ids from the clock, a node number and a sequence,
+	// with no database access. They are not sequential, so they don't leak
+	// how many orders or users exist. Values stay below 2^53 so JavaScript
+	// clients can hold them exactly.
+	IDSnowflake IDStrategy = "snowflake"
+)
+
+const defaultIDBatchSize = 100
+
+// IDs picks a generator per entity. Entities are named after their
+// collection ("orders", "order_items", ...). Counter-based and snowflake ids
+// never overlap in practice, since snowflake values start far above any
+// counter, so an entity can be switched to snowflake ids on a live database.
+type IDs struct {
+	counters   *CounterRepo
+	strategies map[string]IDStrategy
+	batchSize  int
+	snowflake  *snowflakeIDs
+
+	mu   sync.Mutex
+	gens map[string]IDGenerator
+}
+
+// NewIDs builds the id policy. Entities missing from strategies use the
+// "*" entry when there is one and IDCounter otherwise. node tells processes
+// apart for snowflake ids and must be unique among running servers.
+func NewIDs(db *mongo.Database, strategies map[string]IDStrategy, node int) (*IDs, error) {
+	if node < 0 || node > snowflakeMaxNode {
+		return nil, fmt.Errorf("id node must be between 0 and %d", snowflakeMaxNode)
+	}
+	for entity, st := range strategies {
+		switch st {
+		case IDCounter, IDBatch, IDSnowflake:
+		default:
+			return nil, fmt.Errorf("unknown id strategy %q for %s", st, entity)
+		}
+	}
+	return &IDs{
+		counters:   NewCounterRepo(db),
+		strategies: strategies,
+		batchSize:  defaultIDBatchSize,
+		snowflake:  newSnowflakeIDs(node),
+		gens:       make(map[string]IDGenerator),
+	}, nil
+}
+
+// CounterIDs is the policy the app always had: one counter per entity.
+func CounterIDs(db *mongo.Database) *IDs {
+	ids, _ := NewIDs(db, nil, 0)
+	return ids
+}
+
+// ParseIDStrategies reads a spec like "orders=snowflake,users=snowflake,*=batch".
+func ParseIDStrategies(spec string) (map[string]IDStrategy, error) {
+	out := map[string]IDStrategy{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		entity, st, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(entity) == "" {
+			return nil, fmt.Errorf("invalid id strategy %q, want entity=strategy", part)
+		}
+		out[strings.TrimSpace(entity)] = IDStrategy(strings.ToLower(strings.TrimSpace(st)))
+	}
+	return out, nil
+}
+
+func (s *IDs) For(entity string) IDGenerator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g, ok := s.gens[entity]; ok {
+		return g
+	}
+
+	st, ok := s.strategies[entity]
+	if !ok {
+		st = s.strategies["*"]
+	}
+
+	var g IDGenerator
+	switch st {
+	case IDBatch:
+		g = &batchIDs{counters: s.counters, name: entity, size: s.batchSize}
+	case IDSnowflake:
+		g = s.snowflake
+	default:
+		g = counterIDs{counters: s.counters, name: entity}
+	}
+	s.gens[entity] = g
+	return g
+}
+
+type counterIDs struct {
+	counters *CounterRepo
+	name     string
+}
+
+func (g counterIDs) Next(ctx context.Context) (int, error) {
+	return g.counters.Next(ctx, g.name)
+}
+
+func (g counterIDs) NextN(ctx context.Context, n int) ([]int, error) {
+	first, err := g.counters.NextN(ctx, g.name, n)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]int, n)
+	for i := range out {
+		out[i] = first + i
+	}
+	return out, nil
+}
+
+type batchIDs struct {
+	counters *CounterRepo
+	name     string
+	size     int
+
+	mu   sync.Mutex
+	next int
+	end  int // exclusive
+}
+
+func (g *batchIDs) Next(ctx context.Context) (int, error) {
+	ids, err := g.NextN(ctx, 1)
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (g *batchIDs) NextN(ctx context.Context, n int) ([]int, error) {
+	if n <= 0 {
+		return nil, errors.New("n must be positive")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	out := make([]int, 0, n)
+	for len(out) < n {
+		if g.next == g.end {
+			size := max(g.size, n-len(out))
+			first, err := g.counters.NextN(ctx, g.name, size)
+			if err != nil {
+				return nil, err
+			}
+			g.next, g.end = first, first+size
+		}
+		out = append(out, g.next)
+		g.next++
+	}
+	return out, nil
+}
+
+// Snowflake layout, 53 bits in total: 41 bits of milliseconds since
+// snowflakeEpoch (good for about 69 years), 4 bits of node, 8 bits of
+// sequence. The sequence starts at a random point each millisecond so
+// neighbouring ids are not simply one apart.
+const (
+	snowflakeNodeBits = 4
+	snowflakeSeqBits  = 8
+	snowflakeMaxNode  = 1<<snowflakeNodeBits - 1
+	snowflakeSeqMask  = 1<<snowflakeSeqBits - 1
+)
+
+var snowflakeEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type snowflakeIDs struct {
+	node int
+
+	mu       sync.Mutex
+	lastMs   int64
+	seqStart int
+	seq      int
+}
+
+func newSnowflakeIDs(node int) *snowflakeIDs {
+	return &snowflakeIDs{node: node, lastMs: -1}
+}
+
+func (g *snowflakeIDs) Next(ctx context.Context) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for {
+		ms := time.Since(snowflakeEpoch).Milliseconds()
+		if ms < g.lastMs {
+			// The clock stepped back; keep counting in the last millisecond
+			// we used rather than risk repeating an id.
+			ms = g.lastMs
+		}
+
+		if ms != g.lastMs {
+			g.lastMs = ms
+			g.seqStart = rand.IntN(snowflakeSeqMask + 1)
+			g.seq = g.seqStart
+		} else {
+			next := (g.seq + 1) & snowflakeSeqMask
+			if next == g.seqStart {
+				// This millisecond is used up.
+				if err := ctx.Err(); err != nil {
+					return 0, err
+				}
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			g.seq = next
+		}
+
+		id := ms<<(snowflakeNodeBits+snowflakeSeqBits) | int64(g.node)<<snowflakeSeqBits | int64(g.seq)
+		return int(id), nil
+	}
+}
+
+func (g *snowflakeIDs) NextN(ctx context.Context, n int) ([]int, error) {
+	if n <= 0 {
+		return nil, errors.New("n must be positive")
+	}
+	out := make([]int, n)
+	for i := range out {
+		id, err := g.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = id
+	}
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type IdempotencyRepository interface {
+	// Claim stores rec unless an unexpired record already holds its key, in
+	// which case it returns that record and false. Records count as expired
+	// once rec.CreatedAt is past their ExpiresAt.
+	Claim(ctx context.Context, rec models.IdempotencyRecord) (models.IdempotencyRecord, bool, error)
+	// Complete saves the response and new expiry carried by rec on the claim
+	// rec came from, matched by key and CreatedAt. Once that claim has
+	// expired and the key been claimed again, it is not found: a request
+	// that outran its lease must not answer for the retry's.
+	Complete(ctx context.Context, rec models.IdempotencyRecord) error
+	// Release drops the claim rec came from, if its request has not
+	// completed, so the key can be used again. Completed records and later
+	// claims of the key are left alone.
+	Release(ctx context.Context, rec models.IdempotencyRecord) error
+}
+
+type IdempotencyRepo struct {
+	col *mongo.Collection
+}
+
+func NewIdempotencyRepo(db *mongo.Database) *IdempotencyRepo {
+	return &IdempotencyRepo{col: db.Collection("idempotency_keys")}
+}
+
+func (r *IdempotencyRepo) Claim(ctx context.Context, rec models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if err := validateIdempotencyRecord(rec); err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+	// Complete and Release find the claim by CreatedAt, so it has to match
+	// what Mongo stores.
+	rec.CreatedAt = rec.CreatedAt.Truncate(time.Millisecond)
+
+	// The TTL monitor only runs about once a minute, so an expired record
+	// may still be in the way; remove it and try once more.
+	for range 2 {
+		_, err := r.col.InsertOne(ctx, rec)
+		if err == nil {
+			return rec, true, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return models.IdempotencyRecord{}, false, err
+		}
+
+		var cur models.IdempotencyRecord
+		err = r.col.FindOne(ctx, bson.M{"_id": rec.Key}).Decode(&cur)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return models.IdempotencyRecord{}, false, err
+		}
+		if cur.ExpiresAt.After(rec.CreatedAt) {
+			return cur, false, nil
+		}
+		if _, err := r.col.DeleteOne(ctx, bson.M{"_id": rec.Key, "expiresAt": cur.ExpiresAt}); err != nil {
+			return models.IdempotencyRecord{}, false, err
+		}
+	}
+	return models.IdempotencyRecord{}, false, apperr.Conflict("idempotency key is in use")
+}
+
+func validateIdempotencyRecord(rec models.IdempotencyRecord) error {
+	if rec.Key == "" {
+		return apperr.Invalid("key", "idempotency key required")
+	}
+	if rec.ExpiresAt.IsZero() {
+		return apperr.Invalid("expiresAt", "expiry required")
+	}
+	return nil
+}
+
+func (r *IdempotencyRepo) Complete(ctx context.Context, rec models.IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(ctx, bson.M{"_id": rec.Key, "createdAt": rec.CreatedAt}, bson.M{"$set": bson.M{
+		"status":      rec.Status,
+		"contentType": rec.ContentType,
+		"location":    rec.Location,
+		"body":        rec.Body,
+		"expiresAt":   rec.ExpiresAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("idempotency key not found")
+	}
+	return nil
+}
+
+func (r *IdempotencyRepo) Release(ctx context.Context, rec models.IdempotencyRecord) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.col.DeleteOne(ctx, bson.M{"_id": rec.Key, "createdAt": rec.CreatedAt, "status": 0})
+	return err
+}
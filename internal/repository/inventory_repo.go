@@ -0,0 +1,403 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// InventoryRepository keeps the stock counters on books, the holds carts
+// place on them and the ledger of stock movements. Books whose Stock is nil
+// are not tracked: every operation here succeeds for them without changing
+// anything.
+//
+// A book's available quantity is Stock minus Reserved, where Reserved is
+// the sum of the holds on it. A cart's own holds count as available to that
+// cart.
+type InventoryRepository interface {
+	// Reserve sets cartID's hold on bookID to qty until expiresAt. qty 0
+	// drops the hold. It fails with apperr.OutOfStock when the book does
+	// not have enough available stock for the increase.
+	Reserve(ctx context.Context, cartID, bookID, qty int, expiresAt time.Time) error
+	ReleaseCart(ctx context.Context, cartID int) error
+	// ReleaseExpired drops the holds that expired before now.
+	ReleaseExpired(ctx context.Context, now time.Time) (int, error)
+
+	// Take removes every item's quantity from stock, using up cartID's holds
+	// on those books first. Either all items are taken or none are. The
+	// returned movements describe what was taken from tracked books and are
+	// not recorded yet.
+	Take(ctx context.Context, cartID int, items []models.OrderItem) ([]models.StockMovement, error)
+	// Restock puts the items' quantities back on tracked books.
+	Restock(ctx context.Context, items []models.OrderItem) ([]models.StockMovement, error)
+	// Adjust changes a book's stock by delta and starts tracking it if it
+	// was not tracked. Stock cannot go below zero.
+	Adjust(ctx context.Context, bookID, delta int) (models.StockMovement, error)
+
+	// Record appends movements to the ledger and returns them with their ids
+	// and times filled in.
+	Record(ctx context.Context, movements ...models.StockMovement) ([]models.StockMovement, error)
+	// Movements lists a book's ledger, newest first. bookID 0 lists every
+	// book's.
+	Movements(ctx context.Context, bookID, limit int) []models.StockMovement
+}
+
+type InventoryRepo struct {
+	booksCol     *mongo.Collection
+	holdsCol     *mongo.Collection
+	movementsCol *mongo.Collection
+	movementIDs  IDGenerator
+}
+
+func NewInventoryRepo(db *mongo.Database, ids *IDs) *InventoryRepo {
+	return &InventoryRepo{
+		booksCol:     db.Collection("books"),
+		holdsCol:     db.Collection("stock_reservations"),
+		movementsCol: db.Collection("stock_movements"),
+		movementIDs:  ids.For("stock_movements"),
+	}
+}
+
+// tracked matches a book by id when its stock is tracked.
+func tracked(bookID int) bson.M {
+	return bson.M{"id": bookID, "stock": bson.M{"$type": "number"}}
+}
+
+// availableAtLeast matches books whose stock minus reservations, not
+// counting own units reserved by the caller, covers qty.
+func availableAtLeast(qty, own int) bson.M {
+	reservedByOthers := bson.M{"$subtract": bson.A{bson.M{"$ifNull": bson.A{"$reserved", 0}}, own}}
+	return bson.M{"$gte": bson.A{bson.M{"$subtract": bson.A{"$stock", reservedByOthers}}, qty}}
+}
+
+// shortage explains why bookID could not cover a request: it is missing,
+// untracked (nil error, nothing to do) or out of stock.
+func (r *InventoryRepo) shortage(ctx context.Context, bookID int) error {
+	var b models.Book
+	err := r.booksCol.FindOne(ctx, bson.M{"id": bookID}).Decode(&b)
+	if err == mongo.ErrNoDocuments {
+		return apperr.NotFound("book not found")
+	}
+	if err != nil {
+		return err
+	}
+	if b.Stock == nil {
+		return nil
+	}
+	return outOfStock(b)
+}
+
+func outOfStock(b models.Book) error {
+	left := max(*b.Stock-b.Reserved, 0)
+	return apperr.OutOfStock(fmt.Sprintf("only %d left of %q", left, b.Title))
+}
+
+func (r *InventoryRepo) Reserve(ctx context.Context, cartID, bookID, qty int, expiresAt time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if qty < 0 {
+		return apperr.Invalid("qty", "qty cannot be negative")
+	}
+
+	// Swap the hold first so its previous size is read and replaced in one
+	// step; the book counter follows and the hold is put back if the book
+	// cannot cover the difference.
+	key := bson.M{"cartId": cartID, "bookId": bookID}
+	var old models.StockReservation
+	var err error
+	if qty == 0 {
+		err = r.holdsCol.FindOneAndDelete(ctx, key).Decode(&old)
+	} else {
+		err = r.holdsCol.FindOneAndUpdate(ctx, key,
+			bson.M{"$set": bson.M{"qty": qty, "expiresAt": expiresAt}},
+			options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before),
+		).Decode(&old)
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	hadHold := err == nil
+
+	delta := qty - old.Qty
+	if delta == 0 {
+		return nil
+	}
+
+	filter := tracked(bookID)
+	if delta > 0 {
+		filter["$expr"] = availableAtLeast(old.Qty+delta, old.Qty)
+	}
+	res, err := r.booksCol.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"reserved": delta}})
+	if err == nil && res.MatchedCount == 1 {
+		return nil
+	}
+	if err == nil {
+		err = r.shortage(ctx, bookID)
+	}
+
+	// Untracked books keep no holds; otherwise the old hold comes back.
+	if hadHold && err != nil {
+		_, _ = r.holdsCol.ReplaceOne(context.WithoutCancel(ctx), key, old, options.Replace().SetUpsert(true))
+	} else {
+		_, _ = r.holdsCol.DeleteOne(context.WithoutCancel(ctx), key)
+	}
+	return err
+}
+
+func (r *InventoryRepo) ReleaseCart(ctx context.Context, cartID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.release(ctx, bson.M{"cartId": cartID})
+	return err
+}
+
+func (r *InventoryRepo) ReleaseExpired(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	return r.release(ctx, bson.M{"expiresAt": bson.M{"$lt": now}})
+}
+
+// release drops the holds matching filter one by one, so a hold refreshed
+// in the meantime no longer matches and is left alone.
+func (r *InventoryRepo) release(ctx context.Context, filter bson.M) (int, error) {
+	cur, err := r.holdsCol.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var holds []models.StockReservation
+	if err := cur.All(ctx, &holds); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, h := range holds {
+		match := bson.M{"cartId": h.CartID, "bookId": h.BookID}
+		for k, v := range filter {
+			match[k] = v
+		}
+		var gone models.StockReservation
+		err := r.holdsCol.FindOneAndDelete(ctx, match).Decode(&gone)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := r.booksCol.UpdateOne(ctx, tracked(gone.BookID), bson.M{"$inc": bson.M{"reserved": -gone.Qty}}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+type takenStock struct {
+	hold  *models.StockReservation
+	item  models.OrderItem
+	moved *models.StockMovement
+}
+
+func (r *InventoryRepo) Take(ctx context.Context, cartID int, items []models.OrderItem) ([]models.StockMovement, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var done []takenStock
+	for _, it := range items {
+		t, err := r.takeOne(ctx, cartID, it)
+		if err != nil {
+			r.undoTake(ctx, done)
+			return nil, err
+		}
+		done = append(done, t)
+	}
+
+	out := []models.StockMovement{}
+	for _, t := range done {
+		if t.moved != nil {
+			out = append(out, *t.moved)
+		}
+	}
+	return out, nil
+}
+
+func (r *InventoryRepo) takeOne(ctx context.Context, cartID int, it models.OrderItem) (takenStock, error) {
+	t := takenStock{item: it}
+	own := 0
+	if cartID > 0 {
+		var h models.StockReservation
+		err := r.holdsCol.FindOneAndDelete(ctx, bson.M{"cartId": cartID, "bookId": it.BookID}).Decode(&h)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return t, err
+		}
+		if err == nil {
+			t.hold = &h
+			own = h.Qty
+		}
+	}
+
+	filter := tracked(it.BookID)
+	filter["$expr"] = availableAtLeast(it.Qty, own)
+
+	var b models.Book
+	err := r.booksCol.FindOneAndUpdate(ctx, filter,
+		bson.M{"$inc": bson.M{"stock": -it.Qty, "reserved": -own}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&b)
+	if err == nil {
+		t.moved = &models.StockMovement{BookID: it.BookID, Delta: -it.Qty, StockAfter: *b.Stock, Reason: models.MovementOrder}
+		return t, nil
+	}
+	if err == mongo.ErrNoDocuments {
+		err = r.shortage(ctx, it.BookID)
+	}
+	if err != nil {
+		r.undoTake(ctx, []takenStock{t})
+	}
+	return t, err
+}
+
+// undoTake reverses takeOne for each entry, holds included. It runs even
+// when ctx is what made the take fail.
+func (r *InventoryRepo) undoTake(ctx context.Context, done []takenStock) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+
+	for _, t := range done {
+		own := 0
+		if t.hold != nil {
+			own = t.hold.Qty
+		}
+		if t.moved != nil {
+			_, err := r.booksCol.UpdateOne(ctx, tracked(t.item.BookID), bson.M{"$inc": bson.M{"stock": t.item.Qty, "reserved": own}})
+			if err != nil {
+				log.Printf("[INVENTORY] failed to put back stock: bookId=%d qty=%d err=%v\n", t.item.BookID, t.item.Qty, err)
+				continue
+			}
+		}
+		if t.hold != nil {
+			_, err := r.holdsCol.InsertOne(ctx, t.hold)
+			if err != nil {
+				log.Printf("[INVENTORY] failed to restore hold: cartId=%d bookId=%d err=%v\n", t.hold.CartID, t.hold.BookID, err)
+			}
+		}
+	}
+}
+
+func (r *InventoryRepo) Restock(ctx context.Context, items []models.OrderItem) ([]models.StockMovement, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	out := []models.StockMovement{}
+	for _, it := range items {
+		var b models.Book
+		err := r.booksCol.FindOneAndUpdate(ctx, tracked(it.BookID),
+			bson.M{"$inc": bson.M{"stock": it.Qty}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&b)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, models.StockMovement{BookID: it.BookID, Delta: it.Qty, StockAfter: *b.Stock})
+	}
+	return out, nil
+}
+
+func (r *InventoryRepo) Adjust(ctx context.Context, bookID, delta int) (models.StockMovement, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var b models.Book
+	err := r.booksCol.FindOneAndUpdate(ctx,
+		bson.M{
+			"id":    bookID,
+			"$expr": bson.M{"$gte": bson.A{bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$stock", 0}}, delta}}, 0}},
+		},
+		bson.M{"$inc": bson.M{"stock": delta, "reserved": 0}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&b)
+	if err == mongo.ErrNoDocuments {
+		if err := r.booksCol.FindOne(ctx, bson.M{"id": bookID}).Err(); err == mongo.ErrNoDocuments {
+			return models.StockMovement{}, apperr.NotFound("book not found")
+		}
+		return models.StockMovement{}, apperr.Invalid("delta", "stock cannot go below zero")
+	}
+	if err != nil {
+		return models.StockMovement{}, err
+	}
+	return models.StockMovement{BookID: bookID, Delta: delta, StockAfter: *b.Stock, Reason: models.MovementAdjustment}, nil
+}
+
+func (r *InventoryRepo) Record(ctx context.Context, movements ...models.StockMovement) ([]models.StockMovement, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if len(movements) == 0 {
+		return []models.StockMovement{}, nil
+	}
+	ids, err := r.movementIDs.NextN(ctx, len(movements))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]models.StockMovement, 0, len(movements))
+	docs := make([]any, 0, len(movements))
+	for i, m := range movements {
+		m.ID = ids[i]
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = now
+		}
+		out = append(out, m)
+		docs = append(docs, m)
+	}
+	if _, err := r.movementsCol.InsertMany(ctx, docs); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *InventoryRepo) Movements(ctx context.Context, bookID, limit int) []models.StockMovement {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{}
+	if bookID > 0 {
+		filter["bookId"] = bookID
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "id", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cur, err := r.movementsCol.Find(ctx, filter, opts)
+	if err != nil {
+		return []models.StockMovement{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.StockMovement{}
+	for cur.Next(ctx) {
+		var m models.StockMovement
+		if cur.Decode(&m) == nil {
+			out = append(out, m)
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryAddressRepo struct {
+	mu sync.RWMutex
+
+	nextID    int
+	addresses map[int]models.Address
+}
+
+func NewMemoryAddressRepo() *MemoryAddressRepo {
+	return &MemoryAddressRepo{
+		nextID:    1,
+		addresses: make(map[int]models.Address),
+	}
+}
+
+func (r *MemoryAddressRepo) Create(ctx context.Context, a models.Address) (models.Address, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a.UserID <= 0 {
+		return models.Address{}, apperr.Invalid("userId", "userId must be positive")
+	}
+
+	a.ID = r.nextID
+	r.nextID++
+	r.addresses[a.ID] = a
+	return a, nil
+}
+
+func (r *MemoryAddressRepo) GetByID(ctx context.Context, id int) (models.Address, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, ok := r.addresses[id]
+	if !ok {
+		return models.Address{}, apperr.NotFound("address not found")
+	}
+	return a, nil
+}
+
+func (r *MemoryAddressRepo) ListByUser(ctx context.Context, userID int) []models.Address {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []models.Address{}
+	for _, a := range sortedByID(r.addresses, func(a models.Address) int { return a.ID }) {
+		if a.UserID == userID {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func (r *MemoryAddressRepo) Update(ctx context.Context, a models.Address) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a.ID <= 0 {
+		return apperr.Invalid("id", "invalid address id")
+	}
+	cur, ok := r.addresses[a.ID]
+	if !ok || cur.UserID != a.UserID {
+		return apperr.NotFound("address not found")
+	}
+	r.addresses[a.ID] = a
+	return nil
+}
+
+func (r *MemoryAddressRepo) Delete(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.addresses[id]; !ok {
+		return apperr.NotFound("address not found")
+	}
+	delete(r.addresses, id)
+	return nil
+}
+
+func (r *MemoryAddressRepo) SetDefault(ctx context.Context, userID int, kind string, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, ok := r.addresses[id]
+	if !ok || a.UserID != userID || a.Kind != kind {
+		return apperr.NotFound("address not found")
+	}
+	for k, other := range r.addresses {
+		if other.UserID == userID && other.Kind == kind {
+			other.IsDefault = k == id
+			r.addresses[k] = other
+		}
+	}
+	return nil
+}
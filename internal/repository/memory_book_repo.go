@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryBookRepo struct {
+	mu sync.RWMutex
+
+	nextID int
+	books  map[int]models.Book
+}
+
+func NewMemoryBookRepo() *MemoryBookRepo {
+	return &MemoryBookRepo{
+		nextID: 1,
+		books:  make(map[int]models.Book),
+	}
+}
+
+func (r *MemoryBookRepo) Create(ctx context.Context, book models.Book) (models.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	book.ID = r.nextID
+	r.nextID++
+	book.DeletedAt, book.DeletedBy = nil, 0
+	book.Version = 1
+	book.Stock, book.Reserved = clonePtr(book.Stock), 0
+	r.books[book.ID] = book
+	return book, nil
+}
+
+func (r *MemoryBookRepo) GetByID(ctx context.Context, id int) (models.Book, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	b, ok := r.books[id]
+	if !ok || b.DeletedAt != nil {
+		return models.Book{}, apperr.NotFound("book not found")
+	}
+	return b, nil
+}
+
+func (r *MemoryBookRepo) GetAll(ctx context.Context) []models.Book {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.live()
+}
+
+func (r *MemoryBookRepo) live() []models.Book {
+	return slices.DeleteFunc(
+		sortedByID(r.books, func(b models.Book) int { return b.ID }),
+		func(b models.Book) bool { return b.DeletedAt != nil },
+	)
+}
+
+func (r *MemoryBookRepo) Update(ctx context.Context, book models.Book) (models.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur, ok := r.books[book.ID]
+	if !ok || cur.DeletedAt != nil {
+		return models.Book{}, apperr.NotFound("book not found")
+	}
+	if book.Version != 0 && book.Version != cur.Version {
+		return models.Book{}, staleVersion("book")
+	}
+	cur.Title = book.Title
+	cur.Author = book.Author
+	cur.Genre = book.Genre
+	cur.Price = book.Price
+	cur.Description = book.Description
+	cur.Version++
+	r.books[book.ID] = cur
+	return cur, nil
+}
+
+// Find mirrors BookRepo.Find: Search is a case-insensitive regular
+// expression over title and author, and ties in the sort fall back to id.
+func (r *MemoryBookRepo) Find(ctx context.Context, q models.BookQuery) ([]models.Book, error) {
+	var search *regexp.Regexp
+	if q.Search != "" {
+		re, err := regexp.Compile("(?i)" + q.Search)
+		if err != nil {
+			return nil, err
+		}
+		search = re
+	}
+
+	r.mu.RLock()
+	all := r.live()
+	r.mu.RUnlock()
+
+	out := []models.Book{}
+	for _, b := range all {
+		if q.Genre != "" && b.Genre != q.Genre {
+			continue
+		}
+		if search != nil && !search.MatchString(b.Title) && !search.MatchString(b.Author) {
+			continue
+		}
+		if q.MinPrice != nil && b.Price < *q.MinPrice {
+			continue
+		}
+		if q.MaxPrice != nil && b.Price > *q.MaxPrice {
+			continue
+		}
+		out = append(out, b)
+	}
+
+	dir := 1
+	if q.Order == "desc" {
+		dir = -1
+	}
+
+	switch q.SortBy {
+	case "price":
+		slices.SortStableFunc(out, func(a, b models.Book) int { return dir * cmp.Compare(a.Price, b.Price) })
+	case "title":
+		slices.SortStableFunc(out, func(a, b models.Book) int { return dir * cmp.Compare(a.Title, b.Title) })
+	}
+	return out, nil
+}
+
+func (r *MemoryBookRepo) FindByIDs(ctx context.Context, ids []int) ([]models.Book, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []models.Book{}
+	for _, b := range sortedByID(r.books, func(b models.Book) int { return b.ID }) {
+		if slices.Contains(ids, b.ID) {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+func (r *MemoryBookRepo) Delete(ctx context.Context, id, deletedBy int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.books[id]
+	if !ok || b.DeletedAt != nil {
+		return apperr.NotFound("book not found")
+	}
+	now := time.Now()
+	b.DeletedAt, b.DeletedBy = &now, deletedBy
+	r.books[id] = b
+	return nil
+}
+
+func (r *MemoryBookRepo) Restore(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.books[id]
+	if !ok || b.DeletedAt == nil {
+		return apperr.NotFound("deleted book not found")
+	}
+	b.DeletedAt, b.DeletedBy = nil, 0
+	r.books[id] = b
+	return nil
+}
+
+func (r *MemoryBookRepo) Purge(ctx context.Context, before time.Time, keep []int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id, b := range r.books {
+		if b.DeletedAt != nil && b.DeletedAt.Before(before) && !slices.Contains(keep, id) {
+			delete(r.books, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func sortedByID[T any](m map[int]T, id func(T) int) []T {
+	out := make([]T, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	slices.SortFunc(out, func(a, b T) int { return id(a) - id(b) })
+	return out
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryIdempotencyRepo struct {
+	mu sync.Mutex
+
+	records map[string]models.IdempotencyRecord
+}
+
+func NewMemoryIdempotencyRepo() *MemoryIdempotencyRepo {
+	return &MemoryIdempotencyRepo{records: make(map[string]models.IdempotencyRecord)}
+}
+
+func (r *MemoryIdempotencyRepo) Claim(ctx context.Context, rec models.IdempotencyRecord) (models.IdempotencyRecord, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := validateIdempotencyRecord(rec); err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	// Drop expired records here the way the TTL index would.
+	for key, cur := range r.records {
+		if !cur.ExpiresAt.After(rec.CreatedAt) {
+			delete(r.records, key)
+		}
+	}
+
+	if cur, ok := r.records[rec.Key]; ok {
+		cur.Body = slices.Clone(cur.Body)
+		return cur, false, nil
+	}
+	rec.Body = slices.Clone(rec.Body)
+	r.records[rec.Key] = rec
+	return rec, true, nil
+}
+
+func (r *MemoryIdempotencyRepo) Complete(ctx context.Context, rec models.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur, ok := r.records[rec.Key]
+	if !ok || !cur.CreatedAt.Equal(rec.CreatedAt) {
+		return apperr.NotFound("idempotency key not found")
+	}
+	cur.Status = rec.Status
+	cur.ContentType = rec.ContentType
+	cur.Location = rec.Location
+	cur.Body = slices.Clone(rec.Body)
+	cur.ExpiresAt = rec.ExpiresAt
+	r.records[rec.Key] = cur
+	return nil
+}
+
+func (r *MemoryIdempotencyRepo) Release(ctx context.Context, rec models.IdempotencyRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if cur, ok := r.records[rec.Key]; ok && cur.Status == 0 && cur.CreatedAt.Equal(rec.CreatedAt) {
+		delete(r.records, rec.Key)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+// MemoryInventoryRepo works on the stock counters of a MemoryBookRepo and
+// locks that repo for every change, so each call is atomic.
+type MemoryInventoryRepo struct {
+	books *MemoryBookRepo
+
+	holds map[[2]int]models.StockReservation // by cart id, book id
+
+	movementsMu    sync.RWMutex
+	nextMovementID int
+	movements      []models.StockMovement
+}
+
+func NewMemoryInventoryRepo(books *MemoryBookRepo) *MemoryInventoryRepo {
+	return &MemoryInventoryRepo{
+		books:          books,
+		holds:          make(map[[2]int]models.StockReservation),
+		nextMovementID: 1,
+	}
+}
+
+// setStock stores new stock and reserved counts on a book. The book's Stock
+// pointer is replaced, never written through, since copies handed out
+// earlier share it.
+func (r *MemoryInventoryRepo) setStock(b models.Book, stock, reserved int) models.Book {
+	b.Stock, b.Reserved = &stock, reserved
+	r.books.books[b.ID] = b
+	return b
+}
+
+func (r *MemoryInventoryRepo) Reserve(ctx context.Context, cartID, bookID, qty int, expiresAt time.Time) error {
+	r.books.mu.Lock()
+	defer r.books.mu.Unlock()
+
+	if qty < 0 {
+		return apperr.Invalid("qty", "qty cannot be negative")
+	}
+	b, ok := r.books.books[bookID]
+	if !ok {
+		return apperr.NotFound("book not found")
+	}
+	if b.Stock == nil {
+		return nil
+	}
+
+	key := [2]int{cartID, bookID}
+	delta := qty - r.holds[key].Qty
+	if delta > 0 && *b.Stock-b.Reserved < delta {
+		return outOfStock(b)
+	}
+
+	r.setStock(b, *b.Stock, b.Reserved+delta)
+	if qty == 0 {
+		delete(r.holds, key)
+	} else {
+		r.holds[key] = models.StockReservation{CartID: cartID, BookID: bookID, Qty: qty, ExpiresAt: expiresAt}
+	}
+	return nil
+}
+
+func (r *MemoryInventoryRepo) ReleaseCart(ctx context.Context, cartID int) error {
+	r.books.mu.Lock()
+	defer r.books.mu.Unlock()
+
+	r.release(func(h models.StockReservation) bool { return h.CartID == cartID })
+	return nil
+}
+
+func (r *MemoryInventoryRepo) ReleaseExpired(ctx context.Context, now time.Time) (int, error) {
+	r.books.mu.Lock()
+	defer r.books.mu.Unlock()
+
+	return r.release(func(h models.StockReservation) bool { return h.ExpiresAt.Before(now) }), nil
+}
+
+func (r *MemoryInventoryRepo) release(match func(models.StockReservation) bool) int {
+	n := 0
+	for key, h := range r.holds {
+		if !match(h) {
+			continue
+		}
+		delete(r.holds, key)
+		if b, ok := r.books.books[h.BookID]; ok && b.Stock != nil {
+			r.setStock(b, *b.Stock, b.Reserved-h.Qty)
+		}
+		n++
+	}
+	return n
+}
+
+func (r *MemoryInventoryRepo) Take(ctx context.Context, cartID int, items []models.OrderItem) ([]models.StockMovement, error) {
+	r.books.mu.Lock()
+	defer r.books.mu.Unlock()
+
+	// Check everything before changing anything; the lock keeps the check
+	// valid. Quantities of the same book are added up first.
+	need := map[int]int{}
+	for _, it := range items {
+		need[it.BookID] += it.Qty
+	}
+	for bookID, qty := range need {
+		b, ok := r.books.books[bookID]
+		if !ok {
+			return nil, apperr.NotFound("book not found")
+		}
+		own := r.holds[[2]int{cartID, bookID}].Qty
+		if b.Stock != nil && *b.Stock-(b.Reserved-own) < qty {
+			return nil, outOfStock(b)
+		}
+	}
+
+	out := []models.StockMovement{}
+	for _, it := range items {
+		b := r.books.books[it.BookID]
+		if b.Stock == nil {
+			continue
+		}
+		key := [2]int{cartID, it.BookID}
+		own := r.holds[key].Qty
+		delete(r.holds, key)
+
+		b = r.setStock(b, *b.Stock-it.Qty, b.Reserved-own)
+		out = append(out, models.StockMovement{BookID: it.BookID, Delta: -it.Qty, StockAfter: *b.Stock, Reason: models.MovementOrder})
+	}
+	return out, nil
+}
+
+func (r *MemoryInventoryRepo) Restock(ctx context.Context, items []models.OrderItem) ([]models.StockMovement, error) {
+	r.books.mu.Lock()
+	defer r.books.mu.Unlock()
+
+	out := []models.StockMovement{}
+	for _, it := range items {
+		b, ok := r.books.books[it.BookID]
+		if !ok || b.Stock == nil {
+			continue
+		}
+		b = r.setStock(b, *b.Stock+it.Qty, b.Reserved)
+		out = append(out, models.StockMovement{BookID: it.BookID, Delta: it.Qty, StockAfter: *b.Stock})
+	}
+	return out, nil
+}
+
+func (r *MemoryInventoryRepo) Adjust(ctx context.Context, bookID, delta int) (models.StockMovement, error) {
+	r.books.mu.Lock()
+	defer r.books.mu.Unlock()
+
+	b, ok := r.books.books[bookID]
+	if !ok {
+		return models.StockMovement{}, apperr.NotFound("book not found")
+	}
+	stock := 0
+	if b.Stock != nil {
+		stock = *b.Stock
+	}
+	if stock+delta < 0 {
+		return models.StockMovement{}, apperr.Invalid("delta", "stock cannot go below zero")
+	}
+	b = r.setStock(b, stock+delta, b.Reserved)
+	return models.StockMovement{BookID: bookID, Delta: delta, StockAfter: *b.Stock, Reason: models.MovementAdjustment}, nil
+}
+
+func (r *MemoryInventoryRepo) Record(ctx context.Context, movements ...models.StockMovement) ([]models.StockMovement, error) {
+	r.movementsMu.Lock()
+	defer r.movementsMu.Unlock()
+
+	now := time.Now()
+	out := make([]models.StockMovement, 0, len(movements))
+	for _, m := range movements {
+		m.ID = r.nextMovementID
+		r.nextMovementID++
+		if m.CreatedAt.IsZero() {
+			m.CreatedAt = now
+		}
+		r.movements = append(r.movements, m)
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func (r *MemoryInventoryRepo) Movements(ctx context.Context, bookID, limit int) []models.StockMovement {
+	r.movementsMu.RLock()
+	defer r.movementsMu.RUnlock()
+
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	out := []models.StockMovement{}
+	for _, m := range r.movements {
+		if bookID <= 0 || m.BookID == bookID {
+			out = append(out, m)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b models.StockMovement) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryOrderRepo struct {
+	mu sync.RWMutex
+
+	nextOrderID int
+	nextItemID  int
+
+	orders map[int]models.Order
+	items  map[int][]models.OrderItem
+}
+
+func NewMemoryOrderRepo() *MemoryOrderRepo {
+	return &MemoryOrderRepo{
+		nextOrderID: 1,
+		nextItemID:  1,
+		orders:      make(map[int]models.Order),
+		items:       make(map[int][]models.OrderItem),
+	}
+}
+
+func (r *MemoryOrderRepo) Create(ctx context.Context, order models.Order, items []models.OrderItem) (models.Order, []models.OrderItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := validateNewOrder(order, items); err != nil {
+		return models.Order{}, nil, err
+	}
+
+	order.ID = r.nextOrderID
+	r.nextOrderID++
+	order.DeletedAt, order.DeletedBy = nil, 0
+	order.Version = 1
+	order.Status = models.OrderPending
+	order.History = []models.StatusChange{{To: models.OrderPending, At: time.Now()}}
+	order.ShippingAddress = clonePtr(order.ShippingAddress)
+
+	out := make([]models.OrderItem, 0, len(items))
+	for _, it := range items {
+		it.ID = r.nextItemID
+		r.nextItemID++
+		it.OrderID = order.ID
+		out = append(out, it)
+	}
+
+	r.orders[order.ID] = order
+	r.items[order.ID] = out
+	return cloneOrder(order), slices.Clone(out), nil
+}
+
+func (r *MemoryOrderRepo) GetByID(ctx context.Context, id int) (models.Order, []models.OrderItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	o, ok := r.orders[id]
+	if !ok || o.DeletedAt != nil {
+		return models.Order{}, nil, apperr.NotFound("order not found")
+	}
+	return cloneOrder(o), slices.Clone(r.items[id]), nil
+}
+
+func (r *MemoryOrderRepo) GetAll(ctx context.Context) []models.Order {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := slices.DeleteFunc(
+		sortedByID(r.orders, func(o models.Order) int { return o.ID }),
+		func(o models.Order) bool { return o.DeletedAt != nil },
+	)
+	for i := range out {
+		out[i] = cloneOrder(out[i])
+	}
+	return out
+}
+
+func (r *MemoryOrderRepo) Update(ctx context.Context, order models.Order) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := validateOrderUpdate(order); err != nil {
+		return models.Order{}, err
+	}
+
+	cur, ok := r.orders[order.ID]
+	if !ok || cur.DeletedAt != nil {
+		return models.Order{}, apperr.NotFound("order not found")
+	}
+	if order.Version != 0 && order.Version != cur.Version {
+		return models.Order{}, staleVersion("order")
+	}
+	cur.CustomerID = order.CustomerID
+	cur.CartID = order.CartID
+	cur.Total = order.Total
+	cur.Version++
+	r.orders[order.ID] = cur
+
+	return cloneOrder(cur), nil
+}
+
+func (r *MemoryOrderRepo) Claim(ctx context.Context, id, version int) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if version <= 0 {
+		return models.Order{}, apperr.Invalid("version", "version must be positive")
+	}
+
+	cur, ok := r.orders[id]
+	if !ok || cur.DeletedAt != nil {
+		return models.Order{}, apperr.NotFound("order not found")
+	}
+	if cur.Version != version {
+		return models.Order{}, staleVersion("order")
+	}
+	cur.Version++
+	r.orders[id] = cur
+
+	return cloneOrder(cur), nil
+}
+
+func (r *MemoryOrderRepo) Transition(ctx context.Context, id, version int, change models.StatusChange) (models.Order, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur, ok := r.orders[id]
+	if !ok || cur.DeletedAt != nil {
+		return models.Order{}, apperr.NotFound("order not found")
+	}
+	if cur.Status != change.From || (version != 0 && version != cur.Version) {
+		return models.Order{}, staleVersion("order")
+	}
+	cur.Status = change.To
+	cur.History = append(slices.Clone(cur.History), change)
+	cur.Version++
+	r.orders[id] = cur
+	return cloneOrder(cur), nil
+}
+
+// cloneOrder copies the parts of an order that are shared by reference, so
+// callers cannot change the stored order through what they are handed.
+func cloneOrder(o models.Order) models.Order {
+	o.ShippingAddress = clonePtr(o.ShippingAddress)
+	o.History = slices.Clone(o.History)
+	return o
+}
+
+func (r *MemoryOrderRepo) Delete(ctx context.Context, id, deletedBy int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.orders[id]
+	if !ok || o.DeletedAt != nil {
+		return apperr.NotFound("order not found")
+	}
+	now := time.Now()
+	o.DeletedAt, o.DeletedBy = &now, deletedBy
+	r.orders[id] = o
+	return nil
+}
+
+func (r *MemoryOrderRepo) Restore(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.orders[id]
+	if !ok || o.DeletedAt == nil {
+		return apperr.NotFound("deleted order not found")
+	}
+	o.DeletedAt, o.DeletedBy = nil, 0
+	r.orders[id] = o
+	return nil
+}
+
+func (r *MemoryOrderRepo) Purge(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id, o := range r.orders {
+		if o.DeletedAt != nil && o.DeletedAt.Before(before) {
+			delete(r.orders, id)
+			delete(r.items, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryOrderRepo) BookIDs(ctx context.Context) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []int{}
+	for _, items := range r.items {
+		for _, it := range items {
+			if !slices.Contains(out, it.BookID) {
+				out = append(out, it.BookID)
+			}
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
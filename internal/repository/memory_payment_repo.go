@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryPaymentRepo struct {
+	mu sync.RWMutex
+
+	nextID   int
+	payments map[int]models.Payment
+	events   map[string]bool
+}
+
+func NewMemoryPaymentRepo() *MemoryPaymentRepo {
+	return &MemoryPaymentRepo{
+		nextID:   1,
+		payments: make(map[int]models.Payment),
+		events:   make(map[string]bool),
+	}
+}
+
+func (r *MemoryPaymentRepo) Create(ctx context.Context, p models.Payment) (models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := validateNewPayment(p); err != nil {
+		return models.Payment{}, err
+	}
+	for _, cur := range r.payments {
+		if cur.Provider == p.Provider && cur.IntentID == p.IntentID {
+			return models.Payment{}, apperr.Conflict("payment intent already recorded")
+		}
+	}
+
+	p.ID = r.nextID
+	r.nextID++
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = p.CreatedAt
+	r.payments[p.ID] = p
+	return p, nil
+}
+
+func (r *MemoryPaymentRepo) GetByIntent(ctx context.Context, provider, intentID string) (models.Payment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, p := range r.payments {
+		if p.Provider == provider && p.IntentID == intentID {
+			return p, nil
+		}
+	}
+	return models.Payment{}, apperr.NotFound("payment not found")
+}
+
+func (r *MemoryPaymentRepo) ListByOrder(ctx context.Context, orderID int) []models.Payment {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []models.Payment{}
+	for _, p := range sortedByID(r.payments, func(p models.Payment) int { return p.ID }) {
+		if p.OrderID == orderID {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (r *MemoryPaymentRepo) SetStatus(ctx context.Context, id int, from, to, reason string) (models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.payments[id]
+	if !ok {
+		return models.Payment{}, apperr.NotFound("payment not found")
+	}
+	if p.Status != from {
+		return models.Payment{}, apperr.Stale("payment is no longer " + from)
+	}
+	p.Status = to
+	p.FailureReason = reason
+	p.UpdatedAt = time.Now()
+	r.payments[id] = p
+	return p, nil
+}
+
+func (r *MemoryPaymentRepo) EventSeen(ctx context.Context, provider, eventID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.events[provider+":"+eventID], nil
+}
+
+func (r *MemoryPaymentRepo) MarkEvent(ctx context.Context, provider, eventID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[provider+":"+eventID] = true
+	return nil
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryRefundRepo struct {
+	mu sync.RWMutex
+
+	nextID  int
+	refunds map[int]models.Refund
+}
+
+func NewMemoryRefundRepo() *MemoryRefundRepo {
+	return &MemoryRefundRepo{
+		nextID:  1,
+		refunds: make(map[int]models.Refund),
+	}
+}
+
+func (r *MemoryRefundRepo) Create(ctx context.Context, rf models.Refund) (models.Refund, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := validateNewRefund(rf); err != nil {
+		return models.Refund{}, err
+	}
+	for _, cur := range r.refunds {
+		if cur.OrderID == rf.OrderID && cur.ProviderRefundID == rf.ProviderRefundID {
+			return models.Refund{}, apperr.Conflict("refund already recorded")
+		}
+	}
+
+	rf.ID = r.nextID
+	r.nextID++
+	rf.CreatedAt = time.Now()
+	rf.Items = slices.Clone(rf.Items)
+	r.refunds[rf.ID] = rf
+
+	rf.Items = slices.Clone(rf.Items)
+	return rf, nil
+}
+
+func (r *MemoryRefundRepo) ListByOrder(ctx context.Context, orderID int) []models.Refund {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []models.Refund{}
+	for _, rf := range sortedByID(r.refunds, func(rf models.Refund) int { return rf.ID }) {
+		if rf.OrderID == orderID {
+			rf.Items = slices.Clone(rf.Items)
+			out = append(out, rf)
+		}
+	}
+	return out
+}
@@ -1 +1,262 @@
 package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryRevocationRepo struct {
+	mu sync.RWMutex
+
+	tokens  map[string]models.RevokedToken
+	cutoffs map[int]time.Time
+}
+
+func NewMemoryRevocationRepo() *MemoryRevocationRepo {
+	return &MemoryRevocationRepo{
+		tokens:  make(map[string]models.RevokedToken),
+		cutoffs: make(map[int]time.Time),
+	}
+}
+
+func (r *MemoryRevocationRepo) Revoke(ctx context.Context, t models.RevokedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.JTI == "" {
+		return apperr.Invalid("jti", "jti required")
+	}
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = time.Now()
+	}
+
+	now := time.Now()
+	for jti, existing := range r.tokens {
+		if now.After(existing.ExpiresAt) {
+			delete(r.tokens, jti)
+		}
+	}
+
+	if _, ok := r.tokens[t.JTI]; !ok {
+		r.tokens[t.JTI] = t
+	}
+	return nil
+}
+
+func (r *MemoryRevocationRepo) RevokeOnce(ctx context.Context, t models.RevokedToken) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.JTI == "" {
+		return false, apperr.Invalid("jti", "jti required")
+	}
+	if _, ok := r.tokens[t.JTI]; ok {
+		return false, nil
+	}
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = time.Now()
+	}
+	r.tokens[t.JTI] = t
+	return true, nil
+}
+
+func (r *MemoryRevocationRepo) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.tokens[jti]
+	return ok, nil
+}
+
+func (r *MemoryRevocationRepo) RevokeAllForUser(ctx context.Context, userID int, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if userID <= 0 {
+		return apperr.Invalid("userId", "userId must be positive")
+	}
+	at = at.Truncate(time.Microsecond)
+	if at.After(r.cutoffs[userID]) {
+		r.cutoffs[userID] = at
+	}
+	return nil
+}
+
+func (r *MemoryRevocationRepo) RevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cutoffs[userID], nil
+}
+
+type MemoryCartRepo struct {
+	mu sync.RWMutex
+
+	nextCartID int
+	nextItemID int
+
+	carts map[int]models.Cart
+	items map[int][]models.CartItem
+}
+
+func NewMemoryCartRepo() *MemoryCartRepo {
+	return &MemoryCartRepo{
+		nextCartID: 1,
+		nextItemID: 1,
+		carts:      make(map[int]models.Cart),
+		items:      make(map[int][]models.CartItem),
+	}
+}
+
+func (r *MemoryCartRepo) Create(ctx context.Context, customerID int) (models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if customerID <= 0 {
+		return models.Cart{}, apperr.Invalid("customerId", "customerId must be positive")
+	}
+
+	c := models.Cart{
+		ID:         r.nextCartID,
+		CustomerID: customerID,
+		CreatedAt:  time.Now(),
+		Version:    1,
+	}
+	r.nextCartID++
+	r.carts[c.ID] = c
+	r.items[c.ID] = []models.CartItem{}
+	return c, nil
+}
+
+func (r *MemoryCartRepo) GetAll(ctx context.Context) []models.Cart {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return sortedByID(r.carts, func(c models.Cart) int { return c.ID })
+}
+
+func (r *MemoryCartRepo) GetByID(ctx context.Context, id int) (models.Cart, []models.CartItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.carts[id]
+	if !ok {
+		return models.Cart{}, nil, apperr.NotFound("cart not found")
+	}
+	items := append([]models.CartItem{}, r.items[id]...)
+	return c, items, nil
+}
+
+func (r *MemoryCartRepo) Update(ctx context.Context, cart models.Cart) (models.Cart, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cur, ok := r.carts[cart.ID]
+	if !ok {
+		return models.Cart{}, apperr.NotFound("cart not found")
+	}
+	if cart.Version != 0 && cart.Version != cur.Version {
+		return models.Cart{}, staleVersion("cart")
+	}
+	cart.Version = cur.Version + 1
+	r.carts[cart.ID] = cart
+	return cart, nil
+}
+
+func (r *MemoryCartRepo) Delete(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.carts[id]; !ok {
+		return apperr.NotFound("cart not found")
+	}
+	delete(r.carts, id)
+	delete(r.items, id)
+	return nil
+}
+
+func (r *MemoryCartRepo) AddItem(ctx context.Context, cartID int, bookID int, qty int) (models.CartItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.carts[cartID]; !ok {
+		return models.CartItem{}, apperr.NotFound("cart not found")
+	}
+	if qty <= 0 {
+		return models.CartItem{}, apperr.Invalid("qty", "qty must be positive")
+	}
+
+	items := r.items[cartID]
+	for i := range items {
+		if items[i].BookID == bookID {
+			items[i].Qty += qty
+			r.items[cartID] = items
+			return items[i], nil
+		}
+	}
+
+	it := models.CartItem{
+		ID:     r.nextItemID,
+		CartID: cartID,
+		BookID: bookID,
+		Qty:    qty,
+	}
+	r.nextItemID++
+	r.items[cartID] = append(r.items[cartID], it)
+	return it, nil
+}
+
+func (r *MemoryCartRepo) UpdateItem(ctx context.Context, cartID int, itemID int, qty int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if qty <= 0 {
+		return apperr.Invalid("qty", "qty must be positive")
+	}
+
+	items := r.items[cartID]
+	for i := range items {
+		if items[i].ID == itemID {
+			items[i].Qty = qty
+			r.items[cartID] = items
+			return nil
+		}
+	}
+	return apperr.NotFound("item not found")
+}
+
+func (r *MemoryCartRepo) DeleteItem(ctx context.Context, cartID int, itemID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := r.items[cartID]
+	out := make([]models.CartItem, 0, len(items))
+	found := false
+	for _, it := range items {
+		if it.ID == itemID {
+			found = true
+			continue
+		}
+		out = append(out, it)
+	}
+	if !found {
+		return apperr.NotFound("item not found")
+	}
+	r.items[cartID] = out
+	return nil
+}
+
+func (r *MemoryCartRepo) ClearCart(ctx context.Context, cartID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.carts[cartID]; !ok {
+		return apperr.NotFound("cart not found")
+	}
+	r.items[cartID] = []models.CartItem{}
+	return nil
+}
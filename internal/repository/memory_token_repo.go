@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryRefreshTokenRepo struct {
+	mu sync.RWMutex
+
+	tokens map[string]models.RefreshToken
+}
+
+func NewMemoryRefreshTokenRepo() *MemoryRefreshTokenRepo {
+	return &MemoryRefreshTokenRepo{tokens: make(map[string]models.RefreshToken)}
+}
+
+func (r *MemoryRefreshTokenRepo) Create(ctx context.Context, t models.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if t.TokenHash == "" {
+		return errors.New("token hash required")
+	}
+	if t.UserID <= 0 {
+		return apperr.Invalid("userId", "userId must be positive")
+	}
+
+	// Expired tokens are useless once past their window; drop them here the
+	// way a TTL index would.
+	now := time.Now()
+	for hash, existing := range r.tokens {
+		if now.After(existing.ExpiresAt) {
+			delete(r.tokens, hash)
+		}
+	}
+
+	t.RevokedAt = clonePtr(t.RevokedAt)
+	r.tokens[t.TokenHash] = t
+	return nil
+}
+
+func (r *MemoryRefreshTokenRepo) GetByHash(ctx context.Context, hash string) (models.RefreshToken, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	t, ok := r.tokens[hash]
+	if !ok {
+		return models.RefreshToken{}, apperr.NotFound("refresh token not found")
+	}
+	t.RevokedAt = clonePtr(t.RevokedAt)
+	return t, nil
+}
+
+func (r *MemoryRefreshTokenRepo) Revoke(ctx context.Context, hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t, ok := r.tokens[hash]
+	if !ok || t.RevokedAt != nil {
+		return apperr.NotFound("refresh token not found or already revoked")
+	}
+	now := time.Now()
+	t.RevokedAt = &now
+	r.tokens[hash] = t
+	return nil
+}
+
+func (r *MemoryRefreshTokenRepo) RevokeFamily(ctx context.Context, familyID string) error {
+	return r.revokeWhere(func(t models.RefreshToken) bool { return t.FamilyID == familyID })
+}
+
+func (r *MemoryRefreshTokenRepo) RevokeAllForUser(ctx context.Context, userID int) error {
+	return r.revokeWhere(func(t models.RefreshToken) bool { return t.UserID == userID })
+}
+
+func (r *MemoryRefreshTokenRepo) revokeWhere(match func(models.RefreshToken) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for hash, t := range r.tokens {
+		if t.RevokedAt == nil && match(t) {
+			t.RevokedAt = &now
+			r.tokens[hash] = t
+		}
+	}
+	return nil
+}
+
+type MemoryAPIKeyRepo struct {
+	mu sync.RWMutex
+
+	nextID int
+	keys   map[int]models.APIKey
+}
+
+func NewMemoryAPIKeyRepo() *MemoryAPIKeyRepo {
+	return &MemoryAPIKeyRepo{
+		nextID: 1,
+		keys:   make(map[int]models.APIKey),
+	}
+}
+
+func (r *MemoryAPIKeyRepo) Create(ctx context.Context, k models.APIKey) (models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k.KeyHash == "" {
+		return models.APIKey{}, errors.New("key hash required")
+	}
+	if k.UserID <= 0 {
+		return models.APIKey{}, apperr.Invalid("userId", "userId must be positive")
+	}
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+
+	k.ID = r.nextID
+	r.nextID++
+	k = cloneAPIKey(k)
+	r.keys[k.ID] = k
+	return cloneAPIKey(k), nil
+}
+
+func (r *MemoryAPIKeyRepo) GetByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, k := range r.keys {
+		if k.KeyHash == hash {
+			return cloneAPIKey(k), nil
+		}
+	}
+	return models.APIKey{}, apperr.NotFound("api key not found")
+}
+
+func (r *MemoryAPIKeyRepo) ListByUser(ctx context.Context, userID int) []models.APIKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := []models.APIKey{}
+	for _, k := range sortedByID(r.keys, func(k models.APIKey) int { return k.ID }) {
+		if k.UserID == userID {
+			out = append(out, cloneAPIKey(k))
+		}
+	}
+	return out
+}
+
+func (r *MemoryAPIKeyRepo) Revoke(ctx context.Context, userID int, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[id]
+	if !ok || k.UserID != userID || k.RevokedAt != nil {
+		return apperr.NotFound("api key not found or already revoked")
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	r.keys[id] = k
+	return nil
+}
+
+func (r *MemoryAPIKeyRepo) TouchLastUsed(ctx context.Context, id int, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[id]
+	if !ok {
+		return nil
+	}
+	if k.LastUsedAt == nil || at.After(*k.LastUsedAt) {
+		k.LastUsedAt = &at
+		r.keys[id] = k
+	}
+	return nil
+}
+
+func cloneAPIKey(k models.APIKey) models.APIKey {
+	k.Scopes = slices.Clone(k.Scopes)
+	k.ExpiresAt = clonePtr(k.ExpiresAt)
+	k.LastUsedAt = clonePtr(k.LastUsedAt)
+	k.RevokedAt = clonePtr(k.RevokedAt)
+	return k
+}
@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryUserRepo struct {
+	mu sync.RWMutex
+
+	nextID   int
+	users    map[int]models.User
+	attempts []models.LoginAttempt
+}
+
+func NewMemoryUserRepo() *MemoryUserRepo {
+	return &MemoryUserRepo{
+		nextID: 1,
+		users:  make(map[int]models.User),
+	}
+}
+
+func (r *MemoryUserRepo) Create(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.Email == "" {
+		return apperr.Invalid("email", "email required")
+	}
+	if user.Password == "" {
+		return apperr.Invalid("password", "password required")
+	}
+	if user.Role == "" {
+		user.Role = models.RoleCustomer
+	}
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return apperr.Conflict("email already exists")
+		}
+	}
+
+	user.ID = r.nextID
+	r.nextID++
+	r.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (r *MemoryUserRepo) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			return cloneUser(u), nil
+		}
+	}
+	return models.User{}, apperr.NotFound("user not found")
+}
+
+func (r *MemoryUserRepo) GetByID(ctx context.Context, id int) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return models.User{}, apperr.NotFound("user not found")
+	}
+	return cloneUser(u), nil
+}
+
+func (r *MemoryUserRepo) GetAll(ctx context.Context) []models.User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := sortedByID(r.users, func(u models.User) int { return u.ID })
+	for i := range out {
+		out[i] = cloneUser(out[i])
+	}
+	return out
+}
+
+func (r *MemoryUserRepo) Update(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.ID <= 0 {
+		return apperr.Invalid("id", "invalid user id")
+	}
+	if _, ok := r.users[user.ID]; !ok {
+		return apperr.NotFound("user not found")
+	}
+	r.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+func (r *MemoryUserRepo) UpdateProfile(ctx context.Context, userID int, name, phone string) error {
+	return r.modify(userID, func(u *models.User) {
+		u.Name = name
+		u.Phone = phone
+	})
+}
+
+func (r *MemoryUserRepo) RecordLoginFailure(ctx context.Context, userID int, at time.Time) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return models.User{}, apperr.NotFound("user not found")
+	}
+	u.FailedLogins++
+	u.LastFailedLoginAt = &at
+	r.users[userID] = u
+	return cloneUser(u), nil
+}
+
+func (r *MemoryUserRepo) SetLockout(ctx context.Context, userID int, until *time.Time) error {
+	return r.modify(userID, func(u *models.User) {
+		if until == nil {
+			u.LockedUntil = nil
+			return
+		}
+		t := *until
+		u.LockedUntil = &t
+	})
+}
+
+func (r *MemoryUserRepo) ResetLoginFailures(ctx context.Context, userID int) error {
+	return r.modify(userID, func(u *models.User) {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+	})
+}
+
+func (r *MemoryUserRepo) AddLoginAttempt(ctx context.Context, a models.LoginAttempt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	r.attempts = append(r.attempts, a)
+	return nil
+}
+
+func (r *MemoryUserRepo) CountFailedLoginsByIP(ctx context.Context, ip string, since time.Time, reasons []string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	n := 0
+	for _, a := range r.attempts {
+		if a.IP == ip && !a.Success && !a.CreatedAt.Before(since) && slices.Contains(reasons, a.Reason) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryUserRepo) ListLoginAttempts(ctx context.Context, email string, limit int) []models.LoginAttempt {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	out := []models.LoginAttempt{}
+	for i := len(r.attempts) - 1; i >= 0 && len(out) < limit; i-- {
+		if email == "" || r.attempts[i].Email == email {
+			out = append(out, r.attempts[i])
+		}
+	}
+	return out
+}
+
+func (r *MemoryUserRepo) SetTOTP(ctx context.Context, userID int, secret string, enabled bool, recoveryCodes []string) error {
+	return r.modify(userID, func(u *models.User) {
+		u.TOTPSecret = secret
+		u.TOTPEnabled = secret != "" && enabled
+		if u.TOTPEnabled {
+			// The last used step survives so regenerating recovery codes
+			// cannot be used to replay the code that authorised it.
+			now := time.Now()
+			u.TOTPEnabledAt = &now
+			u.RecoveryCodes = slices.Clone(recoveryCodes)
+			return
+		}
+		u.TOTPEnabledAt = nil
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+	})
+}
+
+func (r *MemoryUserRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok || u.TOTPLastStep >= step {
+		return false, nil
+	}
+	u.TOTPLastStep = step
+	r.users[userID] = u
+	return true, nil
+}
+
+func (r *MemoryUserRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return false, nil
+	}
+	i := slices.Index(u.RecoveryCodes, codeHash)
+	if i < 0 {
+		return false, nil
+	}
+	u.RecoveryCodes = slices.Delete(slices.Clone(u.RecoveryCodes), i, i+1)
+	r.users[userID] = u
+	return true, nil
+}
+
+func (r *MemoryUserRepo) modify(userID int, fn func(u *models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[userID]
+	if !ok {
+		return apperr.NotFound("user not found")
+	}
+	fn(&u)
+	r.users[userID] = u
+	return nil
+}
+
+// cloneUser detaches the slice and pointer fields so callers cannot mutate
+// stored records behind the lock.
+func cloneUser(u models.User) models.User {
+	u.RecoveryCodes = slices.Clone(u.RecoveryCodes)
+	u.EmailVerifiedAt = clonePtr(u.EmailVerifiedAt)
+	u.LastFailedLoginAt = clonePtr(u.LastFailedLoginAt)
+	u.LockedUntil = clonePtr(u.LockedUntil)
+	u.TOTPEnabledAt = clonePtr(u.TOTPEnabledAt)
+	return u
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+)
+
+type MemoryWishlistRepo struct {
+	mu sync.RWMutex
+
+	nextWishlistID int
+	nextItemID     int
+
+	wishlists map[int]models.Wishlist
+	items     map[int][]models.WishlistItem
+}
+
+func NewMemoryWishlistRepo() *MemoryWishlistRepo {
+	return &MemoryWishlistRepo{
+		nextWishlistID: 1,
+		nextItemID:     1,
+		wishlists:      make(map[int]models.Wishlist),
+		items:          make(map[int][]models.WishlistItem),
+	}
+}
+
+func (r *MemoryWishlistRepo) Create(ctx context.Context, customerID int) models.Wishlist {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if customerID <= 0 {
+		customerID = 1
+	}
+
+	w := models.Wishlist{
+		ID:         r.nextWishlistID,
+		CustomerID: customerID,
+	}
+	r.nextWishlistID++
+	r.wishlists[w.ID] = w
+	return w
+}
+
+func (r *MemoryWishlistRepo) GetAll(ctx context.Context) []models.Wishlist {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.DeleteFunc(
+		sortedByID(r.wishlists, func(w models.Wishlist) int { return w.ID }),
+		func(w models.Wishlist) bool { return w.DeletedAt != nil },
+	)
+}
+
+func (r *MemoryWishlistRepo) GetByID(ctx context.Context, id int) (models.Wishlist, []models.WishlistItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w, ok := r.wishlists[id]
+	if !ok || w.DeletedAt != nil {
+		return models.Wishlist{}, nil, apperr.NotFound("wishlist not found")
+	}
+	items := slices.Clone(r.items[id])
+	if items == nil {
+		items = []models.WishlistItem{}
+	}
+	return w, items, nil
+}
+
+func (r *MemoryWishlistRepo) Delete(ctx context.Context, id, deletedBy int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.wishlists[id]
+	if !ok || w.DeletedAt != nil {
+		return apperr.NotFound("wishlist not found")
+	}
+	now := time.Now()
+	w.DeletedAt, w.DeletedBy = &now, deletedBy
+	r.wishlists[id] = w
+	return nil
+}
+
+func (r *MemoryWishlistRepo) Restore(ctx context.Context, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.wishlists[id]
+	if !ok || w.DeletedAt == nil {
+		return apperr.NotFound("deleted wishlist not found")
+	}
+	w.DeletedAt, w.DeletedBy = nil, 0
+	r.wishlists[id] = w
+	return nil
+}
+
+func (r *MemoryWishlistRepo) Purge(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for id, w := range r.wishlists {
+		if w.DeletedAt != nil && w.DeletedAt.Before(before) {
+			delete(r.wishlists, id)
+			delete(r.items, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryWishlistRepo) AddItem(ctx context.Context, wishlistID int, bookID int, qty int) (models.WishlistItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if qty <= 0 {
+		return models.WishlistItem{}, apperr.Invalid("qty", "qty must be > 0")
+	}
+	if w, ok := r.wishlists[wishlistID]; !ok || w.DeletedAt != nil {
+		return models.WishlistItem{}, apperr.NotFound("wishlist not found")
+	}
+
+	items := r.items[wishlistID]
+	for i := range items {
+		if items[i].BookID == bookID {
+			items[i].Qty += qty
+			return items[i], nil
+		}
+	}
+
+	it := models.WishlistItem{
+		ID:         r.nextItemID,
+		WishlistID: wishlistID,
+		BookID:     bookID,
+		Qty:        qty,
+	}
+	r.nextItemID++
+	r.items[wishlistID] = append(items, it)
+	return it, nil
+}
+
+func (r *MemoryWishlistRepo) DeleteItem(ctx context.Context, wishlistID int, itemID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	items := r.items[wishlistID]
+	i := slices.IndexFunc(items, func(it models.WishlistItem) bool { return it.ID == itemID })
+	if i < 0 {
+		return apperr.NotFound("item not found")
+	}
+	r.items[wishlistID] = slices.Delete(items, i, i+1)
+	return nil
+}
@@ -2,115 +2,172 @@ package repository
 
 import (
 	"context"
-	"errors"
+	"log"
 	"time"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type OrderRepository interface {
-	Create(order models.Order, items []models.OrderItem) (models.Order, []models.OrderItem, error)
-	GetByID(id int) (models.Order, []models.OrderItem, error)
-	GetAll() []models.Order
-	Update(order models.Order) error
-	Delete(id int) error
+	Create(ctx context.Context, order models.Order, items []models.OrderItem) (models.Order, []models.OrderItem, error)
+	GetByID(ctx context.Context, id int) (models.Order, []models.OrderItem, error)
+	GetAll(ctx context.Context) []models.Order
+	// Update stores the order's customer, cart and total and returns the
+	// order with its new version. A non-zero order.Version must match the
+	// stored one.
+	Update(ctx context.Context, order models.Order) (models.Order, error)
+	// Claim moves the order to its next version and changes nothing else,
+	// so that any other writer still holding version fails as stale.
+	Claim(ctx context.Context, id, version int) (models.Order, error)
+	// Transition moves an order from change.From to change.To and appends
+	// change to its history. It fails with apperr.Stale when the order is no
+	// longer in change.From, or when a non-zero version does not match. It
+	// does not judge whether the move itself is allowed.
+	Transition(ctx context.Context, id, version int, change models.StatusChange) (models.Order, error)
+
+	Delete(ctx context.Context, id, deletedBy int) error
+	Restore(ctx context.Context, id int) error
+	// Purge hard-deletes orders soft-deleted before the cutoff, with their
+	// items.
+	Purge(ctx context.Context, before time.Time) (int, error)
+
+	// BookIDs lists every book referenced by a stored order, deleted or not.
+	BookIDs(ctx context.Context) ([]int, error)
 }
 
 type OrderRepo struct {
+	tx        *MongoTransactions
 	ordersCol *mongo.Collection
 	itemsCol  *mongo.Collection
-	counters  *CounterRepo
+	ids       IDGenerator
+	itemIDs   IDGenerator
 }
 
-func NewOrderRepo(db *mongo.Database) *OrderRepo {
+func NewOrderRepo(db *mongo.Database, ids *IDs) *OrderRepo {
 	return &OrderRepo{
+		tx:        NewMongoTransactions(db),
 		ordersCol: db.Collection("orders"),
 		itemsCol:  db.Collection("order_items"),
-		counters:  NewCounterRepo(db),
+		ids:       ids.For("orders"),
+		itemIDs:   ids.For("order_items"),
 	}
 }
 
-func (r *OrderRepo) Create(order models.Order, items []models.OrderItem) (models.Order, []models.OrderItem, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// Create validates the whole order up front, reserves its IDs and then writes
+// the order and its items as one unit.
+//
+// On a replica set or sharded cluster the writes run in a multi-document
+// transaction, so either everything is stored or nothing is; a transaction
+// already carried by ctx is joined rather than nested. A standalone
+// mongod cannot run transactions; there the items are written first and the
+// order document last, so a half-written order is never visible through
+// GetByID, and any items left behind by a failure are deleted before
+// returning. Ids reserved for a failed order are not reused.
+func (r *OrderRepo) Create(ctx context.Context, order models.Order, items []models.OrderItem) (models.Order, []models.OrderItem, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	if order.CustomerID <= 0 {
-		return models.Order{}, nil, errors.New("customerId must be positive")
-	}
-	if order.CartID <= 0 {
-		return models.Order{}, nil, errors.New("cartId must be positive")
-	}
-	if len(items) == 0 {
-		return models.Order{}, nil, errors.New("order items required")
-	}
-	if order.Total < 0 {
-		return models.Order{}, nil, errors.New("total cannot be negative")
+	if err := validateNewOrder(order, items); err != nil {
+		return models.Order{}, nil, err
 	}
 
-	orderID, err := r.counters.Next("orders")
+	orderID, err := r.ids.Next(ctx)
 	if err != nil {
 		return models.Order{}, nil, err
 	}
-	order.ID = orderID
-
-	_, err = r.ordersCol.InsertOne(ctx, order)
+	itemIDs, err := r.itemIDs.NextN(ctx, len(items))
 	if err != nil {
 		return models.Order{}, nil, err
 	}
+	order.ID = orderID
+	order.DeletedAt, order.DeletedBy = nil, 0
+	order.Version = 1
+	order.Status = models.OrderPending
+	order.History = []models.StatusChange{{To: models.OrderPending, At: time.Now()}}
 
 	outItems := make([]models.OrderItem, 0, len(items))
 	docs := make([]any, 0, len(items))
+	for i, it := range items {
+		it.ID = itemIDs[i]
+		it.OrderID = order.ID
+		docs = append(docs, it)
+		outItems = append(outItems, it)
+	}
 
-	for _, it := range items {
-		if it.BookID <= 0 {
-			_, _ = r.ordersCol.DeleteOne(ctx, bson.M{"id": order.ID})
-			return models.Order{}, nil, errors.New("bookId must be positive")
-		}
-		if it.Qty <= 0 {
-			_, _ = r.ordersCol.DeleteOne(ctx, bson.M{"id": order.ID})
-			return models.Order{}, nil, errors.New("qty must be positive")
-		}
-		if it.Price < 0 {
-			_, _ = r.ordersCol.DeleteOne(ctx, bson.M{"id": order.ID})
-			return models.Order{}, nil, errors.New("price cannot be negative")
+	write := func(ctx context.Context) error {
+		if _, err := r.itemsCol.InsertMany(ctx, docs); err != nil {
+			return err
 		}
+		_, err := r.ordersCol.InsertOne(ctx, order)
+		return err
+	}
 
-		itemID, err := r.counters.Next("order_items")
+	ran, err := r.tx.Run(ctx, write)
+	if !ran {
+		err = write(ctx)
 		if err != nil {
-			_, _ = r.ordersCol.DeleteOne(ctx, bson.M{"id": order.ID})
-			return models.Order{}, nil, err
+			r.discardItems(ctx, order.ID)
 		}
+	}
+	if err != nil {
+		return models.Order{}, nil, err
+	}
 
-		it.ID = itemID
-		it.OrderID = order.ID
+	return order, outItems, nil
+}
 
-		docs = append(docs, it)
-		outItems = append(outItems, it)
+func validateNewOrder(order models.Order, items []models.OrderItem) error {
+	if order.CustomerID <= 0 {
+		return apperr.Invalid("customerId", "customerId must be positive")
 	}
-
-	if len(docs) > 0 {
-		_, err = r.itemsCol.InsertMany(ctx, docs)
-		if err != nil {
-			_, _ = r.ordersCol.DeleteOne(ctx, bson.M{"id": order.ID})
-			_, _ = r.itemsCol.DeleteMany(ctx, bson.M{"orderId": order.ID})
-			return models.Order{}, nil, err
+	if order.CartID <= 0 {
+		return apperr.Invalid("cartId", "cartId must be positive")
+	}
+	if len(items) == 0 {
+		return apperr.Invalid("items", "order items required")
+	}
+	if order.Total < 0 {
+		return apperr.Invalid("total", "total cannot be negative")
+	}
+	for _, it := range items {
+		if it.BookID <= 0 {
+			return apperr.Invalid("bookId", "bookId must be positive")
+		}
+		if it.Qty <= 0 {
+			return apperr.Invalid("qty", "qty must be positive")
+		}
+		if it.Price < 0 {
+			return apperr.Invalid("price", "price cannot be negative")
 		}
 	}
+	return nil
+}
 
-	return order, outItems, nil
+// discardItems removes items written for an order that never made it. The
+// cleanup must run even when the caller's context was what made the write
+// fail, so it ignores that context's cancellation.
+func (r *OrderRepo) discardItems(ctx context.Context, orderID int) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+
+	if _, err := r.itemsCol.DeleteMany(ctx, bson.M{"orderId": orderID}); err != nil {
+		log.Printf("[ORDERS] failed to clean up items of unsaved order: orderId=%d err=%v\n", orderID, err)
+	}
 }
 
-func (r *OrderRepo) GetByID(id int) (models.Order, []models.OrderItem, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *OrderRepo) GetByID(ctx context.Context, id int) (models.Order, []models.OrderItem, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var o models.Order
-	err := r.ordersCol.FindOne(ctx, bson.M{"id": id}).Decode(&o)
+	err := r.ordersCol.FindOne(ctx, live(bson.M{"id": id})).Decode(&o)
 	if err == mongo.ErrNoDocuments {
-		return models.Order{}, nil, errors.New("order not found")
+		return models.Order{}, nil, apperr.NotFound("order not found")
 	}
 	if err != nil {
 		return models.Order{}, nil, err
@@ -133,11 +190,11 @@ func (r *OrderRepo) GetByID(id int) (models.Order, []models.OrderItem, error) {
 	return o, items, nil
 }
 
-func (r *OrderRepo) GetAll() []models.Order {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *OrderRepo) GetAll(ctx context.Context) []models.Order {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	cur, err := r.ordersCol.Find(ctx, bson.M{})
+	cur, err := r.ordersCol.Find(ctx, live(bson.M{}))
 	if err != nil {
 		return []models.Order{}
 	}
@@ -154,49 +211,148 @@ func (r *OrderRepo) GetAll() []models.Order {
 	return out
 }
 
-func (r *OrderRepo) Update(order models.Order) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+func (r *OrderRepo) Update(ctx context.Context, order models.Order) (models.Order, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if err := validateOrderUpdate(order); err != nil {
+		return models.Order{}, err
+	}
+
+	var out models.Order
+	err := updateVersioned(ctx, r.ordersCol, live(bson.M{"id": order.ID}), order.Version, bson.M{
+		"customerId": order.CustomerID,
+		"cartId":     order.CartID,
+		"total":      order.Total,
+	}, &out, "order")
+	if err != nil {
+		return models.Order{}, err
+	}
+	return out, nil
+}
+
+func (r *OrderRepo) Claim(ctx context.Context, id, version int) (models.Order, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if version <= 0 {
+		return models.Order{}, apperr.Invalid("version", "version must be positive")
+	}
+
+	var out models.Order
+	if err := updateVersioned(ctx, r.ordersCol, live(bson.M{"id": id}), version, nil, &out, "order"); err != nil {
+		return models.Order{}, err
+	}
+	return out, nil
+}
+
+func (r *OrderRepo) Transition(ctx context.Context, id, version int, change models.StatusChange) (models.Order, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
+	match := live(bson.M{"id": id, "status": change.From})
+	if version != 0 {
+		match["version"] = version
+	}
+
+	var out models.Order
+	err := r.ordersCol.FindOneAndUpdate(ctx, match,
+		bson.M{
+			"$set":  bson.M{"status": change.To},
+			"$inc":  bson.M{"version": 1},
+			"$push": bson.M{"history": change},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&out)
+	if err == nil {
+		return out, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return models.Order{}, err
+	}
+
+	n, err := r.ordersCol.CountDocuments(ctx, live(bson.M{"id": id}), options.Count().SetLimit(1))
+	if err != nil {
+		return models.Order{}, err
+	}
+	if n > 0 {
+		return models.Order{}, staleVersion("order")
+	}
+	return models.Order{}, apperr.NotFound("order not found")
+}
+
+func validateOrderUpdate(order models.Order) error {
 	if order.ID <= 0 {
-		return errors.New("order id must be positive")
+		return apperr.Invalid("id", "order id must be positive")
 	}
 	if order.CustomerID <= 0 {
-		return errors.New("customerId must be positive")
+		return apperr.Invalid("customerId", "customerId must be positive")
 	}
 	if order.CartID <= 0 {
-		return errors.New("cartId must be positive")
+		return apperr.Invalid("cartId", "cartId must be positive")
 	}
 	if order.Total < 0 {
-		return errors.New("total cannot be negative")
+		return apperr.Invalid("total", "total cannot be negative")
 	}
+	return nil
+}
 
-	res, err := r.ordersCol.UpdateOne(ctx, bson.M{"id": order.ID}, bson.M{"$set": bson.M{
-		"customerId": order.CustomerID,
-		"cartId":     order.CartID,
-		"total":      order.Total,
-	}})
+func (r *OrderRepo) Delete(ctx context.Context, id, deletedBy int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ok, err := softDelete(ctx, r.ordersCol, id, deletedBy)
 	if err != nil {
 		return err
 	}
-	if res.MatchedCount == 0 {
-		return errors.New("order not found")
+	if !ok {
+		return apperr.NotFound("order not found")
 	}
 	return nil
 }
 
-func (r *OrderRepo) Delete(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *OrderRepo) Restore(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	res, err := r.ordersCol.DeleteOne(ctx, bson.M{"id": id})
+	ok, err := restore(ctx, r.ordersCol, id)
 	if err != nil {
 		return err
 	}
-	if res.DeletedCount == 0 {
-		return errors.New("order not found")
+	if !ok {
+		return apperr.NotFound("deleted order not found")
 	}
-
-	_, _ = r.itemsCol.DeleteMany(ctx, bson.M{"orderId": id})
 	return nil
 }
+
+func (r *OrderRepo) Purge(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ids, err := deletedBefore(ctx, r.ordersCol, before, nil)
+	if err != nil {
+		return 0, err
+	}
+	return purge(ctx, r.tx, r.ordersCol, r.itemsCol, "orderId", ids)
+}
+
+func (r *OrderRepo) BookIDs(ctx context.Context) ([]int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	values, err := r.itemsCol.Distinct(ctx, "bookId", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]int, 0, len(values))
+	for _, v := range values {
+		switch id := v.(type) {
+		case int32:
+			out = append(out, int(id))
+		case int64:
+			out = append(out, int(id))
+		}
+	}
+	return out, nil
+}
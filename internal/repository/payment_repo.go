@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PaymentRepository interface {
+	Create(ctx context.Context, p models.Payment) (models.Payment, error)
+	GetByIntent(ctx context.Context, provider, intentID string) (models.Payment, error)
+	// ListByOrder returns an order's payments, oldest first.
+	ListByOrder(ctx context.Context, orderID int) []models.Payment
+	// SetStatus moves a payment from one status to another. It fails with
+	// apperr.Stale when the payment is no longer in from.
+	SetStatus(ctx context.Context, id int, from, to, reason string) (models.Payment, error)
+
+	// EventSeen reports whether a provider's webhook event was handled
+	// already, and MarkEvent records that it has been.
+	EventSeen(ctx context.Context, provider, eventID string) (bool, error)
+	MarkEvent(ctx context.Context, provider, eventID string) error
+}
+
+type PaymentRepo struct {
+	col       *mongo.Collection
+	eventsCol *mongo.Collection
+	ids       IDGenerator
+}
+
+func NewPaymentRepo(db *mongo.Database, ids *IDs) *PaymentRepo {
+	return &PaymentRepo{
+		col:       db.Collection("payments"),
+		eventsCol: db.Collection("payment_events"),
+		ids:       ids.For("payments"),
+	}
+}
+
+func (r *PaymentRepo) Create(ctx context.Context, p models.Payment) (models.Payment, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if err := validateNewPayment(p); err != nil {
+		return models.Payment{}, err
+	}
+
+	id, err := r.ids.Next(ctx)
+	if err != nil {
+		return models.Payment{}, err
+	}
+	p.ID = id
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = p.CreatedAt
+
+	_, err = r.col.InsertOne(ctx, p)
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Payment{}, apperr.Conflict("payment intent already recorded").Wrap(err)
+	}
+	if err != nil {
+		return models.Payment{}, err
+	}
+	return p, nil
+}
+
+func validateNewPayment(p models.Payment) error {
+	if p.OrderID <= 0 {
+		return apperr.Invalid("orderId", "orderId must be positive")
+	}
+	if p.Total < 0 {
+		return apperr.Invalid("total", "total cannot be negative")
+	}
+	if p.Provider == "" || p.IntentID == "" {
+		return apperr.Invalid("intentId", "provider and intent id required")
+	}
+	return nil
+}
+
+func (r *PaymentRepo) GetByIntent(ctx context.Context, provider, intentID string) (models.Payment, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var p models.Payment
+	err := r.col.FindOne(ctx, bson.M{"provider": provider, "intentId": intentID}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return models.Payment{}, apperr.NotFound("payment not found")
+	}
+	return p, err
+}
+
+func (r *PaymentRepo) ListByOrder(ctx context.Context, orderID int) []models.Payment {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.col.Find(ctx, bson.M{"orderId": orderID}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return []models.Payment{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Payment{}
+	for cur.Next(ctx) {
+		var p models.Payment
+		if cur.Decode(&p) == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (r *PaymentRepo) SetStatus(ctx context.Context, id int, from, to, reason string) (models.Payment, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	set := bson.M{"status": to, "updatedAt": time.Now()}
+	update := bson.M{"$set": set, "$unset": bson.M{"failureReason": ""}}
+	if reason != "" {
+		set["failureReason"] = reason
+		update = bson.M{"$set": set}
+	}
+
+	var p models.Payment
+	err := r.col.FindOneAndUpdate(ctx, bson.M{"id": id, "status": from}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&p)
+	if err != mongo.ErrNoDocuments {
+		return p, err
+	}
+
+	n, err := r.col.CountDocuments(ctx, bson.M{"id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return models.Payment{}, err
+	}
+	if n > 0 {
+		return models.Payment{}, apperr.Stale("payment is no longer " + from)
+	}
+	return models.Payment{}, apperr.NotFound("payment not found")
+}
+
+func (r *PaymentRepo) EventSeen(ctx context.Context, provider, eventID string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.eventsCol.CountDocuments(ctx, bson.M{"_id": provider + ":" + eventID}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+func (r *PaymentRepo) MarkEvent(ctx context.Context, provider, eventID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.eventsCol.InsertOne(ctx, bson.M{"_id": provider + ":" + eventID, "handledAt": time.Now()})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
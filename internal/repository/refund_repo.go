@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RefundRepository interface {
+	// Create records a refund. A second refund of the same order with the
+	// same provider refund id is a conflict: it is the same payout seen
+	// twice.
+	Create(ctx context.Context, rf models.Refund) (models.Refund, error)
+	// ListByOrder returns an order's refunds, oldest first.
+	ListByOrder(ctx context.Context, orderID int) []models.Refund
+}
+
+type RefundRepo struct {
+	col *mongo.Collection
+	ids IDGenerator
+}
+
+func NewRefundRepo(db *mongo.Database, ids *IDs) *RefundRepo {
+	return &RefundRepo{
+		col: db.Collection("refunds"),
+		ids: ids.For("refunds"),
+	}
+}
+
+func (r *RefundRepo) Create(ctx context.Context, rf models.Refund) (models.Refund, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if err := validateNewRefund(rf); err != nil {
+		return models.Refund{}, err
+	}
+
+	// The unique index settles a race between two inserts; this check keeps
+	// the rule without it, on a database the migrations have not reached.
+	n, err := r.col.CountDocuments(ctx, bson.M{
+		"orderId":          rf.OrderID,
+		"providerRefundId": rf.ProviderRefundID,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return models.Refund{}, err
+	}
+	if n > 0 {
+		return models.Refund{}, apperr.Conflict("refund already recorded")
+	}
+
+	id, err := r.ids.Next(ctx)
+	if err != nil {
+		return models.Refund{}, err
+	}
+	rf.ID = id
+	rf.CreatedAt = time.Now()
+
+	_, err = r.col.InsertOne(ctx, rf)
+	if mongo.IsDuplicateKeyError(err) {
+		return models.Refund{}, apperr.Conflict("refund already recorded").Wrap(err)
+	}
+	if err != nil {
+		return models.Refund{}, err
+	}
+	return rf, nil
+}
+
+func validateNewRefund(rf models.Refund) error {
+	if rf.OrderID <= 0 {
+		return apperr.Invalid("orderId", "orderId must be positive")
+	}
+	if rf.PaymentID <= 0 {
+		return apperr.Invalid("paymentId", "paymentId must be positive")
+	}
+	if rf.ProviderRefundID == "" {
+		return apperr.Invalid("providerRefundId", "provider refund id required")
+	}
+	if rf.Amount < 0 {
+		return apperr.Invalid("amount", "amount cannot be negative")
+	}
+	if len(rf.Items) == 0 {
+		return apperr.Invalid("items", "refund must have at least one item")
+	}
+	return nil
+}
+
+func (r *RefundRepo) ListByOrder(ctx context.Context, orderID int) []models.Refund {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.col.Find(ctx, bson.M{"orderId": orderID}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return []models.Refund{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.Refund{}
+	for cur.Next(ctx) {
+		var rf models.Refund
+		if cur.Decode(&rf) == nil {
+			out = append(out, rf)
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"bookstore/internal/migrate"
+	"bookstore/internal/repository"
+	"bookstore/internal/repository/repotest"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// forEachBackend runs test once per storage backend. stores hands back a
+// fresh, empty set on every call. Mongo joins in when MONGO_TEST_URI points
+// at a server the tests may create and drop databases on.
+func forEachBackend(t *testing.T, test func(t *testing.T, stores func(t *testing.T) repository.Stores)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, func(*testing.T) repository.Stores { return repository.NewMemoryStores() })
+	})
+	t.Run("mongo", func(t *testing.T) {
+		if os.Getenv("MONGO_TEST_URI") == "" {
+			t.Skip("MONGO_TEST_URI not set")
+		}
+		test(t, mongoStores)
+	})
+}
+
+var mongoClient = sync.OnceValues(func() (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_TEST_URI")))
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		_ = client.Disconnect(context.Background())
+		return nil, err
+	}
+	return client, nil
+})
+
+// mongoStores migrates a throwaway database for one test and drops it
+// afterwards.
+func mongoStores(t *testing.T) repository.Stores {
+	t.Helper()
+	client, err := mongoClient()
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	db := client.Database(fmt.Sprintf("bookstore_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() { _ = db.Drop(context.Background()) })
+	if _, err := migrate.Run(t.Context(), db, migrate.All); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return repository.NewMongoStores(db, repository.CounterIDs(db))
+}
+
+func TestBooks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Books(t, func(t *testing.T) repository.BookRepository { return stores(t).Books })
+	})
+}
+
+func TestUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Users(t, func(t *testing.T) repository.UserRepository { return stores(t).Users })
+	})
+}
+
+func TestCarts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Carts(t, func(t *testing.T) repository.CartRepository { return stores(t).Carts })
+	})
+}
+
+func TestWishlists(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Wishlists(t, func(t *testing.T) repository.WishlistRepository { return stores(t).Wishlists })
+	})
+}
+
+func TestOrders(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Orders(t, func(t *testing.T) repository.OrderRepository { return stores(t).Orders })
+	})
+}
+
+func TestInventory(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Inventory(t, func(t *testing.T) (repository.BookRepository, repository.InventoryRepository) {
+			s := stores(t)
+			return s.Books, s.Inventory
+		})
+	})
+}
+
+func TestPayments(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Payments(t, func(t *testing.T) repository.PaymentRepository { return stores(t).Payments })
+	})
+}
+
+func TestRefunds(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Refunds(t, func(t *testing.T) repository.RefundRepository { return stores(t).Refunds })
+	})
+}
+
+func TestIdempotency(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, stores func(t *testing.T) repository.Stores) {
+		repotest.Idempotency(t, func(t *testing.T) repository.IdempotencyRepository { return stores(t).Idempotency })
+	})
+}
@@ -0,0 +1,201 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Books(t *testing.T, newRepo func(t *testing.T) repository.BookRepository) {
+	ctx := t.Context()
+
+	t.Run("NotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.GetByID(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("GetByID on missing book: err = %v, want not found", err)
+		}
+		if _, err := r.Update(ctx, models.Book{ID: 999, Title: "x"}); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Update on missing book: err = %v, want not found", err)
+		}
+		if err := r.Delete(ctx, 999, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Delete on missing book: err = %v, want not found", err)
+		}
+		if err := r.Restore(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Restore on missing book: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("CreateAssignsIDs", func(t *testing.T) {
+		r := newRepo(t)
+		a := mustBook(t, r, models.Book{ID: 42, Title: "A"})
+		b := mustBook(t, r, models.Book{Title: "B"})
+		if a.ID <= 0 || b.ID <= 0 || a.ID == b.ID {
+			t.Fatalf("ids = %d, %d; want distinct positive", a.ID, b.ID)
+		}
+		got, err := r.GetByID(ctx, a.ID)
+		if err != nil || got.Title != "A" {
+			t.Fatalf("GetByID(%d) = %+v, %v", a.ID, got, err)
+		}
+		if n := len(r.GetAll(ctx)); n != 2 {
+			t.Fatalf("GetAll returned %d books, want 2", n)
+		}
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		r := newRepo(t)
+		b := mustBook(t, r, models.Book{Title: "Old", Price: 1})
+		b.Title, b.Price = "New", 2
+		if _, err := r.Update(ctx, b); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		got, _ := r.GetByID(ctx, b.ID)
+		if got.Title != "New" || got.Price != 2 {
+			t.Fatalf("after Update got %+v", got)
+		}
+		if err := r.Delete(ctx, b.ID, 1); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := r.GetByID(ctx, b.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("GetByID after Delete: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		r := newRepo(t)
+		b := mustBook(t, r, models.Book{Title: "A", Author: "X"})
+		if b.Version != 1 {
+			t.Fatalf("Create version = %d, want 1", b.Version)
+		}
+
+		b.Title = "B"
+		first, err := r.Update(ctx, b)
+		if err != nil || first.Version != 2 || first.Title != "B" {
+			t.Fatalf("Update = %+v, %v; want title B at version 2", first, err)
+		}
+
+		b.Title = "C"
+		if _, err := r.Update(ctx, b); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("Update with old version: err = %v, want stale", err)
+		}
+		if got, _ := r.GetByID(ctx, b.ID); got.Title != "B" || got.Version != 2 {
+			t.Fatalf("after stale Update got %+v", got)
+		}
+
+		b.Version = 0
+		blind, err := r.Update(ctx, b)
+		if err != nil || blind.Version != 3 || blind.Title != "C" {
+			t.Fatalf("Update without version = %+v, %v; want title C at version 3", blind, err)
+		}
+
+		if _, err := r.Update(ctx, models.Book{ID: 999, Version: 1}); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("versioned Update on missing book: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("SoftDeleteAndRestore", func(t *testing.T) {
+		r := newRepo(t)
+		a := mustBook(t, r, models.Book{Title: "A", Genre: "g"})
+		b := mustBook(t, r, models.Book{Title: "B", Genre: "g"})
+
+		if err := r.Restore(ctx, a.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Restore on live book: err = %v, want not found", err)
+		}
+		if err := r.Delete(ctx, a.ID, 7); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if err := r.Delete(ctx, a.ID, 7); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("second Delete: err = %v, want not found", err)
+		}
+		if _, err := r.Update(ctx, a); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Update on deleted book: err = %v, want not found", err)
+		}
+		if g := ids(r.GetAll(ctx), func(b models.Book) int { return b.ID }); !sameInts(g, []int{b.ID}) {
+			t.Fatalf("GetAll after Delete = %v, want %v", g, []int{b.ID})
+		}
+		found, _ := r.Find(ctx, models.BookQuery{Genre: "g"})
+		if g := ids(found, func(b models.Book) int { return b.ID }); !sameInts(g, []int{b.ID}) {
+			t.Fatalf("Find after Delete = %v, want %v", g, []int{b.ID})
+		}
+
+		hist, err := r.FindByIDs(ctx, []int{a.ID, b.ID})
+		if err != nil || len(hist) != 2 {
+			t.Fatalf("FindByIDs = %+v, %v; want both books", hist, err)
+		}
+		if hist[0].DeletedAt == nil || hist[0].DeletedBy != 7 {
+			t.Fatalf("deleted book = %+v, want deletedAt and deletedBy 7", hist[0])
+		}
+
+		if err := r.Restore(ctx, a.ID); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		got, err := r.GetByID(ctx, a.ID)
+		if err != nil || got.DeletedAt != nil || got.DeletedBy != 0 {
+			t.Fatalf("GetByID after Restore = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		r := newRepo(t)
+		a := mustBook(t, r, models.Book{Title: "A"})
+		b := mustBook(t, r, models.Book{Title: "B"})
+		c := mustBook(t, r, models.Book{Title: "C"})
+		_ = r.Delete(ctx, a.ID, 1)
+		_ = r.Delete(ctx, b.ID, 1)
+
+		if n, err := r.Purge(ctx, time.Now().Add(-time.Hour), nil); err != nil || n != 0 {
+			t.Fatalf("Purge with early cutoff = %d, %v; want 0", n, err)
+		}
+		if n, err := r.Purge(ctx, time.Now().Add(time.Hour), []int{b.ID}); err != nil || n != 1 {
+			t.Fatalf("Purge = %d, %v; want 1", n, err)
+		}
+		left, _ := r.FindByIDs(ctx, []int{a.ID, b.ID, c.ID})
+		if g := ids(left, func(b models.Book) int { return b.ID }); !sameInts(g, []int{b.ID, c.ID}) {
+			t.Fatalf("books after Purge = %v, want %v", g, []int{b.ID, c.ID})
+		}
+	})
+
+	t.Run("Find", func(t *testing.T) {
+		r := newRepo(t)
+		dune := mustBook(t, r, models.Book{Title: "Dune", Author: "Frank Herbert", Genre: "scifi", Price: 20})
+		emma := mustBook(t, r, models.Book{Title: "Emma", Author: "Jane Austen", Genre: "classic", Price: 8})
+		found := mustBook(t, r, models.Book{Title: "Foundation", Author: "Isaac Asimov", Genre: "scifi", Price: 12})
+		pers := mustBook(t, r, models.Book{Title: "Persuasion", Author: "Jane Austen", Genre: "classic", Price: 12})
+
+		cases := []struct {
+			name string
+			q    models.BookQuery
+			want []int
+		}{
+			{"All", models.BookQuery{}, []int{dune.ID, emma.ID, found.ID, pers.ID}},
+			{"Genre", models.BookQuery{Genre: "scifi"}, []int{dune.ID, found.ID}},
+			{"SearchTitleIgnoresCase", models.BookQuery{Search: "dUNE"}, []int{dune.ID}},
+			{"SearchAuthor", models.BookQuery{Search: "austen"}, []int{emma.ID, pers.ID}},
+			{"MinPrice", models.BookQuery{MinPrice: ptr(12.0)}, []int{dune.ID, found.ID, pers.ID}},
+			{"MaxPrice", models.BookQuery{MaxPrice: ptr(12.0)}, []int{emma.ID, found.ID, pers.ID}},
+			{"PriceRange", models.BookQuery{MinPrice: ptr(9.0), MaxPrice: ptr(15.0)}, []int{found.ID, pers.ID}},
+			{"SortPriceAscTiesByID", models.BookQuery{SortBy: "price"}, []int{emma.ID, found.ID, pers.ID, dune.ID}},
+			{"SortPriceDescTiesByID", models.BookQuery{SortBy: "price", Order: "desc"}, []int{dune.ID, found.ID, pers.ID, emma.ID}},
+			{"SortTitle", models.BookQuery{SortBy: "title", Order: "desc"}, []int{pers.ID, found.ID, emma.ID, dune.ID}},
+			{"Combined", models.BookQuery{Genre: "classic", SortBy: "price", Order: "desc"}, []int{pers.ID, emma.ID}},
+			{"NoMatch", models.BookQuery{Genre: "horror"}, []int{}},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				got, err := r.Find(ctx, tc.q)
+				if err != nil {
+					t.Fatalf("Find: %v", err)
+				}
+				if got == nil {
+					t.Fatal("Find returned nil slice, want empty")
+				}
+				if g := ids(got, func(b models.Book) int { return b.ID }); !sameInts(g, tc.want) {
+					t.Fatalf("Find(%+v) = %v, want %v", tc.q, g, tc.want)
+				}
+			})
+		}
+	})
+}
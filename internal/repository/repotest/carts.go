@@ -0,0 +1,188 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Carts(t *testing.T, newRepo func(t *testing.T) repository.CartRepository) {
+	ctx := t.Context()
+
+	newCart := func(t *testing.T, r repository.CartRepository, customerID int) models.Cart {
+		t.Helper()
+		c, err := r.Create(ctx, customerID)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return c
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, _, err := r.GetByID(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("GetByID on missing cart: err = %v, want not found", err)
+		}
+		if _, err := r.Update(ctx, models.Cart{ID: 999, CustomerID: 1}); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Update on missing cart: err = %v, want not found", err)
+		}
+		if err := r.Delete(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Delete on missing cart: err = %v, want not found", err)
+		}
+		if _, err := r.AddItem(ctx, 999, 1, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("AddItem on missing cart: err = %v, want not found", err)
+		}
+		if err := r.ClearCart(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("ClearCart on missing cart: err = %v, want not found", err)
+		}
+
+		c := newCart(t, r, 1)
+		if err := r.UpdateItem(ctx, c.ID, 999, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("UpdateItem on missing item: err = %v, want not found", err)
+		}
+		if err := r.DeleteItem(ctx, c.ID, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("DeleteItem on missing item: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("CreateAssignsIDs", func(t *testing.T) {
+		r := newRepo(t)
+		a := newCart(t, r, 1)
+		b := newCart(t, r, 2)
+		if a.ID <= 0 || b.ID <= 0 || a.ID == b.ID {
+			t.Fatalf("ids = %d, %d; want distinct positive", a.ID, b.ID)
+		}
+		got, items, err := r.GetByID(ctx, b.ID)
+		if err != nil || got.CustomerID != 2 {
+			t.Fatalf("GetByID(%d) = %+v, %v", b.ID, got, err)
+		}
+		if items == nil || len(items) != 0 {
+			t.Fatalf("new cart items = %v, want empty", items)
+		}
+		if g := ids(r.GetAll(ctx), func(c models.Cart) int { return c.ID }); !sameInts(g, []int{a.ID, b.ID}) {
+			t.Fatalf("GetAll ids = %v, want [%d %d]", g, a.ID, b.ID)
+		}
+	})
+
+	t.Run("AddItemMergesSameBook", func(t *testing.T) {
+		r := newRepo(t)
+		c := newCart(t, r, 1)
+
+		first, err := r.AddItem(ctx, c.ID, 7, 2)
+		if err != nil {
+			t.Fatalf("AddItem: %v", err)
+		}
+		second, err := r.AddItem(ctx, c.ID, 7, 3)
+		if err != nil {
+			t.Fatalf("AddItem again: %v", err)
+		}
+		if second.ID != first.ID || second.Qty != 5 {
+			t.Fatalf("merged item = %+v, want id %d qty 5", second, first.ID)
+		}
+
+		other, err := r.AddItem(ctx, c.ID, 8, 1)
+		if err != nil {
+			t.Fatalf("AddItem other book: %v", err)
+		}
+		if other.ID == first.ID || other.CartID != c.ID {
+			t.Fatalf("other item = %+v", other)
+		}
+
+		_, items, _ := r.GetByID(ctx, c.ID)
+		if len(items) != 2 {
+			t.Fatalf("cart has %d items, want 2", len(items))
+		}
+	})
+
+	t.Run("AddItemRejectsBadQty", func(t *testing.T) {
+		r := newRepo(t)
+		c := newCart(t, r, 1)
+		if _, err := r.AddItem(ctx, c.ID, 1, 0); !errors.Is(err, apperr.ErrValidation) {
+			t.Errorf("AddItem qty 0: err = %v, want validation error", err)
+		}
+		if _, err := r.AddItem(ctx, c.ID, 1, -1); !errors.Is(err, apperr.ErrValidation) {
+			t.Errorf("AddItem qty -1: err = %v, want validation error", err)
+		}
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		r := newRepo(t)
+		c := newCart(t, r, 1)
+		if c.Version != 1 {
+			t.Fatalf("Create version = %d, want 1", c.Version)
+		}
+
+		c.CustomerID = 2
+		updated, err := r.Update(ctx, c)
+		if err != nil || updated.Version != 2 || updated.CustomerID != 2 {
+			t.Fatalf("Update = %+v, %v; want customer 2 at version 2", updated, err)
+		}
+
+		c.CustomerID = 3
+		if _, err := r.Update(ctx, c); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("Update with old version: err = %v, want stale", err)
+		}
+	})
+
+	t.Run("UpdateAndDeleteItem", func(t *testing.T) {
+		r := newRepo(t)
+		c := newCart(t, r, 1)
+		it, _ := r.AddItem(ctx, c.ID, 1, 1)
+
+		if err := r.UpdateItem(ctx, c.ID, it.ID, 0); !errors.Is(err, apperr.ErrValidation) {
+			t.Errorf("UpdateItem qty 0: err = %v, want validation error", err)
+		}
+		if err := r.UpdateItem(ctx, c.ID, it.ID, 4); err != nil {
+			t.Fatalf("UpdateItem: %v", err)
+		}
+		_, items, _ := r.GetByID(ctx, c.ID)
+		if len(items) != 1 || items[0].Qty != 4 {
+			t.Fatalf("after UpdateItem items = %+v", items)
+		}
+
+		other := newCart(t, r, 2)
+		if err := r.DeleteItem(ctx, other.ID, it.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("DeleteItem through another cart: err = %v, want not found", err)
+		}
+		if err := r.DeleteItem(ctx, c.ID, it.ID); err != nil {
+			t.Fatalf("DeleteItem: %v", err)
+		}
+		_, items, _ = r.GetByID(ctx, c.ID)
+		if len(items) != 0 {
+			t.Fatalf("after DeleteItem items = %+v", items)
+		}
+	})
+
+	t.Run("ClearCart", func(t *testing.T) {
+		r := newRepo(t)
+		c := newCart(t, r, 1)
+		_, _ = r.AddItem(ctx, c.ID, 1, 1)
+		_, _ = r.AddItem(ctx, c.ID, 2, 1)
+
+		if err := r.ClearCart(ctx, c.ID); err != nil {
+			t.Fatalf("ClearCart: %v", err)
+		}
+		_, items, err := r.GetByID(ctx, c.ID)
+		if err != nil {
+			t.Fatalf("cart gone after ClearCart: %v", err)
+		}
+		if len(items) != 0 {
+			t.Fatalf("after ClearCart items = %+v", items)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		r := newRepo(t)
+		c := newCart(t, r, 1)
+		_, _ = r.AddItem(ctx, c.ID, 1, 1)
+		if err := r.Delete(ctx, c.ID); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, _, err := r.GetByID(ctx, c.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("GetByID after Delete: err = %v, want not found", err)
+		}
+	})
+}
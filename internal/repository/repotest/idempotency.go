@@ -0,0 +1,128 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Idempotency(t *testing.T, newRepo func(t *testing.T) repository.IdempotencyRepository) {
+	ctx := t.Context()
+
+	claim := func(key, fp string, at time.Time) models.IdempotencyRecord {
+		return models.IdempotencyRecord{
+			Key:         key,
+			UserID:      1,
+			Fingerprint: fp,
+			CreatedAt:   at,
+			ExpiresAt:   at.Add(time.Minute),
+		}
+	}
+
+	t.Run("ClaimOnce", func(t *testing.T) {
+		r := newRepo(t)
+		now := time.Now()
+		if _, ok, err := r.Claim(ctx, claim("k", "a", now)); err != nil || !ok {
+			t.Fatalf("first Claim = %v, %v; want claimed", ok, err)
+		}
+		got, ok, err := r.Claim(ctx, claim("k", "b", now))
+		if err != nil || ok {
+			t.Fatalf("second Claim = %v, %v; want the existing record", ok, err)
+		}
+		if got.Fingerprint != "a" || got.Status != 0 {
+			t.Fatalf("existing record = %+v, want fingerprint a and no status", got)
+		}
+		if _, ok, _ := r.Claim(ctx, claim("other", "a", now)); !ok {
+			t.Fatal("Claim of another key: want claimed")
+		}
+	})
+
+	t.Run("ClaimValidates", func(t *testing.T) {
+		r := newRepo(t)
+		if _, _, err := r.Claim(ctx, claim("", "a", time.Now())); !errors.Is(err, apperr.ErrValidation) {
+			t.Fatalf("Claim without a key: err = %v, want validation error", err)
+		}
+	})
+
+	t.Run("ExpiredKeysAreFree", func(t *testing.T) {
+		r := newRepo(t)
+		now := time.Now()
+		r.Claim(ctx, claim("k", "a", now))
+		if _, ok, err := r.Claim(ctx, claim("k", "b", now.Add(2*time.Minute))); err != nil || !ok {
+			t.Fatalf("Claim after expiry = %v, %v; want claimed", ok, err)
+		}
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		r := newRepo(t)
+		now := time.Now()
+		rec, _, _ := r.Claim(ctx, claim("k", "a", now))
+		rec.Status = 201
+		rec.ContentType = "application/json"
+		rec.Body = []byte(`{"id":1}`)
+		rec.ExpiresAt = now.Add(time.Hour)
+		if err := r.Complete(ctx, rec); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+
+		got, ok, _ := r.Claim(ctx, claim("k", "a", now.Add(30*time.Minute)))
+		if ok || got.Status != 201 || string(got.Body) != `{"id":1}` || got.ContentType != "application/json" {
+			t.Fatalf("Claim after Complete = %+v, %v; want the stored response", got, ok)
+		}
+
+		if err := r.Complete(ctx, claim("missing", "a", now)); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("Complete of an unknown key = %v, want not found", err)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		r := newRepo(t)
+		now := time.Now()
+		pending, _, _ := r.Claim(ctx, claim("pending", "a", now))
+		if err := r.Release(ctx, pending); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		if _, ok, _ := r.Claim(ctx, claim("pending", "b", now)); !ok {
+			t.Fatal("Claim after Release: want claimed")
+		}
+
+		done, _, _ := r.Claim(ctx, claim("done", "a", now))
+		done.Status = 200
+		r.Complete(ctx, done)
+		r.Release(ctx, done)
+		if _, ok, _ := r.Claim(ctx, claim("done", "a", now)); ok {
+			t.Fatal("Release dropped a completed record")
+		}
+	})
+
+	t.Run("ExpiredClaimCannotTouchTheNextOne", func(t *testing.T) {
+		r := newRepo(t)
+		now := time.Now()
+		stale, _, _ := r.Claim(ctx, claim("k", "a", now))
+		retry, ok, _ := r.Claim(ctx, claim("k", "a", now.Add(2*time.Minute)))
+		if !ok {
+			t.Fatal("Claim after expiry: want claimed")
+		}
+
+		stale.Status = 500
+		if err := r.Complete(ctx, stale); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("Complete of the expired claim = %v, want not found", err)
+		}
+		if err := r.Release(ctx, stale); err != nil {
+			t.Fatalf("Release of the expired claim: %v", err)
+		}
+		got, ok, _ := r.Claim(ctx, claim("k", "a", now.Add(150*time.Second)))
+		if ok || got.Status != 0 || !got.CreatedAt.Equal(retry.CreatedAt) {
+			t.Fatalf("record after the expired claim finished = %+v, %v; want the retry's pending claim", got, ok)
+		}
+
+		retry.Status = 201
+		if err := r.Complete(ctx, retry); err != nil {
+			t.Fatalf("Complete of the current claim: %v", err)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+// Inventory checks an InventoryRepository against the BookRepository whose
+// stock it manages; newRepos must return a pair sharing the same books.
+func Inventory(t *testing.T, newRepos func(t *testing.T) (repository.BookRepository, repository.InventoryRepository)) {
+	ctx := t.Context()
+	later := time.Now().Add(time.Hour)
+
+	stockOf := func(t *testing.T, books repository.BookRepository, id int) (int, int) {
+		t.Helper()
+		b, err := books.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("GetByID(%d): %v", id, err)
+		}
+		if b.Stock == nil {
+			t.Fatalf("book %d stock is untracked", id)
+		}
+		return *b.Stock, b.Reserved
+	}
+
+	t.Run("UntrackedIsUnlimited", func(t *testing.T) {
+		books, inv := newRepos(t)
+		b := mustBook(t, books, models.Book{Title: "T", Author: "A"})
+
+		if err := inv.Reserve(ctx, 1, b.ID, 50, later); err != nil {
+			t.Fatalf("Reserve on untracked book: %v", err)
+		}
+		taken, err := inv.Take(ctx, 1, []models.OrderItem{{BookID: b.ID, Qty: 50}})
+		if err != nil || len(taken) != 0 {
+			t.Fatalf("Take on untracked book = %v, %v; want no movements", taken, err)
+		}
+		got, _ := books.GetByID(ctx, b.ID)
+		if got.Stock != nil {
+			t.Fatalf("stock = %d, want untracked", *got.Stock)
+		}
+	})
+
+	t.Run("ReserveLimitsOthers", func(t *testing.T) {
+		books, inv := newRepos(t)
+		b := mustBook(t, books, models.Book{Title: "T", Author: "A", Stock: ptr(3)})
+
+		if err := inv.Reserve(ctx, 1, b.ID, 2, later); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+		if _, r := stockOf(t, books, b.ID); r != 2 {
+			t.Fatalf("reserved = %d, want 2", r)
+		}
+		if err := inv.Reserve(ctx, 2, b.ID, 2, later); !errors.Is(err, apperr.ErrOutOfStock) {
+			t.Fatalf("Reserve past stock: err = %v, want ErrOutOfStock", err)
+		}
+		// Raising your own hold only needs the difference.
+		if err := inv.Reserve(ctx, 1, b.ID, 3, later); err != nil {
+			t.Fatalf("Reserve raise: %v", err)
+		}
+		if err := inv.Reserve(ctx, 1, b.ID, 0, later); err != nil {
+			t.Fatalf("Reserve drop: %v", err)
+		}
+		if s, r := stockOf(t, books, b.ID); s != 3 || r != 0 {
+			t.Fatalf("stock, reserved = %d, %d; want 3, 0", s, r)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		books, inv := newRepos(t)
+		b := mustBook(t, books, models.Book{Title: "T", Author: "A", Stock: ptr(10)})
+
+		if err := inv.Reserve(ctx, 1, b.ID, 2, time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("Reserve expired: %v", err)
+		}
+		if err := inv.Reserve(ctx, 2, b.ID, 3, later); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+		if err := inv.Reserve(ctx, 3, b.ID, 4, later); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+
+		n, err := inv.ReleaseExpired(ctx, time.Now())
+		if err != nil || n != 1 {
+			t.Fatalf("ReleaseExpired = %d, %v; want 1", n, err)
+		}
+		if _, r := stockOf(t, books, b.ID); r != 7 {
+			t.Fatalf("reserved = %d, want 7", r)
+		}
+		if err := inv.ReleaseCart(ctx, 2); err != nil {
+			t.Fatalf("ReleaseCart: %v", err)
+		}
+		if s, r := stockOf(t, books, b.ID); s != 10 || r != 4 {
+			t.Fatalf("stock, reserved = %d, %d; want 10, 4", s, r)
+		}
+	})
+
+	t.Run("TakeIsAllOrNothing", func(t *testing.T) {
+		books, inv := newRepos(t)
+		a := mustBook(t, books, models.Book{Title: "A", Author: "A", Stock: ptr(5)})
+		b := mustBook(t, books, models.Book{Title: "B", Author: "B", Stock: ptr(1)})
+
+		_, err := inv.Take(ctx, 0, []models.OrderItem{{BookID: a.ID, Qty: 2}, {BookID: b.ID, Qty: 2}})
+		if !errors.Is(err, apperr.ErrOutOfStock) {
+			t.Fatalf("Take short: err = %v, want ErrOutOfStock", err)
+		}
+		if s, _ := stockOf(t, books, a.ID); s != 5 {
+			t.Fatalf("stock after failed Take = %d, want 5", s)
+		}
+
+		taken, err := inv.Take(ctx, 0, []models.OrderItem{{BookID: a.ID, Qty: 2}, {BookID: b.ID, Qty: 1}})
+		if err != nil || len(taken) != 2 {
+			t.Fatalf("Take = %v, %v; want 2 movements", taken, err)
+		}
+		if taken[0].Delta != -2 || taken[0].StockAfter != 3 || taken[0].Reason != models.MovementOrder {
+			t.Fatalf("movement = %+v, want -2 leaving 3", taken[0])
+		}
+		if s, _ := stockOf(t, books, b.ID); s != 0 {
+			t.Fatalf("stock = %d, want 0", s)
+		}
+	})
+
+	t.Run("TakeUsesOwnHold", func(t *testing.T) {
+		books, inv := newRepos(t)
+		b := mustBook(t, books, models.Book{Title: "T", Author: "A", Stock: ptr(2)})
+
+		if err := inv.Reserve(ctx, 1, b.ID, 2, later); err != nil {
+			t.Fatalf("Reserve: %v", err)
+		}
+		if _, err := inv.Take(ctx, 2, []models.OrderItem{{BookID: b.ID, Qty: 1}}); !errors.Is(err, apperr.ErrOutOfStock) {
+			t.Fatalf("Take by other cart: err = %v, want ErrOutOfStock", err)
+		}
+		if _, err := inv.Take(ctx, 1, []models.OrderItem{{BookID: b.ID, Qty: 2}}); err != nil {
+			t.Fatalf("Take by holder: %v", err)
+		}
+		if s, r := stockOf(t, books, b.ID); s != 0 || r != 0 {
+			t.Fatalf("stock, reserved = %d, %d; want 0, 0", s, r)
+		}
+	})
+
+	t.Run("RestockAndAdjust", func(t *testing.T) {
+		books, inv := newRepos(t)
+		b := mustBook(t, books, models.Book{Title: "T", Author: "A"})
+
+		m, err := inv.Adjust(ctx, b.ID, 4)
+		if err != nil || m.StockAfter != 4 || m.Reason != models.MovementAdjustment {
+			t.Fatalf("Adjust untracked = %+v, %v; want stock 4", m, err)
+		}
+		if _, err := inv.Adjust(ctx, b.ID, -5); !errors.Is(err, apperr.ErrValidation) {
+			t.Fatalf("Adjust below zero: err = %v, want validation error", err)
+		}
+		if _, err := inv.Adjust(ctx, 999, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("Adjust missing book: err = %v, want ErrNotFound", err)
+		}
+		if _, err := inv.Restock(ctx, []models.OrderItem{{BookID: b.ID, Qty: 3}}); err != nil {
+			t.Fatalf("Restock: %v", err)
+		}
+		if s, _ := stockOf(t, books, b.ID); s != 7 {
+			t.Fatalf("stock = %d, want 7", s)
+		}
+	})
+
+	t.Run("Movements", func(t *testing.T) {
+		_, inv := newRepos(t)
+		recorded, err := inv.Record(ctx,
+			models.StockMovement{BookID: 1, Delta: 5, Reason: models.MovementInitial},
+			models.StockMovement{BookID: 2, Delta: 1, Reason: models.MovementAdjustment},
+			models.StockMovement{BookID: 1, Delta: -1, Reason: models.MovementOrder, OrderID: 9},
+		)
+		if err != nil || len(recorded) != 3 || recorded[2].OrderID != 9 {
+			t.Fatalf("Record = %+v, %v", recorded, err)
+		}
+
+		got := inv.Movements(ctx, 1, 0)
+		if len(got) != 2 || got[0].Reason != models.MovementOrder || got[1].Reason != models.MovementInitial {
+			t.Fatalf("Movements(1) = %+v, want newest first", got)
+		}
+		if got[0].ID <= 0 || got[0].ID == got[1].ID || got[0].CreatedAt.IsZero() {
+			t.Fatalf("recorded movements lack ids or times: %+v", got)
+		}
+		if got := inv.Movements(ctx, 1, 1); len(got) != 1 {
+			t.Fatalf("Movements limit 1 = %d entries", len(got))
+		}
+	})
+}
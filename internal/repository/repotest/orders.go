@@ -0,0 +1,247 @@
+package repotest
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Orders(t *testing.T, newRepo func(t *testing.T) repository.OrderRepository) {
+	ctx := t.Context()
+
+	validOrder := models.Order{CustomerID: 1, CartID: 1, Total: 30}
+	validItems := []models.OrderItem{
+		{BookID: 1, Qty: 1, Price: 10},
+		{BookID: 2, Qty: 2, Price: 10},
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, _, err := r.GetByID(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("GetByID on missing order: err = %v, want not found", err)
+		}
+		if _, err := r.Update(ctx, models.Order{ID: 999, CustomerID: 1, CartID: 1}); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Update on missing order: err = %v, want not found", err)
+		}
+		if err := r.Delete(ctx, 999, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Delete on missing order: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("CreateValidates", func(t *testing.T) {
+		cases := []struct {
+			name  string
+			order models.Order
+			items []models.OrderItem
+		}{
+			{"NoCustomer", models.Order{CartID: 1}, validItems},
+			{"NoCart", models.Order{CustomerID: 1}, validItems},
+			{"NoItems", validOrder, nil},
+			{"NegativeTotal", models.Order{CustomerID: 1, CartID: 1, Total: -1}, validItems},
+			{"BadBook", validOrder, []models.OrderItem{{BookID: 0, Qty: 1}}},
+			{"BadQty", validOrder, []models.OrderItem{{BookID: 1, Qty: 0}}},
+			{"NegativePrice", validOrder, []models.OrderItem{{BookID: 1, Qty: 1, Price: -1}}},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				r := newRepo(t)
+				if _, _, err := r.Create(ctx, tc.order, tc.items); !errors.Is(err, apperr.ErrValidation) {
+					t.Fatalf("Create: err = %v, want validation error", err)
+				}
+				// A rejected order must not leave anything behind.
+				if n := len(r.GetAll(ctx)); n != 0 {
+					t.Fatalf("GetAll after failed Create returned %d orders", n)
+				}
+			})
+		}
+	})
+
+	t.Run("CreateAssignsIDs", func(t *testing.T) {
+		r := newRepo(t)
+		o, items, err := r.Create(ctx, validOrder, validItems)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if o.ID <= 0 {
+			t.Fatalf("order id = %d", o.ID)
+		}
+		if len(items) != len(validItems) {
+			t.Fatalf("got %d items, want %d", len(items), len(validItems))
+		}
+		seen := map[int]bool{}
+		for _, it := range items {
+			if it.ID <= 0 || seen[it.ID] {
+				t.Fatalf("item ids not distinct positive: %+v", items)
+			}
+			seen[it.ID] = true
+			if it.OrderID != o.ID {
+				t.Fatalf("item %d orderId = %d, want %d", it.ID, it.OrderID, o.ID)
+			}
+		}
+
+		o2, _, err := r.Create(ctx, validOrder, validItems[:1])
+		if err != nil {
+			t.Fatalf("second Create: %v", err)
+		}
+		if o2.ID == o.ID {
+			t.Fatalf("second order reused id %d", o.ID)
+		}
+
+		got, gotItems, err := r.GetByID(ctx, o.ID)
+		if err != nil || got.Total != validOrder.Total || len(gotItems) != len(validItems) {
+			t.Fatalf("GetByID = %+v, %d items, %v", got, len(gotItems), err)
+		}
+	})
+
+	t.Run("UpdateAndDelete", func(t *testing.T) {
+		r := newRepo(t)
+		o, _, _ := r.Create(ctx, validOrder, validItems)
+
+		o.Total = 99
+		if _, err := r.Update(ctx, o); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		got, _, _ := r.GetByID(ctx, o.ID)
+		if got.Total != 99 {
+			t.Fatalf("after Update total = %v", got.Total)
+		}
+
+		if err := r.Delete(ctx, o.ID, 1); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, _, err := r.GetByID(ctx, o.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("GetByID after Delete: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("Versions", func(t *testing.T) {
+		r := newRepo(t)
+		o, _, _ := r.Create(ctx, validOrder, validItems)
+		if o.Version != 1 {
+			t.Fatalf("Create version = %d, want 1", o.Version)
+		}
+
+		o.Total = 50
+		updated, err := r.Update(ctx, o)
+		if err != nil || updated.Version != 2 || updated.Total != 50 {
+			t.Fatalf("Update = %+v, %v; want total 50 at version 2", updated, err)
+		}
+
+		o.Total = 60
+		if _, err := r.Update(ctx, o); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("Update with old version: err = %v, want stale", err)
+		}
+		if got, _, _ := r.GetByID(ctx, o.ID); got.Total != 50 {
+			t.Fatalf("after stale Update total = %v, want 50", got.Total)
+		}
+	})
+
+	t.Run("Claim", func(t *testing.T) {
+		r := newRepo(t)
+		o, _, _ := r.Create(ctx, validOrder, validItems)
+
+		claimed, err := r.Claim(ctx, o.ID, o.Version)
+		if err != nil || claimed.Version != o.Version+1 || claimed.Total != o.Total {
+			t.Fatalf("Claim = %+v, %v; want the same order at version %d", claimed, err, o.Version+1)
+		}
+		if _, err := r.Claim(ctx, o.ID, o.Version); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("second Claim at the old version: err = %v, want stale", err)
+		}
+		if _, err := r.Update(ctx, o); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("Update at the claimed version: err = %v, want stale", err)
+		}
+	})
+
+	t.Run("Transition", func(t *testing.T) {
+		r := newRepo(t)
+		o, _, _ := r.Create(ctx, validOrder, validItems)
+		if o.Status != models.OrderPending || len(o.History) != 1 || o.History[0].To != models.OrderPending {
+			t.Fatalf("Create status = %q, history %+v; want pending", o.Status, o.History)
+		}
+
+		paid := models.StatusChange{From: models.OrderPending, To: models.OrderPaid, At: time.Now(), By: 7}
+		got, err := r.Transition(ctx, o.ID, o.Version, paid)
+		if err != nil || got.Status != models.OrderPaid || got.Version != o.Version+1 {
+			t.Fatalf("Transition = %+v, %v; want paid at version %d", got, err, o.Version+1)
+		}
+		if len(got.History) != 2 || got.History[1].By != 7 || got.History[1].From != models.OrderPending {
+			t.Fatalf("history = %+v, want the change appended", got.History)
+		}
+
+		// The order has moved on, so the same change no longer applies.
+		if _, err := r.Transition(ctx, o.ID, 0, paid); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("Transition from old status: err = %v, want stale", err)
+		}
+		packed := models.StatusChange{From: models.OrderPaid, To: models.OrderPacked, At: time.Now()}
+		if _, err := r.Transition(ctx, o.ID, o.Version, packed); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("Transition with old version: err = %v, want stale", err)
+		}
+		if _, err := r.Transition(ctx, 999, 0, packed); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("Transition on missing order: err = %v, want not found", err)
+		}
+
+		// Updates leave the status alone.
+		got.Total = 40
+		if updated, err := r.Update(ctx, got); err != nil || updated.Status != models.OrderPaid {
+			t.Fatalf("Update = %+v, %v; want status kept", updated, err)
+		}
+	})
+
+	t.Run("SoftDeleteAndRestore", func(t *testing.T) {
+		r := newRepo(t)
+		o, items, _ := r.Create(ctx, validOrder, validItems)
+
+		if err := r.Delete(ctx, o.ID, 7); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if n := len(r.GetAll(ctx)); n != 0 {
+			t.Fatalf("GetAll after Delete returned %d orders, want 0", n)
+		}
+		if _, err := r.Update(ctx, o); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Update on deleted order: err = %v, want not found", err)
+		}
+
+		if err := r.Restore(ctx, o.ID); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		if err := r.Restore(ctx, o.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("second Restore: err = %v, want not found", err)
+		}
+		got, gotItems, err := r.GetByID(ctx, o.ID)
+		if err != nil || got.DeletedAt != nil || len(gotItems) != len(items) {
+			t.Fatalf("GetByID after Restore = %+v, %d items, %v", got, len(gotItems), err)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		r := newRepo(t)
+		a, _, _ := r.Create(ctx, validOrder, validItems)
+		b, _, _ := r.Create(ctx, validOrder, []models.OrderItem{{BookID: 99, Qty: 1, Price: 1}})
+		_ = r.Delete(ctx, b.ID, 1)
+
+		if n, err := r.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+			t.Fatalf("Purge with early cutoff = %d, %v; want 0", n, err)
+		}
+		if bookIDs, _ := r.BookIDs(ctx); !slices.Contains(bookIDs, 99) {
+			t.Fatalf("BookIDs = %v, want deleted order's book 99 included", bookIDs)
+		}
+
+		if n, err := r.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+			t.Fatalf("Purge = %d, %v; want 1", n, err)
+		}
+		if err := r.Restore(ctx, b.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Restore after Purge: err = %v, want not found", err)
+		}
+		if _, _, err := r.GetByID(ctx, a.ID); err != nil {
+			t.Fatalf("live order gone after Purge: %v", err)
+		}
+		if bookIDs, _ := r.BookIDs(ctx); slices.Contains(bookIDs, 99) {
+			t.Fatalf("BookIDs = %v, want purged order's items gone", bookIDs)
+		}
+	})
+}
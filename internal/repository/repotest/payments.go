@@ -0,0 +1,91 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Payments(t *testing.T, newRepo func(t *testing.T) repository.PaymentRepository) {
+	ctx := t.Context()
+
+	newPayment := func(t *testing.T, r repository.PaymentRepository, orderID int, intentID string) models.Payment {
+		t.Helper()
+		p, err := r.Create(ctx, models.Payment{
+			OrderID:  orderID,
+			Total:    10,
+			Status:   models.PaymentPending,
+			Provider: "fake",
+			IntentID: intentID,
+		})
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		return p
+	}
+
+	t.Run("CreateAndFind", func(t *testing.T) {
+		r := newRepo(t)
+		a := newPayment(t, r, 1, "pi_a")
+		newPayment(t, r, 2, "pi_b")
+		c := newPayment(t, r, 1, "pi_c")
+		if a.ID <= 0 || a.ID == c.ID || a.CreatedAt.IsZero() {
+			t.Fatalf("Create = %+v, %+v; want distinct ids and a time", a, c)
+		}
+
+		if _, err := r.Create(ctx, models.Payment{OrderID: 3, Provider: "fake", IntentID: "pi_a"}); !errors.Is(err, apperr.ErrConflict) {
+			t.Fatalf("Create with a known intent: err = %v, want conflict", err)
+		}
+		if _, err := r.Create(ctx, models.Payment{OrderID: 3, Provider: "fake"}); !errors.Is(err, apperr.ErrValidation) {
+			t.Fatalf("Create without intent: err = %v, want validation error", err)
+		}
+
+		got, err := r.GetByIntent(ctx, "fake", "pi_c")
+		if err != nil || got.ID != c.ID {
+			t.Fatalf("GetByIntent = %+v, %v; want payment %d", got, err, c.ID)
+		}
+		if _, err := r.GetByIntent(ctx, "other", "pi_c"); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("GetByIntent for another provider: err = %v, want not found", err)
+		}
+		if g := ids(r.ListByOrder(ctx, 1), func(p models.Payment) int { return p.ID }); !sameInts(g, []int{a.ID, c.ID}) {
+			t.Fatalf("ListByOrder ids = %v, want [%d %d]", g, a.ID, c.ID)
+		}
+	})
+
+	t.Run("SetStatus", func(t *testing.T) {
+		r := newRepo(t)
+		p := newPayment(t, r, 1, "pi_a")
+
+		got, err := r.SetStatus(ctx, p.ID, models.PaymentPending, models.PaymentFailed, "card_declined")
+		if err != nil || got.Status != models.PaymentFailed || got.FailureReason != "card_declined" {
+			t.Fatalf("SetStatus = %+v, %v; want failed with reason", got, err)
+		}
+		if _, err := r.SetStatus(ctx, p.ID, models.PaymentPending, models.PaymentSucceeded, ""); !errors.Is(err, apperr.ErrStale) {
+			t.Fatalf("SetStatus from old status: err = %v, want stale", err)
+		}
+		if _, err := r.SetStatus(ctx, 999, models.PaymentPending, models.PaymentSucceeded, ""); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("SetStatus on missing payment: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		r := newRepo(t)
+		if seen, err := r.EventSeen(ctx, "fake", "evt_1"); err != nil || seen {
+			t.Fatalf("EventSeen before marking = %v, %v", seen, err)
+		}
+		for range 2 {
+			if err := r.MarkEvent(ctx, "fake", "evt_1"); err != nil {
+				t.Fatalf("MarkEvent: %v", err)
+			}
+		}
+		if seen, _ := r.EventSeen(ctx, "fake", "evt_1"); !seen {
+			t.Fatal("EventSeen after marking = false")
+		}
+		if seen, _ := r.EventSeen(ctx, "other", "evt_1"); seen {
+			t.Fatal("EventSeen for another provider = true")
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Refunds(t *testing.T, newRepo func(t *testing.T) repository.RefundRepository) {
+	ctx := t.Context()
+
+	valid := models.Refund{
+		OrderID:          1,
+		PaymentID:        1,
+		Amount:           10,
+		ProviderRefundID: "re_1",
+		Items:            []models.RefundItem{{OrderItemID: 1, BookID: 1, Qty: 1, Amount: 10}},
+	}
+
+	t.Run("CreateValidates", func(t *testing.T) {
+		r := newRepo(t)
+		for name, rf := range map[string]models.Refund{
+			"no order":           {PaymentID: 1, ProviderRefundID: "re_1", Items: valid.Items},
+			"no payment":         {OrderID: 1, ProviderRefundID: "re_1", Items: valid.Items},
+			"negative":           {OrderID: 1, PaymentID: 1, ProviderRefundID: "re_1", Amount: -1, Items: valid.Items},
+			"no items":           {OrderID: 1, PaymentID: 1, ProviderRefundID: "re_1"},
+			"no provider refund": {OrderID: 1, PaymentID: 1, Items: valid.Items},
+		} {
+			if _, err := r.Create(ctx, rf); !errors.Is(err, apperr.ErrValidation) {
+				t.Errorf("Create with %s: err = %v, want validation error", name, err)
+			}
+		}
+	})
+
+	t.Run("CreateRejectsRepeatedProviderRefund", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.Create(ctx, valid); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if _, err := r.Create(ctx, valid); !errors.Is(err, apperr.ErrConflict) {
+			t.Fatalf("second Create = %v, want conflict", err)
+		}
+		other := valid
+		other.OrderID = 2
+		if _, err := r.Create(ctx, other); err != nil {
+			t.Fatalf("Create for another order with the same provider id: %v", err)
+		}
+		if n := len(r.ListByOrder(ctx, 1)); n != 1 {
+			t.Fatalf("ListByOrder = %d refunds, want 1", n)
+		}
+	})
+
+	t.Run("CreateAndList", func(t *testing.T) {
+		r := newRepo(t)
+		a, err := r.Create(ctx, valid)
+		if err != nil || a.ID <= 0 || a.CreatedAt.IsZero() {
+			t.Fatalf("Create = %+v, %v; want id and time", a, err)
+		}
+		other := valid
+		other.OrderID = 2
+		if _, err := r.Create(ctx, other); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		second := valid
+		second.ProviderRefundID = "re_2"
+		b, _ := r.Create(ctx, second)
+
+		got := r.ListByOrder(ctx, 1)
+		if g := ids(got, func(rf models.Refund) int { return rf.ID }); !sameInts(g, []int{a.ID, b.ID}) {
+			t.Fatalf("ListByOrder ids = %v, want [%d %d]", g, a.ID, b.ID)
+		}
+		if len(got[0].Items) != 1 || got[0].Items[0].Qty != 1 {
+			t.Fatalf("items = %+v, want the stored line", got[0].Items)
+		}
+		if len(r.ListByOrder(ctx, 9)) != 0 {
+			t.Fatal("ListByOrder for an order without refunds: want empty")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+// Package repotest holds conformance checks for the repository interfaces.
+// Every backend (Mongo, in-memory, anything added later) is expected to pass
+// the same checks so the rest of the app cannot tell them apart.
+//
+// Each function takes a constructor that must hand back an empty repository
+// on every call; the checks create their own fixtures. For example:
+//
+//	func TestMemoryBooks(t *testing.T) {
+//		repotest.Books(t, func(*testing.T) repository.BookRepository {
+//			return repository.NewMemoryBookRepo()
+//		})
+//	}
+//
+// Mongo constructors should point at a throwaway database and drop it with
+// t.Cleanup. The repository package's own tests run every suite against the
+// in-memory stores, and against Mongo when MONGO_TEST_URI is set.
+package repotest
+
+import (
+	"testing"
+
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func mustBook(t *testing.T, r repository.BookRepository, b models.Book) models.Book {
+	t.Helper()
+	b, err := r.Create(t.Context(), b)
+	if err != nil {
+		t.Fatalf("create book: %v", err)
+	}
+	return b
+}
+
+func ids[T any](items []T, id func(T) int) []int {
+	out := make([]int, 0, len(items))
+	for _, it := range items {
+		out = append(out, id(it))
+	}
+	return out
+}
+
+func sameInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,154 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+	"bookstore/internal/repository"
+)
+
+func Users(t *testing.T, newRepo func(t *testing.T) repository.UserRepository) {
+	ctx := t.Context()
+
+	newUser := func(t *testing.T, r repository.UserRepository, email string) models.User {
+		t.Helper()
+		if err := r.Create(ctx, models.User{Email: email, Password: "hash"}); err != nil {
+			t.Fatalf("Create(%s): %v", email, err)
+		}
+		u, err := r.GetByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("GetByEmail(%s): %v", email, err)
+		}
+		return u
+	}
+
+	t.Run("NotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.GetByID(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("GetByID on missing user: err = %v, want not found", err)
+		}
+		if _, err := r.GetByEmail(ctx, "nobody@example.com"); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("GetByEmail on missing user: err = %v, want not found", err)
+		}
+		if err := r.Update(ctx, models.User{ID: 999, Email: "x", Password: "x"}); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Update on missing user: err = %v, want not found", err)
+		}
+		if _, err := r.RecordLoginFailure(ctx, 999, time.Now()); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("RecordLoginFailure on missing user: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("CreateValidates", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Create(ctx, models.User{Password: "hash"}); !errors.Is(err, apperr.ErrValidation) {
+			t.Errorf("Create without email: err = %v, want validation error", err)
+		}
+		if err := r.Create(ctx, models.User{Email: "a@example.com"}); !errors.Is(err, apperr.ErrValidation) {
+			t.Errorf("Create without password: err = %v, want validation error", err)
+		}
+		newUser(t, r, "a@example.com")
+		if err := r.Create(ctx, models.User{Email: "a@example.com", Password: "hash"}); !errors.Is(err, apperr.ErrConflict) {
+			t.Errorf("Create with duplicate email: err = %v, want conflict", err)
+		}
+	})
+
+	t.Run("CreateAssignsIDsAndDefaultRole", func(t *testing.T) {
+		r := newRepo(t)
+		a := newUser(t, r, "a@example.com")
+		b := newUser(t, r, "b@example.com")
+		if a.ID <= 0 || b.ID <= 0 || a.ID == b.ID {
+			t.Fatalf("ids = %d, %d; want distinct positive", a.ID, b.ID)
+		}
+		if a.Role != models.RoleCustomer {
+			t.Fatalf("default role = %q, want %q", a.Role, models.RoleCustomer)
+		}
+		if g := ids(r.GetAll(ctx), func(u models.User) int { return u.ID }); !sameInts(g, []int{a.ID, b.ID}) {
+			t.Fatalf("GetAll ids = %v", g)
+		}
+	})
+
+	t.Run("LoginFailures", func(t *testing.T) {
+		r := newRepo(t)
+		u := newUser(t, r, "a@example.com")
+
+		for want := 1; want <= 2; want++ {
+			got, err := r.RecordLoginFailure(ctx, u.ID, time.Now())
+			if err != nil {
+				t.Fatalf("RecordLoginFailure: %v", err)
+			}
+			if got.FailedLogins != want {
+				t.Fatalf("failedLogins = %d, want %d", got.FailedLogins, want)
+			}
+		}
+
+		until := time.Now().Add(time.Hour)
+		if err := r.SetLockout(ctx, u.ID, &until); err != nil {
+			t.Fatalf("SetLockout: %v", err)
+		}
+		if got, _ := r.GetByID(ctx, u.ID); got.LockedUntil == nil {
+			t.Fatal("LockedUntil not stored")
+		}
+
+		if err := r.ResetLoginFailures(ctx, u.ID); err != nil {
+			t.Fatalf("ResetLoginFailures: %v", err)
+		}
+		got, _ := r.GetByID(ctx, u.ID)
+		if got.FailedLogins != 0 || got.LockedUntil != nil {
+			t.Fatalf("after reset failedLogins=%d lockedUntil=%v", got.FailedLogins, got.LockedUntil)
+		}
+	})
+
+	t.Run("CountFailedLoginsByIP", func(t *testing.T) {
+		r := newRepo(t)
+		now := time.Now()
+		attempts := []models.LoginAttempt{
+			{IP: "10.0.0.1", Reason: "bad_password", CreatedAt: now},
+			{IP: "10.0.0.1", Reason: "unknown_email", CreatedAt: now},
+			{IP: "10.0.0.1", Reason: "ip_throttled", CreatedAt: now},
+			{IP: "10.0.0.1", Reason: "bad_password", CreatedAt: now.Add(-time.Hour)},
+			{IP: "10.0.0.1", Success: true, CreatedAt: now},
+			{IP: "10.0.0.2", Reason: "bad_password", CreatedAt: now},
+		}
+		for _, a := range attempts {
+			if err := r.AddLoginAttempt(ctx, a); err != nil {
+				t.Fatalf("AddLoginAttempt: %v", err)
+			}
+		}
+
+		n, err := r.CountFailedLoginsByIP(ctx, "10.0.0.1", now.Add(-time.Minute), []string{"bad_password", "unknown_email"})
+		if err != nil {
+			t.Fatalf("CountFailedLoginsByIP: %v", err)
+		}
+		if n != 2 {
+			t.Fatalf("count = %d, want 2", n)
+		}
+	})
+
+	t.Run("TOTPStepsAreSingleUse", func(t *testing.T) {
+		r := newRepo(t)
+		u := newUser(t, r, "a@example.com")
+		if err := r.SetTOTP(ctx, u.ID, "SECRET", true, []string{"h1", "h2"}); err != nil {
+			t.Fatalf("SetTOTP: %v", err)
+		}
+
+		if ok, _ := r.UseTOTPStep(ctx, u.ID, 10); !ok {
+			t.Fatal("first use of step 10 rejected")
+		}
+		if ok, _ := r.UseTOTPStep(ctx, u.ID, 10); ok {
+			t.Fatal("replayed step 10 accepted")
+		}
+		if ok, _ := r.UseTOTPStep(ctx, u.ID, 9); ok {
+			t.Fatal("older step 9 accepted")
+		}
+
+		if ok, _ := r.UseRecoveryCode(ctx, u.ID, "h1"); !ok {
+			t.Fatal("recovery code h1 rejected")
+		}
+		if ok, _ := r.UseRecoveryCode(ctx, u.ID, "h1"); ok {
+			t.Fatal("recovery code h1 accepted twice")
+		}
+	})
+}
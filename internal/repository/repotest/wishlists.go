@@ -0,0 +1,130 @@
+package repotest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/repository"
+)
+
+func Wishlists(t *testing.T, newRepo func(t *testing.T) repository.WishlistRepository) {
+	ctx := t.Context()
+
+	t.Run("NotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, _, err := r.GetByID(ctx, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("GetByID on missing wishlist: err = %v, want not found", err)
+		}
+		if err := r.Delete(ctx, 999, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Delete on missing wishlist: err = %v, want not found", err)
+		}
+		if _, err := r.AddItem(ctx, 999, 1, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("AddItem on missing wishlist: err = %v, want not found", err)
+		}
+
+		w := r.Create(ctx, 1)
+		if err := r.DeleteItem(ctx, w.ID, 999); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("DeleteItem on missing item: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("CreateAssignsIDs", func(t *testing.T) {
+		r := newRepo(t)
+		a := r.Create(ctx, 1)
+		b := r.Create(ctx, 2)
+		if a.ID <= 0 || b.ID <= 0 || a.ID == b.ID {
+			t.Fatalf("ids = %d, %d; want distinct positive", a.ID, b.ID)
+		}
+		got, items, err := r.GetByID(ctx, b.ID)
+		if err != nil || got.CustomerID != 2 {
+			t.Fatalf("GetByID(%d) = %+v, %v", b.ID, got, err)
+		}
+		if items == nil || len(items) != 0 {
+			t.Fatalf("new wishlist items = %v, want empty", items)
+		}
+		if n := len(r.GetAll(ctx)); n != 2 {
+			t.Fatalf("GetAll returned %d wishlists, want 2", n)
+		}
+	})
+
+	t.Run("AddItemMergesSameBook", func(t *testing.T) {
+		r := newRepo(t)
+		w := r.Create(ctx, 1)
+
+		if _, err := r.AddItem(ctx, w.ID, 1, 0); !errors.Is(err, apperr.ErrValidation) {
+			t.Errorf("AddItem qty 0: err = %v, want validation error", err)
+		}
+
+		first, err := r.AddItem(ctx, w.ID, 3, 1)
+		if err != nil {
+			t.Fatalf("AddItem: %v", err)
+		}
+		second, err := r.AddItem(ctx, w.ID, 3, 2)
+		if err != nil {
+			t.Fatalf("AddItem again: %v", err)
+		}
+		if second.ID != first.ID || second.Qty != 3 {
+			t.Fatalf("merged item = %+v, want id %d qty 3", second, first.ID)
+		}
+
+		_, items, _ := r.GetByID(ctx, w.ID)
+		if len(items) != 1 || items[0].Qty != 3 {
+			t.Fatalf("items = %+v", items)
+		}
+	})
+
+	t.Run("DeleteItemAndWishlist", func(t *testing.T) {
+		r := newRepo(t)
+		w := r.Create(ctx, 1)
+		it, _ := r.AddItem(ctx, w.ID, 1, 1)
+
+		if err := r.DeleteItem(ctx, w.ID, it.ID); err != nil {
+			t.Fatalf("DeleteItem: %v", err)
+		}
+		_, items, _ := r.GetByID(ctx, w.ID)
+		if len(items) != 0 {
+			t.Fatalf("after DeleteItem items = %+v", items)
+		}
+
+		if err := r.Delete(ctx, w.ID, 1); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, _, err := r.GetByID(ctx, w.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Fatalf("GetByID after Delete: err = %v, want not found", err)
+		}
+	})
+
+	t.Run("SoftDeleteRestoreAndPurge", func(t *testing.T) {
+		r := newRepo(t)
+		a := r.Create(ctx, 1)
+		b := r.Create(ctx, 2)
+		_, _ = r.AddItem(ctx, a.ID, 1, 1)
+
+		if err := r.Delete(ctx, a.ID, 7); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if n := len(r.GetAll(ctx)); n != 1 {
+			t.Fatalf("GetAll after Delete returned %d wishlists, want 1", n)
+		}
+		if _, err := r.AddItem(ctx, a.ID, 2, 1); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("AddItem on deleted wishlist: err = %v, want not found", err)
+		}
+
+		if err := r.Restore(ctx, a.ID); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		if _, items, err := r.GetByID(ctx, a.ID); err != nil || len(items) != 1 {
+			t.Fatalf("GetByID after Restore = %d items, %v; want 1", len(items), err)
+		}
+
+		_ = r.Delete(ctx, b.ID, 7)
+		if n, err := r.Purge(ctx, time.Now().Add(time.Hour)); err != nil || n != 1 {
+			t.Fatalf("Purge = %d, %v; want 1", n, err)
+		}
+		if err := r.Restore(ctx, b.ID); !errors.Is(err, apperr.ErrNotFound) {
+			t.Errorf("Restore after Purge: err = %v, want not found", err)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type RevocationRepository interface {
+	Revoke(ctx context.Context, t models.RevokedToken) error
+	RevokeOnce(ctx context.Context, t models.RevokedToken) (bool, error)
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+
+	RevokeAllForUser(ctx context.Context, userID int, at time.Time) error
+	RevokedBefore(ctx context.Context, userID int) (time.Time, error)
+}
+
+type RevocationRepo struct {
+	tokensCol  *mongo.Collection
+	cutoffsCol *mongo.Collection
+}
+
+func NewRevocationRepo(db *mongo.Database) *RevocationRepo {
+	return &RevocationRepo{
+		tokensCol:  db.Collection("revoked_tokens"),
+		cutoffsCol: db.Collection("session_cutoffs"),
+	}
+}
+
+func (r *RevocationRepo) Revoke(ctx context.Context, t models.RevokedToken) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if t.JTI == "" {
+		return apperr.Invalid("jti", "jti required")
+	}
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = time.Now()
+	}
+
+	_, err := r.tokensCol.UpdateOne(
+		ctx,
+		bson.M{"_id": t.JTI},
+		bson.M{"$setOnInsert": bson.M{
+			"userId":    t.UserID,
+			"revokedAt": t.RevokedAt,
+			"expiresAt": t.ExpiresAt,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *RevocationRepo) RevokeOnce(ctx context.Context, t models.RevokedToken) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if t.JTI == "" {
+		return false, apperr.Invalid("jti", "jti required")
+	}
+	if t.RevokedAt.IsZero() {
+		t.RevokedAt = time.Now()
+	}
+
+	_, err := r.tokensCol.InsertOne(ctx, t)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *RevocationRepo) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.tokensCol.CountDocuments(ctx, bson.M{"_id": jti}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *RevocationRepo) RevokeAllForUser(ctx context.Context, userID int, at time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if userID <= 0 {
+		return apperr.Invalid("userId", "userId must be positive")
+	}
+
+	// Dates are stored to the millisecond; notBeforeUs keeps the cutoff to
+	// the microsecond that access tokens carry.
+	_, err := r.cutoffsCol.UpdateOne(
+		ctx,
+		bson.M{"userId": userID},
+		bson.M{"$max": bson.M{"notBefore": at, "notBeforeUs": at.UnixMicro()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *RevocationRepo) RevokedBefore(ctx context.Context, userID int) (time.Time, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var out struct {
+		NotBefore   time.Time `bson:"notBefore"`
+		NotBeforeUs int64     `bson:"notBeforeUs"`
+	}
+	err := r.cutoffsCol.FindOne(ctx, bson.M{"userId": userID}).Decode(&out)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if out.NotBeforeUs != 0 {
+		return time.UnixMicro(out.NotBeforeUs), nil
+	}
+	return out.NotBefore, nil
+}
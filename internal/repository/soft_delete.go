@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Books, orders and wishlists are soft-deleted: Delete stamps deletedAt and
+// deletedBy, the usual reads skip stamped documents, Restore clears the
+// stamp, and Purge removes documents that were deleted before a cutoff.
+
+// live narrows filter to documents that have not been soft-deleted.
+func live(filter bson.M) bson.M {
+	filter["deletedAt"] = bson.M{"$exists": false}
+	return filter
+}
+
+// softDelete stamps the live document with the given id. It reports false
+// when there is no such document.
+func softDelete(ctx context.Context, col *mongo.Collection, id, by int) (bool, error) {
+	res, err := col.UpdateOne(ctx, live(bson.M{"id": id}), bson.M{
+		"$set": bson.M{"deletedAt": time.Now(), "deletedBy": by},
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// restore clears the stamp on a soft-deleted document. It reports false when
+// no deleted document has the given id.
+func restore(ctx context.Context, col *mongo.Collection, id int) (bool, error) {
+	res, err := col.UpdateOne(ctx,
+		bson.M{"id": id, "deletedAt": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"deletedAt": "", "deletedBy": ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+// deletedBefore lists the ids of documents soft-deleted before the cutoff,
+// leaving out the ids in keep.
+func deletedBefore(ctx context.Context, col *mongo.Collection, before time.Time, keep []int) ([]int, error) {
+	filter := bson.M{"deletedAt": bson.M{"$lt": before}}
+	if len(keep) > 0 {
+		filter["id"] = bson.M{"$nin": keep}
+	}
+	return findIDs(ctx, col, filter)
+}
+
+func findIDs(ctx context.Context, col *mongo.Collection, filter bson.M) ([]int, error) {
+	cur, err := col.Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var ids []int
+	for cur.Next(ctx) {
+		var doc struct {
+			ID int `bson:"id"`
+		}
+		if cur.Decode(&doc) == nil {
+			ids = append(ids, doc.ID)
+		}
+	}
+	return ids, cur.Err()
+}
+
+// purge hard-deletes those of the given documents that are still
+// soft-deleted, then the items of the ones it removed. A document restored
+// after it was picked keeps its items.
+//
+// Where tx runs transactions both deletes commit together. Elsewhere an
+// interrupted purge can leave items whose parent is gone; they are
+// unreachable and harmless.
+func purge(ctx context.Context, tx Transactions, col, itemsCol *mongo.Collection, itemKey string, ids []int) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	deleted := bson.M{"id": bson.M{"$in": ids}, "deletedAt": bson.M{"$exists": true}}
+	if itemsCol == nil {
+		res, err := col.DeleteMany(ctx, deleted)
+		if err != nil {
+			return 0, err
+		}
+		return int(res.DeletedCount), nil
+	}
+
+	var n int
+	write := func(ctx context.Context) error {
+		picked, err := findIDs(ctx, col, deleted)
+		if err != nil || len(picked) == 0 {
+			return err
+		}
+		if _, err := col.DeleteMany(ctx, bson.M{"id": bson.M{"$in": picked}, "deletedAt": bson.M{"$exists": true}}); err != nil {
+			return err
+		}
+
+		// Anything picked that is still there was restored in between.
+		kept, err := findIDs(ctx, col, bson.M{"id": bson.M{"$in": picked}})
+		if err != nil {
+			return err
+		}
+		removed := slices.DeleteFunc(picked, func(id int) bool { return slices.Contains(kept, id) })
+		if len(removed) > 0 {
+			if _, err := itemsCol.DeleteMany(ctx, bson.M{itemKey: bson.M{"$in": removed}}); err != nil {
+				return err
+			}
+		}
+		n = len(removed)
+		return nil
+	}
+
+	ran, err := tx.Run(ctx, write)
+	if !ran {
+		err = write(ctx)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
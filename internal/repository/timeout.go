@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Timeout bounds each call the Mongo repositories make. It only ever shortens
+// the caller's context, so a cancelled request or a shutting-down server still
+// stops the call earlier. Set it once at startup, before serving traffic.
+var Timeout = 5 * time.Second
+
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, Timeout)
+}
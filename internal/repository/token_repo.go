@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"bookstore/internal/apperr"
+	"bookstore/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, t models.RefreshToken) error
+	GetByHash(ctx context.Context, hash string) (models.RefreshToken, error)
+	Revoke(ctx context.Context, hash string) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeAllForUser(ctx context.Context, userID int) error
+}
+
+type RefreshTokenRepo struct {
+	col *mongo.Collection
+}
+
+func NewRefreshTokenRepo(db *mongo.Database) *RefreshTokenRepo {
+	return &RefreshTokenRepo{col: db.Collection("refresh_tokens")}
+}
+
+func (r *RefreshTokenRepo) Create(ctx context.Context, t models.RefreshToken) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if t.TokenHash == "" {
+		return errors.New("token hash required")
+	}
+	if t.UserID <= 0 {
+		return apperr.Invalid("userId", "userId must be positive")
+	}
+
+	_, err := r.col.InsertOne(ctx, t)
+	return err
+}
+
+func (r *RefreshTokenRepo) GetByHash(ctx context.Context, hash string) (models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var t models.RefreshToken
+	err := r.col.FindOne(ctx, bson.M{"tokenHash": hash}).Decode(&t)
+	if err == mongo.ErrNoDocuments {
+		return models.RefreshToken{}, apperr.NotFound("refresh token not found")
+	}
+	return t, err
+}
+
+func (r *RefreshTokenRepo) Revoke(ctx context.Context, hash string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"tokenHash": hash, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("refresh token not found or already revoked")
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepo) RevokeFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.col.UpdateMany(
+		ctx,
+		bson.M{"familyId": familyID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
+
+func (r *RefreshTokenRepo) RevokeAllForUser(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.col.UpdateMany(
+		ctx,
+		bson.M{"userId": userID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	return err
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactions runs writes that span repositories as one unit where the
+// database allows it.
+type Transactions interface {
+	// Run runs fn in a transaction and reports true. fn must pass the
+	// context it is given to every repository call. A backend without
+	// transactions reports false without running fn, and the caller has to
+	// undo partial work itself.
+	Run(ctx context.Context, fn func(ctx context.Context) error) (bool, error)
+}
+
+// MongoTransactions uses multi-document transactions, which need a replica
+// set or a sharded cluster. Against a standalone mongod Run reports false.
+type MongoTransactions struct {
+	db *mongo.Database
+
+	mu        sync.Mutex
+	probed    bool
+	supported bool
+}
+
+func NewMongoTransactions(db *mongo.Database) *MongoTransactions {
+	return &MongoTransactions{db: db}
+}
+
+// Run joins the transaction ctx already carries, if any. Otherwise the
+// driver retries fn on transient errors, so fn must be safe to run more than
+// once.
+func (t *MongoTransactions) Run(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	if !t.supportsTransactions() {
+		return false, nil
+	}
+	if mongo.SessionFromContext(ctx) != nil {
+		return true, fn(ctx)
+	}
+
+	sess, err := t.db.Client().StartSession()
+	if err != nil {
+		return true, err
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	return true, err
+}
+
+// supportsTransactions reports whether the server is a replica set member or
+// a mongos. The probe runs on its own context so a caller's cancelled or
+// nearly expired request cannot decide the answer, and only a successful
+// answer is cached: after a failed probe the next call asks again.
+func (t *MongoTransactions) supportsTransactions() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.probed {
+		return t.supported
+	}
+
+	ctx, cancel := withTimeout(context.Background())
+	defer cancel()
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := t.db.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		log.Printf("[STORE] could not detect transaction support, writing without transactions: %v\n", err)
+		return false
+	}
+	t.probed = true
+	t.supported = hello.SetName != "" || hello.Msg == "isdbgrid"
+	if !t.supported {
+		log.Println("[STORE] standalone MongoDB detected, writing without transactions")
+	}
+	return t.supported
+}
+
+// NoTransactions is for backends without transactions, such as the memory
+// store.
+type NoTransactions struct{}
+
+func (NoTransactions) Run(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	return false, nil
+}
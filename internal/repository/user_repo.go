@@ -2,46 +2,63 @@ package repository
 
 import (
 	"context"
-	"errors"
 	"time"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository interface {
-	Create(user models.User) error
-	GetByEmail(email string) (models.User, error)
-	GetByID(id int) (models.User, error)
-	Update(user models.User) error
+	Create(ctx context.Context, user models.User) error
+	GetByEmail(ctx context.Context, email string) (models.User, error)
+	GetByID(ctx context.Context, id int) (models.User, error)
+	GetAll(ctx context.Context) []models.User
+	Update(ctx context.Context, user models.User) error
+	UpdateProfile(ctx context.Context, userID int, name, phone string) error
+
+	RecordLoginFailure(ctx context.Context, userID int, at time.Time) (models.User, error)
+	SetLockout(ctx context.Context, userID int, until *time.Time) error
+	ResetLoginFailures(ctx context.Context, userID int) error
+
+	AddLoginAttempt(ctx context.Context, a models.LoginAttempt) error
+	CountFailedLoginsByIP(ctx context.Context, ip string, since time.Time, reasons []string) (int, error)
+	ListLoginAttempts(ctx context.Context, email string, limit int) []models.LoginAttempt
+
+	SetTOTP(ctx context.Context, userID int, secret string, enabled bool, recoveryCodes []string) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
 }
 
 type UserRepo struct {
-	col      *mongo.Collection
-	counters *CounterRepo
+	col         *mongo.Collection
+	attemptsCol *mongo.Collection
+	ids         IDGenerator
 }
 
-func NewUserRepo(db *mongo.Database) *UserRepo {
+func NewUserRepo(db *mongo.Database, ids *IDs) *UserRepo {
 	return &UserRepo{
-		col:      db.Collection("users"),
-		counters: NewCounterRepo(db),
+		col:         db.Collection("users"),
+		attemptsCol: db.Collection("login_attempts"),
+		ids:         ids.For("users"),
 	}
 }
 
-func (r *UserRepo) Create(user models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepo) Create(ctx context.Context, user models.User) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	if user.Email == "" {
-		return errors.New("email required")
+		return apperr.Invalid("email", "email required")
 	}
 	if user.Password == "" {
-		return errors.New("password required")
+		return apperr.Invalid("password", "password required")
 	}
 	if user.Role == "" {
-		user.Role = "user"
+		user.Role = models.RoleCustomer
 	}
 
 	exists, err := r.col.CountDocuments(ctx, bson.M{"email": user.Email})
@@ -49,48 +66,72 @@ func (r *UserRepo) Create(user models.User) error {
 		return err
 	}
 	if exists > 0 {
-		return errors.New("email already exists")
+		return apperr.Conflict("email already exists")
 	}
 
-	id, err := r.counters.Next("users")
+	id, err := r.ids.Next(ctx)
 	if err != nil {
 		return err
 	}
 	user.ID = id
 
 	_, err = r.col.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return apperr.Conflict("email already exists").Wrap(err)
+	}
 	return err
 }
 
-func (r *UserRepo) GetByEmail(email string) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepo) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var u models.User
 	err := r.col.FindOne(ctx, bson.M{"email": email}).Decode(&u)
 	if err == mongo.ErrNoDocuments {
-		return models.User{}, errors.New("user not found")
+		return models.User{}, apperr.NotFound("user not found")
 	}
 	return u, err
 }
 
-func (r *UserRepo) GetByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *UserRepo) GetByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var u models.User
 	err := r.col.FindOne(ctx, bson.M{"id": id}).Decode(&u)
 	if err == mongo.ErrNoDocuments {
-		return models.User{}, errors.New("user not found")
+		return models.User{}, apperr.NotFound("user not found")
 	}
 	return u, err
 }
-func (r *UserRepo) Update(user models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+func (r *UserRepo) GetAll(ctx context.Context) []models.User {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cur, err := r.col.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return []models.User{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.User{}
+	for cur.Next(ctx) {
+		var u models.User
+		if cur.Decode(&u) == nil {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func (r *UserRepo) Update(ctx context.Context, user models.User) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	if user.ID <= 0 {
-		return errors.New("invalid user id")
+		return apperr.Invalid("id", "invalid user id")
 	}
 
 	res, err := r.col.UpdateOne(
@@ -102,7 +143,199 @@ func (r *UserRepo) Update(user models.User) error {
 		return err
 	}
 	if res.MatchedCount == 0 {
-		return errors.New("user not found")
+		return apperr.NotFound("user not found")
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdateProfile(ctx context.Context, userID int, name, phone string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(ctx, bson.M{"id": userID}, bson.M{
+		"$set": bson.M{"name": name, "phone": phone},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("user not found")
+	}
+	return nil
+}
+
+func (r *UserRepo) RecordLoginFailure(ctx context.Context, userID int, at time.Time) (models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var u models.User
+	err := r.col.FindOneAndUpdate(
+		ctx,
+		bson.M{"id": userID},
+		bson.M{
+			"$inc": bson.M{"failedLogins": 1},
+			"$set": bson.M{"lastFailedLoginAt": at},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return models.User{}, apperr.NotFound("user not found")
+	}
+	return u, err
+}
+
+func (r *UserRepo) SetLockout(ctx context.Context, userID int, until *time.Time) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"lockedUntil": ""}}
+	if until != nil {
+		update = bson.M{"$set": bson.M{"lockedUntil": *until}}
+	}
+
+	res, err := r.col.UpdateOne(ctx, bson.M{"id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("user not found")
+	}
+	return nil
+}
+
+func (r *UserRepo) ResetLoginFailures(ctx context.Context, userID int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(ctx, bson.M{"id": userID}, bson.M{
+		"$set":   bson.M{"failedLogins": 0},
+		"$unset": bson.M{"lockedUntil": ""},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("user not found")
+	}
+	return nil
+}
+
+func (r *UserRepo) AddLoginAttempt(ctx context.Context, a models.LoginAttempt) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	_, err := r.attemptsCol.InsertOne(ctx, a)
+	return err
+}
+
+func (r *UserRepo) CountFailedLoginsByIP(ctx context.Context, ip string, since time.Time, reasons []string) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	n, err := r.attemptsCol.CountDocuments(ctx, bson.M{
+		"ip":        ip,
+		"success":   false,
+		"reason":    bson.M{"$in": reasons},
+		"createdAt": bson.M{"$gte": since},
+	})
+	return int(n), err
+}
+
+func (r *UserRepo) ListLoginAttempts(ctx context.Context, email string, limit int) []models.LoginAttempt {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	filter := bson.M{}
+	if email != "" {
+		filter["email"] = email
+	}
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cur, err := r.attemptsCol.Find(ctx, filter, opts)
+	if err != nil {
+		return []models.LoginAttempt{}
+	}
+	defer cur.Close(ctx)
+
+	out := []models.LoginAttempt{}
+	for cur.Next(ctx) {
+		var a models.LoginAttempt
+		if cur.Decode(&a) == nil {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func (r *UserRepo) SetTOTP(ctx context.Context, userID int, secret string, enabled bool, recoveryCodes []string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	update := bson.M{
+		"$set":   bson.M{"totpEnabled": false},
+		"$unset": bson.M{"totpSecret": "", "totpEnabledAt": "", "totpLastStep": "", "recoveryCodes": ""},
+	}
+	if secret != "" {
+		set := bson.M{"totpSecret": secret, "totpEnabled": enabled}
+		if enabled {
+			set["totpEnabledAt"] = time.Now()
+			set["recoveryCodes"] = recoveryCodes
+			update = bson.M{"$set": set}
+		} else {
+			update = bson.M{
+				"$set":   set,
+				"$unset": bson.M{"totpEnabledAt": "", "totpLastStep": "", "recoveryCodes": ""},
+			}
+		}
+	}
+
+	res, err := r.col.UpdateOne(ctx, bson.M{"id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return apperr.NotFound("user not found")
 	}
 	return nil
 }
+
+func (r *UserRepo) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(ctx, bson.M{
+		"id": userID,
+		"$or": bson.A{
+			bson.M{"totpLastStep": bson.M{"$exists": false}},
+			bson.M{"totpLastStep": bson.M{"$lt": step}},
+		},
+	}, bson.M{"$set": bson.M{"totpLastStep": step}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
+
+func (r *UserRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := r.col.UpdateOne(
+		ctx,
+		bson.M{"id": userID, "recoveryCodes": codeHash},
+		bson.M{"$pull": bson.M{"recoveryCodes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount == 1, nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+
+	"bookstore/internal/apperr"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Books, orders and carts carry a version that starts at 1 and goes up by
+// one on every Update. An Update that names a version only applies if the
+// stored document still has it, and fails with apperr.Stale otherwise.
+// Version 0 skips the check, for callers that never read the document.
+
+// updateVersioned applies set to the document matching filter and bumps its
+// version, decoding the updated document into out.
+func updateVersioned(ctx context.Context, col *mongo.Collection, filter bson.M, version int, set bson.M, out any, entity string) error {
+	match := bson.M{}
+	for k, v := range filter {
+		match[k] = v
+	}
+	if version != 0 {
+		match["version"] = version
+	}
+
+	update := bson.M{"$inc": bson.M{"version": 1}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	err := col.FindOneAndUpdate(ctx, match, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(out)
+	if err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	if version != 0 {
+		n, err := col.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return staleVersion(entity)
+		}
+	}
+	return apperr.NotFound(entity + " not found")
+}
+
+func staleVersion(entity string) error {
+	return apperr.Stale(entity + " was changed since it was read")
+}
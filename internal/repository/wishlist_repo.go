@@ -2,48 +2,58 @@ package repository
 
 import (
 	"context"
-	"errors"
 	"time"
 
+	"bookstore/internal/apperr"
 	"bookstore/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type WishlistRepository interface {
-	Create(customerID int) models.Wishlist
-	GetAll() []models.Wishlist
-	GetByID(id int) (models.Wishlist, []models.WishlistItem, error)
-	Delete(id int) error
-
-	AddItem(wishlistID int, bookID int, qty int) (models.WishlistItem, error)
-	DeleteItem(wishlistID int, itemID int) error
+	Create(ctx context.Context, customerID int) models.Wishlist
+	GetAll(ctx context.Context) []models.Wishlist
+	GetByID(ctx context.Context, id int) (models.Wishlist, []models.WishlistItem, error)
+
+	Delete(ctx context.Context, id, deletedBy int) error
+	Restore(ctx context.Context, id int) error
+	// Purge hard-deletes wishlists soft-deleted before the cutoff, with
+	// their items.
+	Purge(ctx context.Context, before time.Time) (int, error)
+
+	AddItem(ctx context.Context, wishlistID int, bookID int, qty int) (models.WishlistItem, error)
+	DeleteItem(ctx context.Context, wishlistID int, itemID int) error
 }
 
 type WishlistRepo struct {
+	tx           *MongoTransactions
 	wishlistsCol *mongo.Collection
 	itemsCol     *mongo.Collection
-	counters     *CounterRepo
+	ids          IDGenerator
+	itemIDs      IDGenerator
 }
 
-func NewWishlistRepo(db *mongo.Database) *WishlistRepo {
+func NewWishlistRepo(db *mongo.Database, ids *IDs) *WishlistRepo {
 	return &WishlistRepo{
+		tx:           NewMongoTransactions(db),
 		wishlistsCol: db.Collection("wishlists"),
 		itemsCol:     db.Collection("wishlist_items"),
-		counters:     NewCounterRepo(db),
+		ids:          ids.For("wishlists"),
+		itemIDs:      ids.For("wishlist_items"),
 	}
 }
 
-func (r *WishlistRepo) Create(customerID int) models.Wishlist {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *WishlistRepo) Create(ctx context.Context, customerID int) models.Wishlist {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	if customerID <= 0 {
 		customerID = 1
 	}
 
-	id, err := r.counters.Next("wishlists")
+	id, err := r.ids.Next(ctx)
 	if err != nil {
 		return models.Wishlist{}
 	}
@@ -61,11 +71,11 @@ func (r *WishlistRepo) Create(customerID int) models.Wishlist {
 	return w
 }
 
-func (r *WishlistRepo) GetAll() []models.Wishlist {
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+func (r *WishlistRepo) GetAll(ctx context.Context) []models.Wishlist {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	cur, err := r.wishlistsCol.Find(ctx, bson.M{})
+	cur, err := r.wishlistsCol.Find(ctx, live(bson.M{}))
 	if err != nil {
 		return []models.Wishlist{}
 	}
@@ -81,14 +91,14 @@ func (r *WishlistRepo) GetAll() []models.Wishlist {
 	return out
 }
 
-func (r *WishlistRepo) GetByID(id int) (models.Wishlist, []models.WishlistItem, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+func (r *WishlistRepo) GetByID(ctx context.Context, id int) (models.Wishlist, []models.WishlistItem, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	var w models.Wishlist
-	err := r.wishlistsCol.FindOne(ctx, bson.M{"id": id}).Decode(&w)
+	err := r.wishlistsCol.FindOne(ctx, live(bson.M{"id": id})).Decode(&w)
 	if err == mongo.ErrNoDocuments {
-		return models.Wishlist{}, nil, errors.New("wishlist not found")
+		return models.Wishlist{}, nil, apperr.NotFound("wishlist not found")
 	}
 	if err != nil {
 		return models.Wishlist{}, nil, err
@@ -111,33 +121,56 @@ func (r *WishlistRepo) GetByID(id int) (models.Wishlist, []models.WishlistItem,
 	return w, items, nil
 }
 
-func (r *WishlistRepo) Delete(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+func (r *WishlistRepo) Delete(ctx context.Context, id, deletedBy int) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
-	res, err := r.wishlistsCol.DeleteOne(ctx, bson.M{"id": id})
+	ok, err := softDelete(ctx, r.wishlistsCol, id, deletedBy)
 	if err != nil {
 		return err
 	}
-	if res.DeletedCount == 0 {
-		return errors.New("wishlist not found")
+	if !ok {
+		return apperr.NotFound("wishlist not found")
 	}
+	return nil
+}
 
-	_, _ = r.itemsCol.DeleteMany(ctx, bson.M{"wishlistId": id})
+func (r *WishlistRepo) Restore(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ok, err := restore(ctx, r.wishlistsCol, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apperr.NotFound("deleted wishlist not found")
+	}
 	return nil
 }
 
-func (r *WishlistRepo) AddItem(wishlistID int, bookID int, qty int) (models.WishlistItem, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *WishlistRepo) Purge(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	ids, err := deletedBefore(ctx, r.wishlistsCol, before, nil)
+	if err != nil {
+		return 0, err
+	}
+	return purge(ctx, r.tx, r.wishlistsCol, r.itemsCol, "wishlistId", ids)
+}
+
+func (r *WishlistRepo) AddItem(ctx context.Context, wishlistID int, bookID int, qty int) (models.WishlistItem, error) {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	if qty <= 0 {
-		return models.WishlistItem{}, errors.New("qty must be > 0")
+		return models.WishlistItem{}, apperr.Invalid("qty", "qty must be > 0")
 	}
 
-	err := r.wishlistsCol.FindOne(ctx, bson.M{"id": wishlistID}).Err()
+	err := r.wishlistsCol.FindOne(ctx, live(bson.M{"id": wishlistID})).Err()
 	if err == mongo.ErrNoDocuments {
-		return models.WishlistItem{}, errors.New("wishlist not found")
+		return models.WishlistItem{}, apperr.NotFound("wishlist not found")
 	}
 	if err != nil {
 		return models.WishlistItem{}, err
@@ -147,6 +180,7 @@ func (r *WishlistRepo) AddItem(wishlistID int, bookID int, qty int) (models.Wish
 		ctx,
 		bson.M{"wishlistId": wishlistID, "bookId": bookID},
 		bson.M{"$inc": bson.M{"qty": qty}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
 	)
 	if res.Err() == nil {
 		var updated models.WishlistItem
@@ -157,7 +191,7 @@ func (r *WishlistRepo) AddItem(wishlistID int, bookID int, qty int) (models.Wish
 		return models.WishlistItem{}, res.Err()
 	}
 
-	itemID, err := r.counters.Next("wishlist_items")
+	itemID, err := r.itemIDs.Next(ctx)
 	if err != nil {
 		return models.WishlistItem{}, err
 	}
@@ -177,8 +211,8 @@ func (r *WishlistRepo) AddItem(wishlistID int, bookID int, qty int) (models.Wish
 	return it, nil
 }
 
-func (r *WishlistRepo) DeleteItem(wishlistID int, itemID int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+func (r *WishlistRepo) DeleteItem(ctx context.Context, wishlistID int, itemID int) error {
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 
 	res, err := r.itemsCol.DeleteOne(ctx, bson.M{"wishlistId": wishlistID, "id": itemID})
@@ -186,7 +220,7 @@ func (r *WishlistRepo) DeleteItem(wishlistID int, itemID int) error {
 		return err
 	}
 	if res.DeletedCount == 0 {
-		return errors.New("item not found")
+		return apperr.NotFound("item not found")
 	}
 	return nil
 }
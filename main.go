@@ -1,25 +1,118 @@
 package main
 
 import (
-	"bookstore/internal/db"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"bookstore/internal/db"
+	"bookstore/internal/migrate"
+	"bookstore/internal/payments"
+	"bookstore/internal/repository"
 
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
 	_ = godotenv.Load()
 
-	client, mongoDB, err := db.Connect()
+	inMemory := flag.Bool("memory", os.Getenv("DATA_STORE") == "memory", "keep all data in memory instead of MongoDB")
+	flag.Parse()
+
+	if v := os.Getenv("DB_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("invalid DB_TIMEOUT %q", v)
+		}
+		repository.Timeout = d
+	}
+
+	// Cancelled on SIGINT/SIGTERM; in-flight requests and background
+	// workers see it through their contexts.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var stores repository.Stores
+	if *inMemory {
+		log.Println("[STORE] running without MongoDB; all data is lost on restart")
+		stores = repository.NewMemoryStores()
+	} else {
+		client, mongoDB, err := db.Connect()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer client.Disconnect(db.Bg())
+
+		if os.Getenv("MIGRATE_ON_START") == "true" {
+			if _, err := migrate.Run(ctx, mongoDB, migrate.All); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		ids, err := idsFromEnv(mongoDB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stores = repository.NewMongoStores(mongoDB, ids)
+	}
+
+	// Nothing paid for on the in-memory store is real, so it can run on the
+	// fake provider without any configuration.
+	paymentProvider, err := payments.FromEnv(*inMemory)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Disconnect(db.Bg())
 
 	mux := http.NewServeMux()
-	RegisterRoutes(mux, mongoDB)
+	RegisterRoutes(ctx, mux, stores, paymentProvider)
+
+	srv := &http.Server{
+		Addr:        ":8080",
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v\n", err)
+		}
+	}()
 
 	log.Println("Server started at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+}
+
+// idsFromEnv reads ID_STRATEGY (e.g. "orders=snowflake,users=snowflake,*=batch")
+// and ID_NODE, the 0-15 node number each server needs for snowflake ids.
+func idsFromEnv(mongoDB *mongo.Database) (*repository.IDs, error) {
+	strategies, err := repository.ParseIDStrategies(os.Getenv("ID_STRATEGY"))
+	if err != nil {
+		return nil, err
+	}
+	node := 0
+	if v := os.Getenv("ID_NODE"); v != "" {
+		if node, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid ID_NODE %q", v)
+		}
+	}
+	return repository.NewIDs(mongoDB, strategies, node)
 }
@@ -1,104 +1,228 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"bookstore/internal/handlers"
 	"bookstore/internal/logic"
+	"bookstore/internal/mailer"
 	"bookstore/internal/middleware"
+	"bookstore/internal/models"
+	"bookstore/internal/payments"
 	"bookstore/internal/repository"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func RegisterRoutes(mux *http.ServeMux, mongoDB *mongo.Database) {
+// RegisterRoutes wires every handler onto mux. Background workers started here
+// run until ctx is cancelled.
+func RegisterRoutes(ctx context.Context, mux *http.ServeMux, stores repository.Stores, paymentProvider payments.Provider) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		log.Fatal("JWT_SECRET is not set")
 	}
 
-	bookRepo := repository.NewBookRepo(mongoDB)
-	userRepo := repository.NewUserRepo(mongoDB)
-	cartRepo := repository.NewCartRepo() 
-	wishlistRepo := repository.NewWishlistRepo(mongoDB)
-	orderRepo := repository.NewOrderRepo(mongoDB)
+	middleware.TrustProxyHeaders = os.Getenv("TRUST_PROXY_HEADERS") == "true"
+	middleware.WriteError = handlers.WriteError
+
+	baseURL := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	bookRepo := stores.Books
+	userRepo := stores.Users
+	cartRepo := stores.Carts
+	wishlistRepo := stores.Wishlists
+	orderRepo := stores.Orders
+	refreshRepo := stores.RefreshTokens
+	revocationRepo := stores.Revocations
+	apiKeyRepo := stores.APIKeys
+	addressRepo := stores.Addresses
+
+	fakePayments, _ := paymentProvider.(*payments.Fake)
 
-	logic.StartOrderWorkerPool(2, cartRepo, wishlistRepo)
+	logic.StartOrderWorkerPool(ctx, 2, cartRepo, wishlistRepo)
 
-	bookService := logic.NewBookService(bookRepo)
-	authService := logic.NewAuthService(userRepo, secret)
-	cartCRUDService := logic.NewCartCRUDService(cartRepo, bookRepo)
-	orderSvc := logic.NewOrderService(orderRepo, bookRepo, cartRepo)
+	purgeService := logic.NewPurgeService(bookRepo, orderRepo, wishlistRepo,
+		durationEnv("PURGE_RETENTION", logic.DefaultPurgeRetention))
+	logic.StartPurgeJob(ctx, durationEnv("PURGE_INTERVAL", 24*time.Hour), purgeService)
+
+	inventoryService := logic.NewInventoryService(stores.Inventory, stores.Transactions,
+		durationEnv("CART_RESERVATION_TTL", logic.DefaultReservationTTL))
+	logic.StartReservationSweeper(ctx, time.Minute, inventoryService)
+
+	bookService := logic.NewBookService(bookRepo, inventoryService)
+	authService := logic.NewAuthService(userRepo, refreshRepo, revocationRepo, secret)
+	authService.SetMFARequiredRoles(splitList(os.Getenv("MFA_REQUIRED_ROLES")))
+	apiKeyService := logic.NewAPIKeyService(apiKeyRepo, userRepo)
+	userService := logic.NewUserService(userRepo, revocationRepo)
+	accountService := logic.NewAccountService(userRepo, authService, mailer.FromEnv(), baseURL)
+	cartCRUDService := logic.NewCartCRUDService(cartRepo, bookRepo, inventoryService)
+	addressService := logic.NewAddressService(addressRepo)
 	orderCRUD := logic.NewOrderCRUDService(orderRepo)
-	wishlistService := logic.NewWishlistService(wishlistRepo, bookRepo, orderRepo)
+	paymentService := logic.NewPaymentService(stores.Payments, paymentProvider, orderCRUD)
+	orderSvc := logic.NewOrderService(orderRepo, bookRepo, cartRepo, addressService, inventoryService, paymentService)
+	wishlistService := logic.NewWishlistService(wishlistRepo, bookRepo, orderRepo, inventoryService, paymentService)
+	refundService := logic.NewRefundService(stores.Refunds, orderCRUD, paymentService, inventoryService)
+	paymentService.SetRefunds(refundService)
+	idempotencyService := logic.NewIdempotencyService(stores.Idempotency,
+		durationEnv("IDEMPOTENCY_RETENTION", logic.DefaultIdempotencyRetention))
 
 	bookHandler := handlers.NewBookHandler(bookService)
+	inventoryHandler := handlers.NewInventoryHandler(inventoryService)
 	cartHandler := handlers.NewCartHandler(cartCRUDService)
 	orderHandler := handlers.NewOrderHandler(orderSvc)
 	orderCRUDHandler := handlers.NewOrderCRUDHandler(orderCRUD)
 	wishlistHandler := handlers.NewWishlistHandler(wishlistService)
-	authHandler := handlers.NewAuthHandler(authService)
+	authHandler := handlers.NewAuthHandler(authService, accountService)
+	userHandler := handlers.NewUserHandler(userService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	profileHandler := handlers.NewProfileHandler(accountService, addressService)
+	paymentHandler := handlers.NewPaymentHandler(paymentService, orderCRUD, fakePayments)
+	refundHandler := handlers.NewRefundHandler(refundService, orderCRUD)
 
 	frontend, err := handlers.NewFrontendHandler(
 		bookService,
 		authService,
+		accountService,
 		cartCRUDService,
 		orderSvc,
 		orderCRUD,
 		wishlistService,
-		secret,
+		addressService,
+		paymentService,
+		refundService,
 	)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	mux.HandleFunc("GET /", frontend.Home)
-	mux.HandleFunc("GET /catalog", frontend.Catalog)
-	mux.HandleFunc("GET /about", frontend.About)
+	// Account and session management only accepts a user's own token; API
+	// keys are for the data endpoints.
+	sessionAuth := middleware.Bearer(authService)
+	apiAuth := middleware.Chain(sessionAuth, middleware.APIKey(apiKeyService))
+	pageAuth := middleware.Cookie(authService, handlers.TokenCookie)
+
+	csrf := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.CSRF(frontend.CSRFFailure, next)
+	}
+	page := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.Authenticate(pageAuth, frontend.WithSession(csrf(next)))
+	}
+
+	// Endpoints that place orders replay their first response to retries
+	// that carry the same idempotency key.
+	idempotentAPI := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.Idempotent(idempotencyService, handlers.WriteError, next)
+	}
+	idempotentPage := func(next http.HandlerFunc) http.HandlerFunc {
+		return middleware.Idempotent(idempotencyService, frontend.IdempotencyFailure, next)
+	}
+
+	mux.HandleFunc("GET /", page(frontend.Home))
+	mux.HandleFunc("GET /catalog", page(frontend.Catalog))
+	mux.HandleFunc("GET /about", page(frontend.About))
 
-	mux.HandleFunc("GET /login", frontend.Login)
-	mux.HandleFunc("POST /login", frontend.LoginPost)
+	mux.HandleFunc("GET /login", page(frontend.Login))
+	mux.HandleFunc("POST /login", page(frontend.LoginPost))
+	mux.HandleFunc("POST /login/2fa", page(frontend.LoginMFAPost))
 
-	mux.HandleFunc("GET /register", frontend.Register)
-	mux.HandleFunc("POST /register", frontend.RegisterPost)
+	mux.HandleFunc("GET /register", page(frontend.Register))
+	mux.HandleFunc("POST /register", page(frontend.RegisterPost))
 
-	mux.HandleFunc("POST /logout", frontend.Logout)
+	mux.HandleFunc("GET /account/security", page(frontend.SecurityPage))
+	mux.HandleFunc("POST /account/2fa/setup", page(frontend.TOTPSetupPost))
+	mux.HandleFunc("POST /account/2fa/enable", page(frontend.TOTPEnablePost))
+	mux.HandleFunc("POST /account/2fa/disable", page(frontend.TOTPDisablePost))
+	mux.HandleFunc("POST /account/2fa/recovery-codes", page(frontend.RecoveryCodesPost))
 
-	mux.HandleFunc("GET /admin/books/create", frontend.AdminCreateBookPage)
-	mux.HandleFunc("POST /admin/books/create", frontend.AdminCreateBookPost)
+	mux.HandleFunc("GET /account/profile", page(frontend.ProfilePage))
+	mux.HandleFunc("POST /account/profile", page(frontend.ProfilePost))
+	mux.HandleFunc("POST /account/password", page(frontend.ChangePasswordPost))
+	mux.HandleFunc("POST /account/addresses", page(frontend.AddressAddPost))
+	mux.HandleFunc("POST /account/addresses/{id}/default", page(frontend.AddressDefaultPost))
+	mux.HandleFunc("POST /account/addresses/{id}/delete", page(frontend.AddressDeletePost))
 
-	mux.HandleFunc("GET /cart", frontend.CartPage)
-	mux.HandleFunc("POST /cart/add/{bookId}", frontend.CartAdd)
-	mux.HandleFunc("POST /cart/item/{itemId}/update", frontend.CartUpdateQty)
-	mux.HandleFunc("POST /cart/item/{itemId}/delete", frontend.CartDeleteItem)
+	mux.HandleFunc("GET /auth/verify", page(frontend.VerifyEmailPage))
+	mux.HandleFunc("GET /auth/reset", page(frontend.ResetPasswordPage))
+	mux.HandleFunc("POST /auth/reset", byContentType(authHandler.ResetPassword, page(frontend.ResetPasswordPost)))
+	mux.HandleFunc("POST /auth/reset/request", byContentType(authHandler.RequestPasswordReset, page(frontend.ResetRequestPost)))
 
-	mux.HandleFunc("GET /orders", frontend.OrdersPage)
-	mux.HandleFunc("GET /orders/{id}", frontend.OrderDetailsPage)
-	mux.HandleFunc("POST /orders/create", frontend.CreateOrderFromCart)
+	mux.HandleFunc("POST /logout", middleware.Authenticate(pageAuth, csrf(frontend.Logout)))
+	mux.HandleFunc("POST /logout/all", page(frontend.LogoutAll))
 
-	mux.HandleFunc("GET /wishlists", frontend.WishlistsPage)
-	mux.HandleFunc("POST /wishlists/add/{bookId}", frontend.WishlistAdd)
-	mux.HandleFunc("POST /wishlists/gift/{wishlistId}", frontend.WishlistGift)
+	mux.HandleFunc("GET /admin/books/create", page(frontend.AdminCreateBookPage))
+	mux.HandleFunc("POST /admin/books/create", page(frontend.AdminCreateBookPost))
+
+	mux.HandleFunc("GET /cart", page(frontend.CartPage))
+	mux.HandleFunc("POST /cart/add/{bookId}", page(frontend.CartAdd))
+	mux.HandleFunc("POST /cart/item/{itemId}/update", page(frontend.CartUpdateQty))
+	mux.HandleFunc("POST /cart/item/{itemId}/delete", page(frontend.CartDeleteItem))
+
+	mux.HandleFunc("GET /orders", page(frontend.OrdersPage))
+	mux.HandleFunc("GET /orders/{id}", page(frontend.OrderDetailsPage))
+	mux.HandleFunc("POST /orders/create", page(idempotentPage(frontend.CreateOrderFromCart)))
+	mux.HandleFunc("POST /orders/{id}/cancel", page(frontend.OrderCancel))
+
+	mux.HandleFunc("GET /wishlists", page(frontend.WishlistsPage))
+	mux.HandleFunc("POST /wishlists/add/{bookId}", page(frontend.WishlistAdd))
+	mux.HandleFunc("POST /wishlists/gift/{wishlistId}", page(idempotentPage(frontend.WishlistGift)))
 
 	mux.HandleFunc("GET /health", handlers.Health)
 
 	mux.HandleFunc("POST /auth/register", authHandler.Register)
 	mux.HandleFunc("POST /auth/login", authHandler.Login)
+	mux.HandleFunc("POST /auth/login/2fa", authHandler.LoginMFA)
+	mux.HandleFunc("POST /auth/refresh", authHandler.Refresh)
+	mux.HandleFunc("POST /auth/verify", authHandler.VerifyEmail)
+	mux.HandleFunc("POST /auth/verify/resend", middleware.AuthOnly(sessionAuth, authHandler.ResendVerification))
+	mux.HandleFunc("POST /auth/logout", middleware.AuthOnly(sessionAuth, authHandler.Logout))
+	mux.HandleFunc("POST /auth/logout-all", middleware.AuthOnly(sessionAuth, authHandler.LogoutAll))
+	mux.HandleFunc("POST /auth/2fa/setup", middleware.AuthOnly(sessionAuth, authHandler.TOTPSetup))
+	mux.HandleFunc("POST /auth/2fa/enable", middleware.AuthOnly(sessionAuth, authHandler.TOTPEnable))
+	mux.HandleFunc("POST /auth/2fa/disable", middleware.AuthOnly(sessionAuth, authHandler.TOTPDisable))
+	mux.HandleFunc("POST /auth/2fa/recovery-codes", middleware.AuthOnly(sessionAuth, authHandler.RecoveryCodes))
+
+	mux.HandleFunc("GET /auth/api-keys", middleware.AuthOnly(sessionAuth, apiKeyHandler.List))
+	mux.HandleFunc("POST /auth/api-keys", middleware.AuthOnly(sessionAuth, apiKeyHandler.Create))
+	mux.HandleFunc("DELETE /auth/api-keys/{id}", middleware.AuthOnly(sessionAuth, apiKeyHandler.Revoke))
+
+	mux.HandleFunc("GET /me", middleware.AuthOnly(sessionAuth, profileHandler.Me))
+	mux.HandleFunc("PUT /me", middleware.AuthOnly(sessionAuth, profileHandler.Me))
+	mux.HandleFunc("POST /me/password", middleware.AuthOnly(sessionAuth, profileHandler.ChangePassword))
+	mux.HandleFunc("GET /me/addresses", middleware.AuthOnly(sessionAuth, profileHandler.Addresses))
+	mux.HandleFunc("POST /me/addresses", middleware.AuthOnly(sessionAuth, profileHandler.Addresses))
+	mux.HandleFunc("GET /me/addresses/{id}", middleware.AuthOnly(sessionAuth, profileHandler.AddressByID))
+	mux.HandleFunc("PUT /me/addresses/{id}", middleware.AuthOnly(sessionAuth, profileHandler.AddressByID))
+	mux.HandleFunc("DELETE /me/addresses/{id}", middleware.AuthOnly(sessionAuth, profileHandler.AddressByID))
+	mux.HandleFunc("POST /me/addresses/{id}/default", middleware.AuthOnly(sessionAuth, profileHandler.SetDefaultAddress))
+
+	mux.HandleFunc("GET /admin/roles", middleware.Require(apiAuth, models.PermUsersRead, userHandler.Roles))
+	mux.HandleFunc("GET /admin/users", middleware.Require(apiAuth, models.PermUsersRead, userHandler.Users))
+	mux.HandleFunc("GET /admin/users/{id}", middleware.Require(apiAuth, models.PermUsersRead, userHandler.UserByID))
+	mux.HandleFunc("PUT /admin/users/{id}/role", middleware.Require(apiAuth, models.PermUsersWrite, userHandler.AssignRole))
+	mux.HandleFunc("POST /admin/users/{id}/unlock", middleware.Require(apiAuth, models.PermUsersWrite, userHandler.Unlock))
+	mux.HandleFunc("POST /admin/users/{id}/2fa/reset", middleware.Require(apiAuth, models.PermUsersWrite, authHandler.ResetTOTP))
+	mux.HandleFunc("GET /admin/login-attempts", middleware.Require(apiAuth, models.PermUsersRead, userHandler.LoginAttempts))
 
 	mux.HandleFunc("GET /books", bookHandler.Books)
-	mux.HandleFunc("POST /books", middleware.AdminOnly(secret, bookHandler.Books))
+	mux.HandleFunc("POST /books", middleware.Require(apiAuth, models.PermBooksWrite, bookHandler.Books))
 
 	mux.HandleFunc("GET /books/{id}", bookHandler.BookByID)
-	mux.HandleFunc("PUT /books/{id}", middleware.AdminOnly(secret, bookHandler.BookByID))
-	mux.HandleFunc("DELETE /books/{id}", middleware.AdminOnly(secret, bookHandler.BookByID))
+	mux.HandleFunc("PUT /books/{id}", middleware.Require(apiAuth, models.PermBooksWrite, bookHandler.BookByID))
+	mux.HandleFunc("DELETE /books/{id}", middleware.Require(apiAuth, models.PermBooksWrite, bookHandler.BookByID))
+	mux.HandleFunc("POST /books/{id}/restore", middleware.Require(apiAuth, models.PermBooksWrite, bookHandler.Restore))
+	mux.HandleFunc("POST /books/{id}/stock", middleware.Require(apiAuth, models.PermBooksWrite, inventoryHandler.Adjust))
+	mux.HandleFunc("GET /books/{id}/stock/movements", middleware.Require(apiAuth, models.PermBooksWrite, inventoryHandler.Movements))
 
-	mux.HandleFunc("GET /carts", middleware.AuthOnly(secret, cartHandler.Carts))
-	mux.HandleFunc("POST /carts", middleware.AuthOnly(secret, cartHandler.Carts))
+	mux.HandleFunc("GET /carts", middleware.Require(apiAuth, models.PermCartsOwn, cartHandler.Carts))
+	mux.HandleFunc("POST /carts", middleware.Require(apiAuth, models.PermCartsOwn, cartHandler.Carts))
 
-	cartsPrefixHandler := middleware.AuthOnly(secret, func(w http.ResponseWriter, r *http.Request) {
+	cartsPrefixHandler := middleware.Require(apiAuth, models.PermCartsOwn, func(w http.ResponseWriter, r *http.Request) {
 		if strings.Contains(r.URL.Path, "/items/") {
 			cartHandler.CartItemByID(w, r)
 			return
@@ -115,25 +239,38 @@ func RegisterRoutes(mux *http.ServeMux, mongoDB *mongo.Database) {
 	mux.HandleFunc("PUT /carts/", cartsPrefixHandler)
 	mux.HandleFunc("DELETE /carts/", cartsPrefixHandler)
 
-	mux.HandleFunc("POST /orders_api", middleware.AuthOnly(secret, orderHandler.Orders))
-	mux.HandleFunc("GET /orders_api", middleware.AuthOnly(secret, orderCRUDHandler.Orders))
+	mux.HandleFunc("POST /orders_api", middleware.Require(apiAuth, models.PermOrdersPlace, idempotentAPI(orderHandler.Orders)))
+	mux.HandleFunc("GET /orders_api", middleware.Scoped(apiAuth, orderCRUDHandler.Orders, models.PermOrdersPlace, models.PermOrdersRead))
 
-	ordersByID := middleware.AuthOnly(secret, orderCRUDHandler.OrderByID)
+	ordersByID := middleware.Scoped(apiAuth, orderCRUDHandler.OrderByID,
+		models.PermOrdersPlace, models.PermOrdersRead, models.PermOrdersWrite, models.PermOrdersDelete)
 	mux.HandleFunc("GET /orders_api/", ordersByID)
 	mux.HandleFunc("POST /orders_api/", ordersByID)
 	mux.HandleFunc("PUT /orders_api/", ordersByID)
 	mux.HandleFunc("DELETE /orders_api/", ordersByID)
+	mux.HandleFunc("POST /orders_api/{id}/restore", middleware.Require(apiAuth, models.PermOrdersDelete, orderCRUDHandler.Restore))
+	mux.HandleFunc("POST /orders_api/{id}/transitions", middleware.Require(apiAuth, models.PermOrdersWrite, orderCRUDHandler.Transition))
+	mux.HandleFunc("GET /orders_api/{id}/payments", middleware.Scoped(apiAuth, paymentHandler.OrderPayments, models.PermOrdersPlace, models.PermOrdersRead))
+	mux.HandleFunc("POST /orders_api/{id}/payments", middleware.Scoped(apiAuth, paymentHandler.OrderPayments, models.PermOrdersPlace, models.PermOrdersWrite))
+	mux.HandleFunc("POST /orders_api/{id}/cancel", middleware.Scoped(apiAuth, refundHandler.Cancel, models.PermOrdersPlace, models.PermOrdersWrite))
+	mux.HandleFunc("GET /orders_api/{id}/refunds", middleware.Scoped(apiAuth, refundHandler.Refunds, models.PermOrdersPlace, models.PermOrdersRead))
+	mux.HandleFunc("POST /orders_api/{id}/refunds", middleware.Require(apiAuth, models.PermOrdersRefund, refundHandler.Refunds))
 
-	mux.HandleFunc("GET /wishlists_api", middleware.AuthOnly(secret, wishlistHandler.Wishlists))
-	mux.HandleFunc("POST /wishlists_api", middleware.AuthOnly(secret, wishlistHandler.Wishlists))
+	mux.HandleFunc("POST /payments/webhook", paymentHandler.Webhook)
+	if fakePayments != nil {
+		mux.HandleFunc("POST /payments/fake/{intent}/settle", middleware.Scoped(apiAuth, paymentHandler.FakeSettle, models.PermOrdersPlace, models.PermOrdersWrite))
+	}
 
-	wishlistsPrefixHandler := middleware.AuthOnly(secret, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /wishlists_api", middleware.Require(apiAuth, models.PermWishlistsOwn, wishlistHandler.Wishlists))
+	mux.HandleFunc("POST /wishlists_api", middleware.Require(apiAuth, models.PermWishlistsOwn, wishlistHandler.Wishlists))
+
+	wishlistsPrefixHandler := middleware.Require(apiAuth, models.PermWishlistsOwn, func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasSuffix(r.URL.Path, "/items") {
 			wishlistHandler.WishlistItems(w, r)
 			return
 		}
 		if strings.HasSuffix(r.URL.Path, "/gift") {
-			wishlistHandler.Gift(w, r)
+			idempotentAPI(wishlistHandler.Gift)(w, r)
 			return
 		}
 		wishlistHandler.WishlistByID(w, r)
@@ -143,4 +280,37 @@ func RegisterRoutes(mux *http.ServeMux, mongoDB *mongo.Database) {
 	mux.HandleFunc("POST /wishlists_api/", wishlistsPrefixHandler)
 	mux.HandleFunc("PUT /wishlists_api/", wishlistsPrefixHandler)
 	mux.HandleFunc("DELETE /wishlists_api/", wishlistsPrefixHandler)
+	mux.HandleFunc("POST /wishlists_api/{id}/restore", middleware.Require(apiAuth, models.PermWishlistsWrite, wishlistHandler.Restore))
+}
+
+func byContentType(jsonHandler, formHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			jsonHandler(w, r)
+			return
+		}
+		formHandler(w, r)
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q", name, v)
+	}
+	return d
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bookstore/internal/handlers"
+	"bookstore/internal/logic"
+	"bookstore/internal/middleware"
+	"bookstore/internal/payments"
+	"bookstore/internal/repository"
+)
+
+// newTestMux registers every route on in-memory stores.
+func newTestMux(t *testing.T) (*http.ServeMux, repository.Stores) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+
+	stores := repository.NewMemoryStores()
+	mux := http.NewServeMux()
+	RegisterRoutes(t.Context(), mux, stores, payments.NewFake("test-webhook-secret"))
+	return mux, stores
+}
+
+// register signs up email with the password "secret" through the API.
+func register(t *testing.T, mux http.Handler, email string) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/auth/register",
+		strings.NewReader(`{"email":"`+email+`","password":"secret"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status = %d, want 201", w.Code)
+	}
+}
+
+// newCSRFToken makes a token the CSRF middleware accepts as its own.
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// postForm submits a page form the way a browser holding csrf and cookies
+// would.
+func postForm(mux http.Handler, path, csrf string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	if csrf != "" {
+		form.Set(middleware.CSRFField, csrf)
+	}
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if csrf != "" {
+		r.AddCookie(&http.Cookie{Name: middleware.CSRFCookie, Value: csrf})
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestPageErrorStatus(t *testing.T) {
+	mux, _ := newTestMux(t)
+	csrf := newCSRFToken()
+
+	t.Run("csrf failure", func(t *testing.T) {
+		w := postForm(mux, "/login", "", url.Values{"email": {"a@example.com"}, "password": {"secret"}})
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("status = %d, want 403", w.Code)
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Fatalf("content type = %q, want the error page", ct)
+		}
+	})
+
+	t.Run("throttled login", func(t *testing.T) {
+		register(t, mux, "a@example.com")
+
+		// The failure that uses up the free attempts locks the account.
+		bad := url.Values{"email": {"a@example.com"}, "password": {"wrong"}}
+		for i := range logic.DefaultLockoutPolicy.FreeAttempts + 1 {
+			if w := postForm(mux, "/login", csrf, bad); w.Code != http.StatusOK {
+				t.Fatalf("attempt %d: status = %d, want 200", i+1, w.Code)
+			}
+		}
+
+		w := postForm(mux, "/login", csrf, bad)
+		if w.Code != http.StatusLocked {
+			t.Fatalf("status = %d, want 423", w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Fatal("throttled login page has no Retry-After")
+		}
+		if !strings.Contains(w.Body.String(), "Too many failed attempts") {
+			t.Fatal("throttled login page does not say why")
+		}
+	})
+}
+
+func TestAPIAuthErrorsAreJSON(t *testing.T) {
+	mux, _ := newTestMux(t)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	var body struct{ Code string }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Code != "unauthorized" {
+		t.Fatalf("body = %q, want a JSON error with code unauthorized", w.Body.String())
+	}
+}
+
+func TestPageActionErrors(t *testing.T) {
+	mux, stores := newTestMux(t)
+	csrf := newCSRFToken()
+	register(t, mux, "a@example.com")
+
+	w := postForm(mux, "/login", csrf, url.Values{"email": {"a@example.com"}, "password": {"secret"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("login: status = %d, want 303", w.Code)
+	}
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == handlers.TokenCookie {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("login set no session cookie")
+	}
+
+	t.Run("add a missing book to the cart", func(t *testing.T) {
+		w := postForm(mux, "/cart/add/999", csrf, url.Values{}, session)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400", w.Code)
+		}
+		if !strings.Contains(w.Body.String(), `class="alert"`) {
+			t.Fatal("cart page does not show the error")
+		}
+	})
+
+	t.Run("gift an empty wishlist", func(t *testing.T) {
+		u, _ := stores.Users.GetByEmail(t.Context(), "a@example.com")
+		wl := stores.Wishlists.Create(t.Context(), u.ID)
+		path := "/wishlists/gift/" + strconv.Itoa(wl.ID)
+
+		// The failure must not be stored against the key: a retry runs
+		// again instead of replaying it.
+		for attempt := 1; attempt <= 2; attempt++ {
+			w := postForm(mux, path, csrf, url.Values{middleware.IdempotencyField: {"gift-1"}}, session)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("attempt %d: status = %d, want 400", attempt, w.Code)
+			}
+			if w.Header().Get(middleware.ReplayedHeader) != "" {
+				t.Fatalf("attempt %d: failure was replayed", attempt)
+			}
+		}
+	})
+}